@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// alertNames are the Alertmanager alert names of each failure kind.
+var alertNames = map[string]string{
+	FailureRunAborted:        "KubeNsGcRunAborted",
+	FailureApproval:          "KubeNsGcApprovalFailed",
+	FailureNamespaceCleanup:  "KubeNsGcNamespaceCleanupFailed",
+	FailureHelmUninstall:     "KubeNsGcHelmUninstallFailed",
+	FailureHelmHook:          "KubeNsGcHelmHookFailed",
+	FailureHelmArchive:       "KubeNsGcHelmArchiveFailed",
+	FailureEscalated:         "KubeNsGcCleanupEscalated",
+	FailureStuckTerminating:  "KubeNsGcNamespaceStuckTerminating",
+	FailureFluxUnavailable:   "KubeNsGcFluxUnavailable",
+	FailureArgoCDUnavailable: "KubeNsGcArgoCDUnavailable",
+}
+
+// alertKey returns the dedup key of a failure event, or "" if the event is
+// not a failure. The key is stable across runs, so a failure that repeats
+// every run stays one alert.
+func alertKey(event NotificationEvent) string {
+	if event.Failure == "" || (event.Type != EventError && event.Type != EventWarning) {
+		return ""
+	}
+	parts := []string{"kube-ns-gc"}
+	if event.ClusterName != "" {
+		parts = append(parts, event.ClusterName)
+	}
+	parts = append(parts, event.Failure)
+	if event.Namespace != "" {
+		parts = append(parts, event.Namespace)
+	}
+	if event.Release != "" {
+		parts = append(parts, event.Release)
+	}
+	return strings.Join(parts, "/")
+}
+
+// alertSeverity maps the event type to the severity of the alert.
+func alertSeverity(event NotificationEvent) string {
+	if event.Type == EventWarning {
+		return "warning"
+	}
+	return "critical"
+}
+
+type trackedAlert struct {
+	Event NotificationEvent `json:"event"`
+	RunID string            `json:"run_id"`
+}
+
+// alertEvent keeps the fields of a failure event an alert is built from.
+func alertEvent(event NotificationEvent) NotificationEvent {
+	return NotificationEvent{
+		ID:          event.ID,
+		Type:        event.Type,
+		Time:        event.Time,
+		ClusterName: event.ClusterName,
+		RunID:       event.RunID,
+		Namespace:   event.Namespace,
+		Policy:      event.Policy,
+		Release:     event.Release,
+		Message:     event.Message,
+		Error:       event.Error,
+		Failure:     event.Failure,
+	}
+}
+
+// AlertStateConfig is the ConfigMap the firing alerts of a sink are kept in,
+// so that they are resolved after a restart too.
+type AlertStateConfig struct {
+	StateConfigMap string `json:"state_configmap"`
+	StateNamespace string `json:"state_namespace"`
+}
+
+// alertTracker remembers the firing alerts of a sink so they can be resolved
+// once a later cleanup run no longer reports the failure. Without a clientset
+// or state ConfigMap the state is kept in memory only.
+type alertTracker struct {
+	// key is the ConfigMap key of the sink
+	key       string
+	config    AlertStateConfig
+	clientset kubernetes.Interface
+	logger    *logrus.Logger
+
+	mu     sync.Mutex
+	loaded bool
+	firing map[string]trackedAlert
+}
+
+func newAlertTracker(key string, config AlertStateConfig, clientset kubernetes.Interface, logger *logrus.Logger) *alertTracker {
+	if clientset == nil {
+		config.StateConfigMap = ""
+	}
+	if config.StateConfigMap != "" && config.StateNamespace == "" {
+		config.StateNamespace = getEnvString("POD_NAMESPACE", "default")
+	}
+	return &alertTracker{
+		key:       key,
+		config:    config,
+		clientset: clientset,
+		logger:    logger,
+		firing:    make(map[string]trackedAlert),
+	}
+}
+
+// Fire records the failure event under key.
+func (t *alertTracker) Fire(key string, event NotificationEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.load()
+	_, known := t.firing[key]
+	t.firing[key] = trackedAlert{Event: alertEvent(event), RunID: event.RunID}
+	if !known {
+		t.save()
+	}
+}
+
+// EndRun forgets and returns the alerts that were not reported again during
+// run runID. Alerts about namespaces the run did not retry, because it
+// skipped them or failed them for another reason, stay firing.
+func (t *alertTracker) EndRun(runID string, report *CleanupReport) map[string]NotificationEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.load()
+	resolved := make(map[string]NotificationEvent)
+	for key, alert := range t.firing {
+		if runID != "" && alert.RunID == runID {
+			continue
+		}
+		if !alertRechecked(alert.Event, report) {
+			continue
+		}
+		resolved[key] = alert.Event
+		delete(t.firing, key)
+	}
+	if len(resolved) > 0 {
+		t.save()
+	}
+	return resolved
+}
+
+// alertRechecked reports whether the run of report retried what event failed
+// on. Failures of the run itself, and of namespaces the run went through
+// without skipping or failing them, were.
+func alertRechecked(event NotificationEvent, report *CleanupReport) bool {
+	if report == nil || event.Namespace == "" {
+		return true
+	}
+	for _, skipped := range report.Skipped {
+		if skipped.Name == event.Namespace {
+			return false
+		}
+	}
+	for _, failed := range report.Failed {
+		if failed.Name == event.Namespace {
+			return false
+		}
+	}
+	return true
+}
+
+// load reads the state on first use, keeping alerts fired since. The caller
+// must hold t.mu. A failed load is retried on the next call.
+func (t *alertTracker) load() {
+	if t.loaded || t.config.StateConfigMap == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cm, err := t.clientset.CoreV1().ConfigMaps(t.config.StateNamespace).Get(ctx, t.config.StateConfigMap, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		t.logger.Warnf("Failed to get %s alert state: %v", t.key, err)
+		return
+	}
+	t.loaded = true
+	if err != nil {
+		return
+	}
+
+	var firing map[string]trackedAlert
+	if data := cm.Data[t.key+".json"]; data != "" {
+		if err := json.Unmarshal([]byte(data), &firing); err != nil {
+			t.logger.Warnf("Failed to parse %s alert state: %v", t.key, err)
+			return
+		}
+	}
+	for key, alert := range firing {
+		if _, ok := t.firing[key]; !ok {
+			t.firing[key] = alert
+		}
+	}
+	t.logger.Infof("Loaded %d firing %s alerts", len(firing), t.key)
+}
+
+// save writes the state. The caller must hold t.mu. Failures are logged, the
+// alerts are still delivered.
+func (t *alertTracker) save() {
+	if t.config.StateConfigMap == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := t.write(ctx); err != nil {
+		t.logger.Warnf("Failed to save %s alert state: %v", t.key, err)
+	}
+}
+
+func (t *alertTracker) write(ctx context.Context) error {
+	data, err := json.Marshal(t.firing)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert state: %v", err)
+	}
+
+	configMaps := t.clientset.CoreV1().ConfigMaps(t.config.StateNamespace)
+	cm, err := configMaps.Get(ctx, t.config.StateConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      t.config.StateConfigMap,
+				Namespace: t.config.StateNamespace,
+			},
+			Data: map[string]string{t.key + ".json": string(data)},
+		}
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create alert state: %v", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get alert state: %v", err)
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[t.key+".json"] = string(data)
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update alert state: %v", err)
+	}
+	return nil
+}
+
+// sortedAlertKeys returns the keys of alerts in order.
+func sortedAlertKeys(alerts map[string]NotificationEvent) []string {
+	keys := make([]string, 0, len(alerts))
+	for key := range alerts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAlertKey(t *testing.T) {
+	tests := []struct {
+		event    NotificationEvent
+		expected string
+	}{
+		{NotificationEvent{Type: EventError, ClusterName: "prod", RunID: "run-1", Failure: FailureRunAborted}, "kube-ns-gc/prod/run_aborted"},
+		{NotificationEvent{Type: EventError, Namespace: "preview-1", Failure: FailureNamespaceCleanup}, "kube-ns-gc/namespace_cleanup/preview-1"},
+		{NotificationEvent{Type: EventWarning, Namespace: "preview-1", Release: "api", Failure: FailureHelmUninstall}, "kube-ns-gc/helm_uninstall/preview-1/api"},
+		{NotificationEvent{Type: EventError, Message: "untagged"}, ""},
+		{NotificationEvent{Type: EventNamespaceDeleted, Namespace: "preview-1", Failure: FailureNamespaceCleanup}, ""},
+	}
+
+	for _, test := range tests {
+		if key := alertKey(test.event); key != test.expected {
+			t.Errorf("alertKey(%+v) = %q, expected %q", test.event, key, test.expected)
+		}
+	}
+}
+
+// runEvents simulates two cleanup runs: preview-1 fails in both, preview-2
+// only in the first.
+func runEvents() []NotificationEvent {
+	failure := func(runID, namespace string) NotificationEvent {
+		return NotificationEvent{
+			Type:      EventError,
+			Time:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			RunID:     runID,
+			Namespace: namespace,
+			Message:   "Failed to clean up namespace " + namespace,
+			Error:     "timeout waiting for namespace deletion",
+			Failure:   FailureNamespaceCleanup,
+		}
+	}
+	return []NotificationEvent{
+		failure("run-1", "preview-1"),
+		failure("run-1", "preview-2"),
+		{Type: EventCleanupSummary, RunID: "run-1"},
+		failure("run-2", "preview-1"),
+		{Type: EventCleanupSummary, RunID: "run-2"},
+	}
+}
+
+func TestAlertmanagerResolvesClearedFailures(t *testing.T) {
+	hook := newFakeWebhook(t)
+	notifier, err := NewAlertmanagerNotifier(&AlertmanagerConfig{
+		URL:         hook.server.URL,
+		Labels:      map[string]string{"team": "platform"},
+		RetryConfig: RetryConfig{InitialBackoff: time.Millisecond},
+	}, nil, logrus.New())
+	if err != nil {
+		t.Fatalf("NewAlertmanagerNotifier failed: %v", err)
+	}
+
+	for _, event := range runEvents() {
+		if err := notifier.Notify(event); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+	}
+	closeNotifier(t, notifier)
+
+	requests := hook.received()
+	if len(requests) != 4 {
+		t.Fatalf("Expected 3 firing posts and 1 resolve post, got %d requests", len(requests))
+	}
+
+	var firing []AlertmanagerAlert
+	if err := json.Unmarshal(requests[0].Body, &firing); err != nil {
+		t.Fatalf("Invalid alerts: %v", err)
+	}
+	labels := firing[0].Labels
+	if labels["alertname"] != "KubeNsGcNamespaceCleanupFailed" || labels["namespace"] != "preview-1" || labels["team"] != "platform" || labels["severity"] != "critical" {
+		t.Errorf("Unexpected labels: %v", labels)
+	}
+	if !firing[0].EndsAt.After(firing[0].StartsAt) {
+		t.Errorf("Expected firing alert to end in the future, got %+v", firing[0])
+	}
+
+	var resolved []AlertmanagerAlert
+	if err := json.Unmarshal(requests[3].Body, &resolved); err != nil {
+		t.Fatalf("Invalid alerts: %v", err)
+	}
+	if len(resolved) != 1 || resolved[0].Labels["namespace"] != "preview-2" || resolved[0].EndsAt.After(time.Now()) {
+		t.Errorf("Expected preview-2 to be resolved, got %+v", resolved)
+	}
+}
+
+func TestPagerDutyResolvesClearedFailures(t *testing.T) {
+	hook := newFakeWebhook(t)
+	notifier, err := NewPagerDutyNotifier(&PagerDutyConfig{
+		RoutingKey:  "routing-key",
+		APIURL:      hook.server.URL,
+		RetryConfig: RetryConfig{InitialBackoff: time.Millisecond},
+	}, nil, logrus.New())
+	if err != nil {
+		t.Fatalf("NewPagerDutyNotifier failed: %v", err)
+	}
+
+	for _, event := range runEvents() {
+		_ = notifier.Notify(event)
+	}
+	closeNotifier(t, notifier)
+
+	requests := hook.received()
+	var events []PagerDutyEvent
+	for _, request := range requests {
+		var event PagerDutyEvent
+		if err := json.Unmarshal(request.Body, &event); err != nil {
+			t.Fatalf("Invalid event: %v", err)
+		}
+		events = append(events, event)
+	}
+
+	actions := ""
+	for _, event := range events {
+		actions += event.EventAction + " "
+	}
+	if actions != "trigger trigger trigger resolve " {
+		t.Fatalf("Unexpected event actions: %s", actions)
+	}
+	if events[2].DedupKey != events[0].DedupKey || events[3].DedupKey != "kube-ns-gc/namespace_cleanup/preview-2" {
+		t.Errorf("Unexpected dedup keys: %q, %q", events[2].DedupKey, events[3].DedupKey)
+	}
+	if payload := events[0].Payload; payload.Source != "kube-ns-gc" || payload.Class != FailureNamespaceCleanup || payload.Group != "preview-1" || events[0].RoutingKey != "routing-key" {
+		t.Errorf("Unexpected trigger: %+v", events[0])
+	}
+}
+
+func TestAlertTrackerPersistsAndKeepsSkippedNamespaces(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	config := AlertStateConfig{StateConfigMap: "kube-ns-gc-alerts", StateNamespace: "kube-ns-gc"}
+	failure := func(namespace string) NotificationEvent {
+		return NotificationEvent{Type: EventError, RunID: "run-1", Namespace: namespace, Failure: FailureNamespaceCleanup, Message: "Failed to clean up namespace " + namespace}
+	}
+
+	tracker := newAlertTracker("alertmanager", config, clientset, logrus.New())
+	for _, namespace := range []string{"preview-1", "preview-2", "preview-3"} {
+		tracker.Fire(alertKey(failure(namespace)), failure(namespace))
+	}
+
+	// A restarted tracker still knows the firing alerts
+	tracker = newAlertTracker("alertmanager", config, clientset, logrus.New())
+	report := NewCleanupReport(time.Now())
+	report.AddSkipped("preview-1", "waiting for approval")
+	report.AddFailed("preview-2", fmt.Errorf("failed to request approval"))
+	resolved := tracker.EndRun("run-2", report)
+	if len(resolved) != 1 || resolved["kube-ns-gc/namespace_cleanup/preview-3"].Message != "Failed to clean up namespace preview-3" {
+		t.Errorf("Expected only preview-3 to be resolved, got %+v", resolved)
+	}
+
+	tracker = newAlertTracker("alertmanager", config, clientset, logrus.New())
+	if resolved := tracker.EndRun("run-3", NewCleanupReport(time.Now())); len(resolved) != 2 {
+		t.Errorf("Expected the skipped and failed namespaces to stay firing until retried, got %+v", resolved)
+	}
+}
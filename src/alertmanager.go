@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AlertmanagerConfig configures the Alertmanager sink, which pushes failures
+// to the v2 alerts API.
+type AlertmanagerConfig struct {
+	Enabled bool `json:"enabled"`
+	// URL is the Alertmanager base URL, e.g. http://alertmanager:9093
+	URL string `json:"url"`
+	// Labels are added to every alert
+	Labels  map[string]string `json:"labels"`
+	Headers map[string]string `json:"headers"`
+	// ResolveTimeout is how long a firing alert lasts without being reported
+	// again. It should be longer than the cleanup interval.
+	ResolveTimeout time.Duration `json:"resolve_timeout"`
+	Timeout        time.Duration `json:"timeout"`
+	RetryConfig
+	AlertStateConfig
+}
+
+// AlertmanagerAlert is an alert of the Alertmanager v2 API.
+type AlertmanagerAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt,omitempty"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// AlertmanagerNotifier fires an alert per failure and resolves it after a
+// cleanup run that no longer reports the failure.
+type AlertmanagerNotifier struct {
+	config  *AlertmanagerConfig
+	logger  *logrus.Logger
+	client  *http.Client
+	queue   *deliveryQueue
+	tracker *alertTracker
+}
+
+func NewAlertmanagerNotifier(config *AlertmanagerConfig, clientset kubernetes.Interface, logger *logrus.Logger) (*AlertmanagerNotifier, error) {
+	endpoint, err := url.Parse(config.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid alertmanager url %q", config.URL)
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &AlertmanagerNotifier{
+		config:  config,
+		logger:  logger,
+		client:  &http.Client{Timeout: timeout},
+		queue:   newDeliveryQueue("alertmanager", config.RetryConfig, logger),
+		tracker: newAlertTracker("alertmanager", config.AlertStateConfig, clientset, logger),
+	}, nil
+}
+
+func (a *AlertmanagerNotifier) Notify(event NotificationEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if event.Type == EventCleanupSummary {
+		resolved := a.tracker.EndRun(event.RunID, event.Report)
+		if len(resolved) == 0 {
+			return nil
+		}
+		alerts := make([]AlertmanagerAlert, 0, len(resolved))
+		for _, key := range sortedAlertKeys(resolved) {
+			alert := a.alert(resolved[key])
+			alert.EndsAt = event.Time.UTC()
+			alerts = append(alerts, alert)
+		}
+		return a.post(fmt.Sprintf("%d resolved alerts", len(alerts)), alerts)
+	}
+
+	key := alertKey(event)
+	if key == "" {
+		return nil
+	}
+	a.tracker.Fire(key, event)
+	return a.post(key+" alert", []AlertmanagerAlert{a.alert(event)})
+}
+
+func (a *AlertmanagerNotifier) Close(ctx context.Context) error {
+	return a.queue.Close(ctx)
+}
+
+// alert returns the firing alert of a failure event. Resolving sends the same
+// labels with EndsAt set to now.
+func (a *AlertmanagerNotifier) alert(event NotificationEvent) AlertmanagerAlert {
+	labels := map[string]string{
+		"alertname": alertNames[event.Failure],
+		"service":   "kube-ns-gc",
+		"failure":   event.Failure,
+		"severity":  alertSeverity(event),
+	}
+	if labels["alertname"] == "" {
+		labels["alertname"] = "KubeNsGcFailure"
+	}
+	if event.ClusterName != "" {
+		labels["cluster"] = event.ClusterName
+	}
+	if event.Namespace != "" {
+		labels["namespace"] = event.Namespace
+	}
+	if event.Release != "" {
+		labels["release"] = event.Release
+	}
+	for name, value := range a.config.Labels {
+		labels[name] = value
+	}
+
+	annotations := map[string]string{"summary": event.Message}
+	if event.Error != "" {
+		annotations["description"] = event.Error
+	}
+	if event.RunID != "" {
+		annotations["run_id"] = event.RunID
+	}
+
+	resolveTimeout := a.config.ResolveTimeout
+	if resolveTimeout <= 0 {
+		resolveTimeout = 48 * time.Hour
+	}
+	return AlertmanagerAlert{
+		Labels:      labels,
+		Annotations: annotations,
+		StartsAt:    event.Time.UTC(),
+		EndsAt:      event.Time.Add(resolveTimeout).UTC(),
+	}
+}
+
+func (a *AlertmanagerNotifier) post(description string, alerts []AlertmanagerAlert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alerts: %v", err)
+	}
+	endpoint := strings.TrimSuffix(a.config.URL, "/") + "/api/v2/alerts"
+	return a.queue.Enqueue(description, func(ctx context.Context) error {
+		return postJSON(ctx, a.client, endpoint, body, a.config.Headers, nil)
+	})
+}
@@ -0,0 +1,640 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	ApprovalActionApprove  = "approve"
+	ApprovalActionPostpone = "postpone"
+	ApprovalActionProtect  = "protect"
+
+	// PostponedUntilAnnotation holds an RFC3339 timestamp before which a namespace is not collected.
+	PostponedUntilAnnotation = "kube-ns-gc/postponed-until"
+
+	approvalStateKey = "approvals.json"
+
+	// approvalTimeoutActor decides approvals nobody decided on in time
+	approvalTimeoutActor = "timeout"
+	// approvalPruneInterval is how often approvals of deleted namespaces are dropped
+	approvalPruneInterval = 10 * time.Minute
+)
+
+type ApprovalConfig struct {
+	Enabled        bool          `json:"enabled"`
+	Selector       string        `json:"selector"`
+	Timeout        time.Duration `json:"timeout"`
+	DefaultAction  string        `json:"default_action"`
+	PostponeFor    time.Duration `json:"postpone_for"`
+	AllowedUsers   []string      `json:"allowed_users"`
+	StateConfigMap string        `json:"state_configmap"`
+	StateNamespace string        `json:"state_namespace"`
+}
+
+type PendingApproval struct {
+	ID          string    `json:"id"`
+	Namespace   string    `json:"namespace"`
+	MessageID   int       `json:"message_id"`
+	RequestedAt time.Time `json:"requested_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	Approved    bool      `json:"approved"`
+	DecidedBy   string    `json:"decided_by,omitempty"`
+	// Attempts and RetryAt back off a default action that failed to apply
+	Attempts int       `json:"attempts,omitempty"`
+	RetryAt  time.Time `json:"retry_at,omitempty"`
+
+	// deciding is the postpone or protect decision being applied, and
+	// decidingBy who made it. A decision in flight dies with the process.
+	deciding   string
+	decidingBy string
+}
+
+// AuditActor returns who decided on the approval.
+func (a *PendingApproval) AuditActor() AuditActor {
+	return approvalAuditActor(a.DecidedBy)
+}
+
+// approvalAuditActor returns the audit actor of a decision made by actor.
+func approvalAuditActor(actor string) AuditActor {
+	if actor == approvalTimeoutActor {
+		return AuditActor{Type: AuditActorScheduler, Name: "approval timeout"}
+	}
+	return AuditActor{Type: AuditActorTelegram, Name: actor}
+}
+
+type approvalState struct {
+	Offset    int                         `json:"offset"`
+	Approvals map[string]*PendingApproval `json:"approvals"`
+}
+
+// ApprovalDecisionFunc applies a postpone or protect decision made by actor to
+// the namespace behind an approval, and records an approval. Approved
+// deletions are left to the next cleanup run, which finds them through Check.
+type ApprovalDecisionFunc func(approval *PendingApproval, action, actor string) error
+
+// PermanentDecisionError is a decision error retrying cannot fix, such as a
+// deleted namespace. The approval is dropped instead of retried.
+type PermanentDecisionError struct {
+	Err error
+}
+
+func (e *PermanentDecisionError) Error() string {
+	return e.Err.Error()
+}
+
+const (
+	decisionRetryDelay    = 30 * time.Second
+	decisionMaxRetryDelay = time.Hour
+)
+
+// decisionBackoff returns the backoff after attempts failed decisions.
+func decisionBackoff(attempts int) time.Duration {
+	delay := decisionRetryDelay
+	for i := 1; i < attempts && delay < decisionMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > decisionMaxRetryDelay {
+		delay = decisionMaxRetryDelay
+	}
+	return delay
+}
+
+// ApprovalManager asks for a human decision in Telegram before a namespace is collected.
+// Pending approvals are persisted in a ConfigMap so they survive restarts.
+type ApprovalManager struct {
+	config     *ApprovalConfig
+	clientset  kubernetes.Interface
+	telegram   *TelegramClient
+	logger     *logrus.Logger
+	selector   labels.Selector
+	onDecision ApprovalDecisionFunc
+
+	mu       sync.Mutex
+	loaded   bool
+	prunedAt time.Time
+	state    approvalState
+}
+
+func NewApprovalManager(config *ApprovalConfig, clientset kubernetes.Interface, telegram *TelegramClient, logger *logrus.Logger, onDecision ApprovalDecisionFunc) (*ApprovalManager, error) {
+	if telegram == nil || !telegram.Configured() {
+		return nil, fmt.Errorf("approval mode requires Telegram to be enabled and configured")
+	}
+
+	switch config.DefaultAction {
+	case ApprovalActionApprove, ApprovalActionPostpone, ApprovalActionProtect:
+	default:
+		return nil, fmt.Errorf("invalid approval default action %q", config.DefaultAction)
+	}
+
+	selector, err := labels.Parse(config.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid approval selector %q: %v", config.Selector, err)
+	}
+
+	if config.StateNamespace == "" {
+		config.StateNamespace = getEnvString("POD_NAMESPACE", "default")
+	}
+
+	return &ApprovalManager{
+		config:     config,
+		clientset:  clientset,
+		telegram:   telegram,
+		logger:     logger,
+		selector:   selector,
+		onDecision: onDecision,
+		state:      approvalState{Approvals: map[string]*PendingApproval{}},
+	}, nil
+}
+
+// Required reports whether deleting the namespace needs an approval.
+func (m *ApprovalManager) Required(ns *v1.Namespace) bool {
+	return m.selector.Matches(labels.Set(ns.Labels))
+}
+
+// Check returns the approval once the namespace deletion has been approved,
+// or nil. If no approval is pending yet, a request is sent to Telegram. Until
+// the persisted approvals are loaded, Check fails instead of asking again.
+func (m *ApprovalManager) Check(ctx context.Context, ns *v1.Namespace) (*PendingApproval, error) {
+	id := string(ns.UID)
+
+	m.mu.Lock()
+	if !m.loaded {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("approval state is not loaded yet")
+	}
+	if approval, ok := m.state.Approvals[id]; ok {
+		var snapshot *PendingApproval
+		if approval.Approved {
+			copied := *approval
+			snapshot = &copied
+		}
+		m.mu.Unlock()
+		return snapshot, nil
+	}
+	m.mu.Unlock()
+
+	// The request is sent without the lock, the Telegram queue may hold it
+	now := time.Now()
+	approval := &PendingApproval{
+		ID:          id,
+		Namespace:   ns.Name,
+		RequestedAt: now,
+		ExpiresAt:   now.Add(m.config.Timeout),
+	}
+
+	messageID, err := m.telegram.SendApprovalRequest(ctx, id, ns.Name, now.Sub(ns.CreationTimestamp.Time), approval.ExpiresAt, m.config.DefaultAction, m.config.PostponeFor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send approval request: %v", err)
+	}
+	approval.MessageID = messageID
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state.Approvals[id] = approval
+	if err := m.save(ctx); err != nil {
+		return nil, err
+	}
+
+	m.logger.Infof("Requested approval to delete namespace %s", ns.Name)
+	return nil, nil
+}
+
+// Complete forgets an approval once its namespace has been handled.
+func (m *ApprovalManager) Complete(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.state.Approvals[id]; !ok {
+		return nil
+	}
+	delete(m.state.Approvals, id)
+	return m.save(ctx)
+}
+
+// Load loads the persisted approvals. Run retries until it succeeds.
+func (m *ApprovalManager) Load(ctx context.Context) error {
+	m.mu.Lock()
+	loaded := m.loaded
+	m.mu.Unlock()
+	if loaded {
+		return nil
+	}
+	return m.load(ctx)
+}
+
+// Run loads the persisted state and then polls Telegram for button presses and
+// expires pending approvals until the context is cancelled.
+func (m *ApprovalManager) Run(ctx context.Context) {
+	for {
+		if err := m.Load(ctx); err == nil {
+			break
+		} else {
+			m.logger.Errorf("Failed to load approval state: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			m.logger.Info("Approval routine stopped")
+			return
+		default:
+		}
+
+		m.prune(ctx)
+		m.expire(ctx)
+
+		m.mu.Lock()
+		offset := m.state.Offset
+		m.mu.Unlock()
+
+		updates, err := m.telegram.GetUpdates(ctx, offset, 25*time.Second)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+			m.logger.Warnf("Failed to poll Telegram updates: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+
+		for _, update := range updates {
+			m.mu.Lock()
+			if update.UpdateID >= m.state.Offset {
+				m.state.Offset = update.UpdateID + 1
+			}
+			m.mu.Unlock()
+
+			if update.CallbackQuery != nil {
+				m.handleCallback(ctx, update.CallbackQuery)
+			}
+		}
+
+		if len(updates) > 0 {
+			m.mu.Lock()
+			if err := m.save(ctx); err != nil {
+				m.logger.Warnf("Failed to persist approval state: %v", err)
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (m *ApprovalManager) handleCallback(ctx context.Context, query *TelegramCallbackQuery) {
+	action, id, found := strings.Cut(query.Data, ":")
+	if !found {
+		return
+	}
+
+	actor := query.From.DisplayName()
+	if query.Message == nil || !m.telegram.IsConfiguredChat(query.Message.Chat) {
+		m.logger.Warnf("Ignoring approval decision by %s from another chat", actor)
+		m.answer(ctx, query.ID, "Decisions are only accepted in the approval chat")
+		return
+	}
+	if !m.userAllowed(query.From) {
+		m.logger.Warnf("Ignoring approval decision from unauthorized user %s", actor)
+		m.answer(ctx, query.ID, "You are not allowed to decide on deletions")
+		return
+	}
+
+	m.mu.Lock()
+	approval, ok := m.state.Approvals[id]
+	if ok && approval.deciding != "" {
+		decision := fmt.Sprintf("This request is no longer pending: %s by %s", approval.deciding, approval.decidingBy)
+		m.mu.Unlock()
+		m.answer(ctx, query.ID, decision)
+		return
+	}
+	if !ok || approval.Approved {
+		m.mu.Unlock()
+		m.answer(ctx, query.ID, "This request is no longer pending")
+		return
+	}
+	switch action {
+	case ApprovalActionApprove:
+		approval.Approved = true
+		approval.DecidedBy = actor
+		if err := m.save(ctx); err != nil {
+			m.logger.Warnf("Failed to persist approval state: %v", err)
+		}
+	case ApprovalActionPostpone, ApprovalActionProtect:
+		// Later presses find the decision in flight
+		approval.deciding = action
+		approval.decidingBy = actor
+	}
+	snapshot := *approval
+	m.mu.Unlock()
+
+	switch action {
+	case ApprovalActionApprove:
+		m.answer(ctx, query.ID, "Decision recorded: "+action)
+		m.decide(ctx, &snapshot, action, actor)
+	case ApprovalActionPostpone, ApprovalActionProtect:
+		m.answer(ctx, query.ID, "Decision recorded: "+action)
+		go m.decide(context.Background(), &snapshot, action, actor)
+	default:
+		m.answer(ctx, query.ID, "Unknown action")
+	}
+}
+
+// expire applies the default action to approvals that nobody decided on in time.
+func (m *ApprovalManager) expire(ctx context.Context) {
+	now := time.Now()
+
+	m.mu.Lock()
+	var expired []PendingApproval
+	for _, approval := range m.state.Approvals {
+		if !approval.Approved && approval.deciding == "" && now.After(approval.ExpiresAt) && !now.Before(approval.RetryAt) {
+			if m.config.DefaultAction == ApprovalActionApprove {
+				approval.Approved = true
+				approval.DecidedBy = approvalTimeoutActor
+			}
+			expired = append(expired, *approval)
+		}
+	}
+	if len(expired) > 0 && m.config.DefaultAction == ApprovalActionApprove {
+		if err := m.save(ctx); err != nil {
+			m.logger.Warnf("Failed to persist approval state: %v", err)
+		}
+	}
+	m.mu.Unlock()
+
+	for i := range expired {
+		m.logger.Infof("Approval for namespace %s expired, applying default action %s", expired[i].Namespace, m.config.DefaultAction)
+		m.decide(ctx, &expired[i], m.config.DefaultAction, approvalTimeoutActor)
+	}
+}
+
+// prune drops the approvals of namespaces that were deleted, or recreated,
+// elsewhere. They would never be completed by a cleanup run.
+func (m *ApprovalManager) prune(ctx context.Context) {
+	m.mu.Lock()
+	due := time.Since(m.prunedAt) >= approvalPruneInterval && len(m.state.Approvals) > 0
+	m.mu.Unlock()
+	if !due {
+		return
+	}
+
+	namespaces, err := m.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		m.logger.Warnf("Failed to list namespaces to prune approvals: %v", err)
+		return
+	}
+	uids := make(map[string]bool, len(namespaces.Items))
+	for _, ns := range namespaces.Items {
+		uids[string(ns.UID)] = true
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prunedAt = time.Now()
+
+	pruned := 0
+	for id, approval := range m.state.Approvals {
+		if !uids[id] {
+			m.logger.Infof("Dropping approval for namespace %s, it no longer exists", approval.Namespace)
+			delete(m.state.Approvals, id)
+			pruned++
+		}
+	}
+	if pruned > 0 {
+		if err := m.save(ctx); err != nil {
+			m.logger.Warnf("Failed to persist approval state: %v", err)
+		}
+	}
+}
+
+// decide applies a decision. An approval only marks the namespace, the next
+// cleanup run deletes it and completes the approval, so that deletions always
+// run inside a run and never twice at once.
+func (m *ApprovalManager) decide(ctx context.Context, approval *PendingApproval, action, actor string) {
+	if action == ApprovalActionApprove {
+		m.logger.Infof("Deletion of namespace %s approved by %s, deleting it in the next cleanup run", approval.Namespace, actor)
+		if err := m.onDecision(approval, action, actor); err != nil {
+			m.logger.Warnf("Failed to record approval of namespace %s: %v", approval.Namespace, err)
+		}
+		renderer := func(f telegramFormatter) string {
+			return f.Title(approvalActionIcon(action), fmt.Sprintf("%s by %s", approvalActionTitle(action), actor)) + "\n\n" +
+				f.Field("📦", "Namespace", f.Code(approval.Namespace)) + "\n" +
+				f.Field("🗑️", "Deletion", f.Text("in the next cleanup run"))
+		}
+		if err := m.telegram.EditMessage(ctx, approval.MessageID, renderer); err != nil {
+			m.logger.Warnf("Failed to edit approval message: %v", err)
+		}
+		return
+	}
+
+	var renderer telegramRenderer
+	if err := m.onDecision(approval, action, actor); err != nil {
+		if _, permanent := err.(*PermanentDecisionError); !permanent {
+			// Leave the request and its buttons in place so it can be retried
+			delay := m.retryLater(ctx, approval.ID)
+			m.logger.Errorf("Failed to apply %s decision for namespace %s, retrying in %s: %v", action, approval.Namespace, delay, err)
+			return
+		}
+		m.logger.Errorf("Dropping %s decision for namespace %s: %v", action, approval.Namespace, err)
+		renderer = func(f telegramFormatter) string {
+			return f.Title("❌", fmt.Sprintf("%s by %s failed", approvalActionTitle(action), actor)) + "\n\n" +
+				f.Field("📦", "Namespace", f.Code(approval.Namespace)) + "\n" +
+				f.Field("🔍", "Error", f.Code(err.Error()))
+		}
+	} else {
+		renderer = func(f telegramFormatter) string {
+			return f.Title(approvalActionIcon(action), fmt.Sprintf("%s by %s", approvalActionTitle(action), actor)) + "\n\n" +
+				f.Field("📦", "Namespace", f.Code(approval.Namespace)) + "\n" +
+				f.Field("🕐", "Time", f.Text(time.Now().Format("2006-01-02 15:04:05 MST")))
+		}
+	}
+
+	if err := m.Complete(ctx, approval.ID); err != nil {
+		m.logger.Warnf("Failed to persist approval state: %v", err)
+	}
+
+	if err := m.telegram.EditMessage(ctx, approval.MessageID, renderer); err != nil {
+		m.logger.Warnf("Failed to edit approval message: %v", err)
+	}
+}
+
+// retryLater backs off the next attempt to apply the default action of an
+// approval, reopens it for decisions and returns the delay.
+func (m *ApprovalManager) retryLater(ctx context.Context, id string) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	approval, ok := m.state.Approvals[id]
+	if !ok {
+		return 0
+	}
+	approval.deciding = ""
+	approval.decidingBy = ""
+	approval.Attempts++
+	delay := decisionBackoff(approval.Attempts)
+	approval.RetryAt = time.Now().Add(delay)
+	if err := m.save(ctx); err != nil {
+		m.logger.Warnf("Failed to persist approval state: %v", err)
+	}
+	return delay
+}
+
+func (m *ApprovalManager) userAllowed(user *TelegramUser) bool {
+	if len(m.config.AllowedUsers) == 0 {
+		return true
+	}
+	if user == nil {
+		return false
+	}
+	for _, allowed := range m.config.AllowedUsers {
+		allowed = strings.TrimPrefix(allowed, "@")
+		if allowed == user.Username || allowed == fmt.Sprintf("%d", user.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *ApprovalManager) answer(ctx context.Context, callbackID, text string) {
+	if err := m.telegram.AnswerCallbackQuery(ctx, callbackID, text); err != nil {
+		m.logger.Debugf("Failed to answer callback query: %v", err)
+	}
+}
+
+func (m *ApprovalManager) load(ctx context.Context) error {
+	cm, err := m.clientset.CoreV1().ConfigMaps(m.config.StateNamespace).Get(ctx, m.config.StateConfigMap, metav1.GetOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to get approval state: %v", err)
+	}
+
+	// Without a ConfigMap nothing was persisted yet
+	var state approvalState
+	if err == nil && cm.Data[approvalStateKey] != "" {
+		if err := json.Unmarshal([]byte(cm.Data[approvalStateKey]), &state); err != nil {
+			return fmt.Errorf("failed to parse approval state: %v", err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.merge(state)
+	m.loaded = true
+
+	m.logger.Infof("Loaded %d pending approvals", len(state.Approvals))
+	return nil
+}
+
+// merge adds the persisted approvals to the state. Approvals already in the
+// state are newer and kept. The caller must hold m.mu.
+func (m *ApprovalManager) merge(state approvalState) {
+	if m.state.Approvals == nil {
+		m.state.Approvals = map[string]*PendingApproval{}
+	}
+	if state.Offset > m.state.Offset {
+		m.state.Offset = state.Offset
+	}
+	for id, approval := range state.Approvals {
+		if _, ok := m.state.Approvals[id]; !ok {
+			m.state.Approvals[id] = approval
+		}
+	}
+}
+
+// save writes the state to the ConfigMap. The caller must hold m.mu.
+func (m *ApprovalManager) save(ctx context.Context) error {
+	data, err := json.Marshal(m.state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal approval state: %v", err)
+	}
+
+	configMaps := m.clientset.CoreV1().ConfigMaps(m.config.StateNamespace)
+	cm, err := configMaps.Get(ctx, m.config.StateConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      m.config.StateConfigMap,
+				Namespace: m.config.StateNamespace,
+			},
+			Data: map[string]string{approvalStateKey: string(data)},
+		}
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create approval state: %v", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get approval state: %v", err)
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[approvalStateKey] = string(data)
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update approval state: %v", err)
+	}
+	return nil
+}
+
+func approvalActionTitle(action string) string {
+	switch action {
+	case ApprovalActionApprove:
+		return "Approved"
+	case ApprovalActionPostpone:
+		return "Postponed"
+	case ApprovalActionProtect:
+		return "Protected"
+	}
+	return action
+}
+
+func approvalActionIcon(action string) string {
+	switch action {
+	case ApprovalActionApprove:
+		return "✅"
+	case ApprovalActionPostpone:
+		return "⏸️"
+	case ApprovalActionProtect:
+		return "🛡️"
+	}
+	return "ℹ️"
+}
+
+// postponedUntil returns the time a namespace was postponed to, if any.
+func postponedUntil(ns *v1.Namespace) (time.Time, bool) {
+	value, ok := ns.Annotations[PostponedUntilAnnotation]
+	if !ok {
+		return time.Time{}, false
+	}
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return until, true
+}
+
+func shortDuration(d time.Duration) string {
+	if d >= 24*time.Hour && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
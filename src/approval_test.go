@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestApprovalManager(t *testing.T, config *ApprovalConfig) *ApprovalManager {
+	telegram := NewTelegramClient(&TelegramConfig{
+		Enabled:  true,
+		BotToken: "test-token",
+		ChatID:   "test-chat-id",
+	}, logrus.New())
+
+	manager, err := NewApprovalManager(config, fake.NewSimpleClientset(), telegram, logrus.New(), func(*PendingApproval, string, string) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected approval manager to be created, got %v", err)
+	}
+	return manager
+}
+
+func TestNewApprovalManagerValidation(t *testing.T) {
+	logger := logrus.New()
+	disabled := NewTelegramClient(&TelegramConfig{Enabled: false}, logger)
+	if _, err := NewApprovalManager(&ApprovalConfig{DefaultAction: ApprovalActionPostpone}, fake.NewSimpleClientset(), disabled, logger, nil); err == nil {
+		t.Error("Expected error when Telegram is disabled")
+	}
+
+	enabled := NewTelegramClient(&TelegramConfig{Enabled: true, BotToken: "token", ChatID: "chat"}, logger)
+	if _, err := NewApprovalManager(&ApprovalConfig{DefaultAction: "delete-everything"}, fake.NewSimpleClientset(), enabled, logger, nil); err == nil {
+		t.Error("Expected error for invalid default action")
+	}
+
+	if _, err := NewApprovalManager(&ApprovalConfig{DefaultAction: ApprovalActionProtect, Selector: "env in (("}, fake.NewSimpleClientset(), enabled, logger, nil); err == nil {
+		t.Error("Expected error for invalid selector")
+	}
+}
+
+func TestApprovalRequired(t *testing.T) {
+	manager := newTestApprovalManager(t, &ApprovalConfig{
+		DefaultAction: ApprovalActionPostpone,
+		Selector:      "sensitive=true",
+	})
+
+	sensitive := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "a", Labels: map[string]string{"sensitive": "true"}}}
+	if !manager.Required(sensitive) {
+		t.Error("Expected approval to be required for matching namespace")
+	}
+
+	regular := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "b"}}
+	if manager.Required(regular) {
+		t.Error("Expected approval not to be required for non-matching namespace")
+	}
+}
+
+func TestApprovalStatePersistence(t *testing.T) {
+	config := &ApprovalConfig{
+		DefaultAction:  ApprovalActionPostpone,
+		StateConfigMap: "kube-ns-gc-approvals",
+		StateNamespace: "kube-ns-gc",
+	}
+	manager := newTestApprovalManager(t, config)
+	ctx := context.Background()
+
+	manager.state.Offset = 42
+	manager.state.Approvals["uid-1"] = &PendingApproval{ID: "uid-1", Namespace: "preview-1", MessageID: 7, Approved: true}
+	if err := manager.save(ctx); err != nil {
+		t.Fatalf("Expected state to be saved, got %v", err)
+	}
+
+	// A second manager sharing the cluster state simulates a restart
+	restarted := &ApprovalManager{config: config, clientset: manager.clientset, logger: logrus.New()}
+	if err := restarted.load(ctx); err != nil {
+		t.Fatalf("Expected state to be loaded, got %v", err)
+	}
+
+	if restarted.state.Offset != 42 {
+		t.Errorf("Expected offset 42, got %d", restarted.state.Offset)
+	}
+
+	approval, ok := restarted.state.Approvals["uid-1"]
+	if !ok {
+		t.Fatal("Expected pending approval to survive restart")
+	}
+	if approval.Namespace != "preview-1" || approval.MessageID != 7 || !approval.Approved {
+		t.Errorf("Unexpected approval after restart: %+v", approval)
+	}
+
+	if err := restarted.Complete(ctx, "uid-1"); err != nil {
+		t.Fatalf("Expected approval to be completed, got %v", err)
+	}
+	again := &ApprovalManager{config: config, clientset: manager.clientset, logger: logrus.New()}
+	if err := again.load(ctx); err != nil {
+		t.Fatalf("Expected state to be reloaded, got %v", err)
+	}
+	if len(again.state.Approvals) != 0 {
+		t.Errorf("Expected no approvals after completion, got %d", len(again.state.Approvals))
+	}
+}
+
+func TestApprovalCheckWaitsForLoadAndLoadMerges(t *testing.T) {
+	config := &ApprovalConfig{
+		DefaultAction:  ApprovalActionPostpone,
+		StateConfigMap: "kube-ns-gc-approvals",
+		StateNamespace: "kube-ns-gc",
+	}
+	manager := newTestApprovalManager(t, config)
+	ctx := context.Background()
+
+	manager.state.Approvals["uid-1"] = &PendingApproval{ID: "uid-1", Namespace: "preview-1", Approved: true, DecidedBy: "alice"}
+	if err := manager.save(ctx); err != nil {
+		t.Fatalf("Expected state to be saved, got %v", err)
+	}
+
+	// Until the persisted approvals are loaded no new request is sent
+	restarted := newTestApprovalManager(t, config)
+	restarted.clientset = manager.clientset
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1", UID: "uid-1"}}
+	if _, err := restarted.Check(ctx, ns); err == nil {
+		t.Fatal("Expected Check to fail before the state is loaded")
+	}
+
+	// Loading keeps approvals created in the meantime
+	restarted.state.Approvals["uid-2"] = &PendingApproval{ID: "uid-2", Namespace: "preview-2"}
+	if err := restarted.Load(ctx); err != nil {
+		t.Fatalf("Expected state to be loaded, got %v", err)
+	}
+	if _, ok := restarted.state.Approvals["uid-2"]; !ok || len(restarted.state.Approvals) != 2 {
+		t.Errorf("Expected the loaded approvals to be merged, got %+v", restarted.state.Approvals)
+	}
+
+	approval, err := restarted.Check(ctx, ns)
+	if err != nil || approval == nil {
+		t.Fatalf("Expected the approval to survive the restart, got %+v, %v", approval, err)
+	}
+	if actor := approval.AuditActor(); actor.Type != AuditActorTelegram || actor.Name != "alice" {
+		t.Errorf("Expected the deletion to be made on behalf of alice, got %+v", actor)
+	}
+	if actor := approvalAuditActor(approvalTimeoutActor); actor.Type != AuditActorScheduler || actor.Name != "approval timeout" {
+		t.Errorf("Expected an expired approval to be made by the scheduler, got %+v", actor)
+	}
+}
+
+func TestApprovalPruneDropsDeletedNamespaces(t *testing.T) {
+	manager := newTestApprovalManager(t, &ApprovalConfig{
+		DefaultAction:  ApprovalActionPostpone,
+		StateConfigMap: "kube-ns-gc-approvals",
+		StateNamespace: "kube-ns-gc",
+	})
+	manager.clientset = fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1", UID: "uid-1"}})
+	ctx := context.Background()
+
+	manager.state.Approvals["uid-1"] = &PendingApproval{ID: "uid-1", Namespace: "preview-1"}
+	manager.state.Approvals["uid-2"] = &PendingApproval{ID: "uid-2", Namespace: "preview-2"}
+	manager.state.Approvals["uid-3"] = &PendingApproval{ID: "uid-3", Namespace: "preview-1"}
+	manager.prune(ctx)
+	if _, ok := manager.state.Approvals["uid-1"]; !ok || len(manager.state.Approvals) != 1 {
+		t.Errorf("Expected only the approval of the existing namespace to be kept, got %+v", manager.state.Approvals)
+	}
+
+	// Pruning runs at most every approvalPruneInterval
+	manager.state.Approvals["uid-2"] = &PendingApproval{ID: "uid-2", Namespace: "preview-2"}
+	manager.prune(ctx)
+	if _, ok := manager.state.Approvals["uid-2"]; !ok {
+		t.Error("Expected no second prune within the interval")
+	}
+}
+
+func TestApprovalUserAllowed(t *testing.T) {
+	manager := newTestApprovalManager(t, &ApprovalConfig{
+		DefaultAction: ApprovalActionPostpone,
+		AllowedUsers:  []string{"@alice", "12345"},
+	})
+
+	tests := []struct {
+		user     *TelegramUser
+		expected bool
+	}{
+		{&TelegramUser{ID: 1, Username: "alice"}, true},
+		{&TelegramUser{ID: 12345, Username: "bob"}, true},
+		{&TelegramUser{ID: 2, Username: "mallory"}, false},
+		{nil, false},
+	}
+
+	for _, test := range tests {
+		if result := manager.userAllowed(test.user); result != test.expected {
+			t.Errorf("userAllowed(%+v) = %v, expected %v", test.user, result, test.expected)
+		}
+	}
+}
+
+func TestPostponedUntil(t *testing.T) {
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{PostponedUntilAnnotation: until.Format(time.RFC3339)},
+	}}
+	result, ok := postponedUntil(ns)
+	if !ok || !result.Equal(until) {
+		t.Errorf("Expected postponed until %s, got %s (%v)", until, result, ok)
+	}
+
+	invalid := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{PostponedUntilAnnotation: "tomorrow"},
+	}}
+	if _, ok := postponedUntil(invalid); ok {
+		t.Error("Expected invalid annotation to be ignored")
+	}
+}
+
+func TestApprovalDecisionBacksOffAndDrops(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}))
+	defer server.Close()
+
+	telegram := NewTelegramClient(&TelegramConfig{Enabled: true, BotToken: "test-token", ChatID: "test-chat-id", APIURL: server.URL}, logrus.New())
+	var decisions int
+	decisionErr := fmt.Errorf("connection refused")
+	manager, err := NewApprovalManager(&ApprovalConfig{
+		DefaultAction:  ApprovalActionPostpone,
+		StateConfigMap: "kube-ns-gc-approvals",
+		StateNamespace: "kube-ns-gc",
+	}, fake.NewSimpleClientset(), telegram, logrus.New(), func(*PendingApproval, string, string) error {
+		decisions++
+		return decisionErr
+	})
+	if err != nil {
+		t.Fatalf("NewApprovalManager failed: %v", err)
+	}
+	ctx := context.Background()
+	manager.state.Approvals["uid-1"] = &PendingApproval{ID: "uid-1", Namespace: "preview-1", ExpiresAt: time.Now().Add(-time.Minute)}
+
+	// A transient failure is retried only after the backoff
+	manager.expire(ctx)
+	manager.expire(ctx)
+	approval := manager.state.Approvals["uid-1"]
+	if decisions != 1 || approval == nil || approval.Attempts != 1 || !approval.RetryAt.After(time.Now()) {
+		t.Fatalf("Expected one attempt and a backoff, got %d decisions and %+v", decisions, approval)
+	}
+
+	// A failure retrying cannot fix drops the approval
+	approval.RetryAt = time.Time{}
+	decisionErr = &PermanentDecisionError{Err: fmt.Errorf("namespace preview-1 no longer exists")}
+	manager.expire(ctx)
+	if _, ok := manager.state.Approvals["uid-1"]; ok || decisions != 2 {
+		t.Errorf("Expected the approval to be dropped, got %d decisions and %+v", decisions, manager.state.Approvals)
+	}
+
+	// Approving is recorded but only marks the approval for the next cleanup run
+	manager.state.Approvals["uid-2"] = &PendingApproval{ID: "uid-2", Namespace: "preview-2", Approved: true}
+	manager.decide(ctx, manager.state.Approvals["uid-2"], ApprovalActionApprove, "alice")
+	if _, ok := manager.state.Approvals["uid-2"]; !ok || decisions != 3 {
+		t.Errorf("Expected the approval to stay until the cleanup run, got %d decisions", decisions)
+	}
+}
+
+func TestDecisionBackoff(t *testing.T) {
+	if d := decisionBackoff(1); d != 30*time.Second {
+		t.Errorf("Expected 30s, got %s", d)
+	}
+	if d := decisionBackoff(3); d != 2*time.Minute {
+		t.Errorf("Expected 2m, got %s", d)
+	}
+	if d := decisionBackoff(20); d != time.Hour {
+		t.Errorf("Expected the backoff to be capped at 1h, got %s", d)
+	}
+}
+
+// newFakeApprovalTelegram answers every Bot API call with ok and records the
+// texts of answered callback queries.
+func newFakeApprovalTelegram(t *testing.T) (*TelegramClient, func() []string) {
+	var mu sync.Mutex
+	var answers []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/answerCallbackQuery") {
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			mu.Lock()
+			answers = append(answers, body["text"])
+			mu.Unlock()
+		}
+		w.Write([]byte(`{"ok":true,"result":{}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	telegram := NewTelegramClient(&TelegramConfig{Enabled: true, BotToken: "test-token", ChatID: "-100", APIURL: server.URL}, logrus.New())
+	return telegram, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), answers...)
+	}
+}
+
+func approvalCallback(action, id string) *TelegramCallbackQuery {
+	return &TelegramCallbackQuery{
+		ID:      action + "-" + id,
+		From:    &TelegramUser{ID: 1, Username: "alice"},
+		Message: &TelegramSentMessage{MessageID: 7, Chat: &TelegramChat{ID: -100}},
+		Data:    action + ":" + id,
+	}
+}
+
+func TestApprovalCheckConcurrentWithCallback(t *testing.T) {
+	telegram, _ := newFakeApprovalTelegram(t)
+	manager, err := NewApprovalManager(&ApprovalConfig{
+		DefaultAction:  ApprovalActionPostpone,
+		StateConfigMap: "kube-ns-gc-approvals",
+		StateNamespace: "kube-ns-gc",
+	}, fake.NewSimpleClientset(), telegram, logrus.New(), func(*PendingApproval, string, string) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("NewApprovalManager failed: %v", err)
+	}
+	ctx := context.Background()
+	if err := manager.Load(ctx); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	manager.state.Approvals["uid-1"] = &PendingApproval{ID: "uid-1", Namespace: "preview-1", MessageID: 7}
+
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1", UID: "uid-1"}}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if _, err := manager.Check(ctx, ns); err != nil {
+				t.Errorf("Check failed: %v", err)
+				return
+			}
+		}
+	}()
+	manager.handleCallback(ctx, approvalCallback(ApprovalActionApprove, "uid-1"))
+	wg.Wait()
+
+	approval, err := manager.Check(ctx, ns)
+	if err != nil || approval == nil || approval.DecidedBy != "@alice" {
+		t.Errorf("Expected the approval by @alice, got %+v, %v", approval, err)
+	}
+}
+
+func TestApprovalCallbackDecidesOnce(t *testing.T) {
+	telegram, answers := newFakeApprovalTelegram(t)
+	release := make(chan struct{})
+	decided := make(chan string, 10)
+	decisionErr := fmt.Errorf("connection refused")
+	manager, err := NewApprovalManager(&ApprovalConfig{
+		DefaultAction:  ApprovalActionPostpone,
+		StateConfigMap: "kube-ns-gc-approvals",
+		StateNamespace: "kube-ns-gc",
+	}, fake.NewSimpleClientset(), telegram, logrus.New(), func(approval *PendingApproval, action, actor string) error {
+		<-release
+		decided <- action
+		return decisionErr
+	})
+	if err != nil {
+		t.Fatalf("NewApprovalManager failed: %v", err)
+	}
+	ctx := context.Background()
+	manager.state.Approvals["uid-1"] = &PendingApproval{ID: "uid-1", Namespace: "preview-1", MessageID: 7}
+
+	// A press from another chat is not a decision
+	other := approvalCallback(ApprovalActionProtect, "uid-1")
+	other.Message.Chat = &TelegramChat{ID: -200}
+	manager.handleCallback(ctx, other)
+
+	// Presses while a postpone is in flight are turned away
+	manager.handleCallback(ctx, approvalCallback(ApprovalActionPostpone, "uid-1"))
+	manager.handleCallback(ctx, approvalCallback(ApprovalActionProtect, "uid-1"))
+	manager.handleCallback(ctx, approvalCallback(ApprovalActionApprove, "uid-1"))
+	close(release)
+
+	if action := <-decided; action != ApprovalActionPostpone {
+		t.Errorf("Expected the postpone to be applied, got %s", action)
+	}
+	expected := []string{
+		"Decisions are only accepted in the approval chat",
+		"Decision recorded: postpone",
+		"This request is no longer pending: postpone by @alice",
+		"This request is no longer pending: postpone by @alice",
+	}
+	if got := answers(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected answers %q, got %q", expected, got)
+	}
+
+	// A failed decision reopens the approval
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		manager.mu.Lock()
+		approval := manager.state.Approvals["uid-1"]
+		reopened := approval.deciding == "" && approval.Attempts == 1
+		manager.mu.Unlock()
+		if reopened {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the failed decision to reopen the approval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	decisionErr = nil
+	manager.handleCallback(ctx, approvalCallback(ApprovalActionProtect, "uid-1"))
+	if action := <-decided; action != ApprovalActionProtect {
+		t.Errorf("Expected the protect to be applied after the failure, got %s", action)
+	}
+	if len(decided) != 0 {
+		t.Errorf("Expected no other decision, got %d", len(decided))
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	archiveIDLayout = "20060102T150405.000Z"
+	redactedValue   = "[REDACTED]"
+)
+
+// DefaultRedactKeys are the value keys redacted by default, matched
+// case-insensitively as substrings.
+var DefaultRedactKeys = []string{"password", "secret", "token", "apikey", "api_key", "privatekey", "private_key", "credentials"}
+
+// ArchiveConfig configures the archive of Helm release history taken before
+// uninstall. Archives are JSON files under Dir/<namespace>/<release>/.
+type ArchiveConfig struct {
+	Enabled bool   `json:"enabled"`
+	Dir     string `json:"dir"`
+	// RedactSecrets redacts Secret data in manifests and values whose key
+	// contains one of RedactKeys
+	RedactSecrets bool     `json:"redact_secrets"`
+	RedactKeys    []string `json:"redact_keys"`
+}
+
+// ReleaseArchive is the history of a Helm release saved before uninstall.
+type ReleaseArchive struct {
+	ID         string             `json:"id"`
+	Cluster    string             `json:"cluster,omitempty"`
+	RunID      string             `json:"run_id,omitempty"`
+	Policy     string             `json:"policy,omitempty"`
+	Namespace  string             `json:"namespace"`
+	Release    string             `json:"release"`
+	ArchivedAt time.Time          `json:"archived_at"`
+	Redacted   bool               `json:"redacted"`
+	Revisions  []ArchivedRevision `json:"revisions"`
+}
+
+// ArchivedRevision is one revision of an archived release.
+type ArchivedRevision struct {
+	Revision      int             `json:"revision"`
+	Status        string          `json:"status,omitempty"`
+	Description   string          `json:"description,omitempty"`
+	FirstDeployed time.Time       `json:"first_deployed,omitempty"`
+	LastDeployed  time.Time       `json:"last_deployed,omitempty"`
+	Chart         *chart.Metadata `json:"chart,omitempty"`
+	// Values are the user-supplied values, not merged with the chart defaults
+	Values   map[string]interface{} `json:"values,omitempty"`
+	Manifest string                 `json:"manifest"`
+	Notes    string                 `json:"notes,omitempty"`
+}
+
+// ArchiveSummary lists an archive without reading it.
+type ArchiveSummary struct {
+	ID         string    `json:"id"`
+	Namespace  string    `json:"namespace"`
+	Release    string    `json:"release"`
+	ArchivedAt time.Time `json:"archived_at"`
+	Size       int64     `json:"size"`
+}
+
+// newReleaseArchive converts the history of a release, oldest revision first.
+// With redactKeys set, Secret data and matching values are redacted.
+func newReleaseArchive(namespace, name string, history []*release.Release, redactKeys []string, now time.Time) *ReleaseArchive {
+	archive := &ReleaseArchive{
+		ID:         now.UTC().Format(archiveIDLayout),
+		Namespace:  namespace,
+		Release:    name,
+		ArchivedAt: now,
+		Redacted:   len(redactKeys) > 0,
+	}
+
+	history = append([]*release.Release(nil), history...)
+	releaseutil.SortByRevision(history)
+	for _, rel := range history {
+		revision := ArchivedRevision{
+			Revision: rel.Version,
+			Values:   rel.Config,
+			Manifest: rel.Manifest,
+		}
+		if rel.Info != nil {
+			revision.Status = rel.Info.Status.String()
+			revision.Description = rel.Info.Description
+			revision.FirstDeployed = rel.Info.FirstDeployed.Time
+			revision.LastDeployed = rel.Info.LastDeployed.Time
+			revision.Notes = rel.Info.Notes
+		}
+		if rel.Chart != nil {
+			revision.Chart = rel.Chart.Metadata
+		}
+		if archive.Redacted {
+			revision.Values = redactValues(revision.Values, redactKeys)
+			revision.Manifest = redactManifest(revision.Manifest)
+		}
+		archive.Revisions = append(archive.Revisions, revision)
+	}
+	return archive
+}
+
+// redactValues returns a copy of values with the values of matching keys
+// replaced, nested maps and lists included.
+func redactValues(values map[string]interface{}, keys []string) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		if matchesRedactKey(key, keys) {
+			redacted[key] = redactedValue
+			continue
+		}
+		redacted[key] = redactValue(value, keys)
+	}
+	return redacted
+}
+
+func redactValue(value interface{}, keys []string) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return redactValues(value, keys)
+	case []interface{}:
+		redacted := make([]interface{}, len(value))
+		for i, item := range value {
+			redacted[i] = redactValue(item, keys)
+		}
+		return redacted
+	default:
+		return value
+	}
+}
+
+func matchesRedactKey(key string, keys []string) bool {
+	key = strings.ToLower(key)
+	for _, redact := range keys {
+		if redact != "" && strings.Contains(key, strings.ToLower(redact)) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactManifest replaces the data and stringData values of the Secrets in
+// manifest. Other documents are kept as rendered.
+func redactManifest(manifest string) string {
+	documents := releaseutil.SplitManifests(manifest)
+	keys := make([]string, 0, len(documents))
+	for key := range documents {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString("---\n")
+		b.WriteString(redactSecret(documents[key]))
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func redactSecret(document string) string {
+	var head manifestHead
+	if err := yaml.Unmarshal([]byte(document), &head); err != nil || head.Kind != "Secret" {
+		return document
+	}
+	var secret map[string]interface{}
+	if err := yaml.Unmarshal([]byte(document), &secret); err != nil {
+		return document
+	}
+	for _, field := range []string{"data", "stringData"} {
+		data, ok := secret[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key := range data {
+			data[key] = redactedValue
+		}
+	}
+	out, err := yaml.Marshal(secret)
+	if err != nil {
+		return document
+	}
+
+	// Keep the "# Source:" comment of the template
+	var comments []string
+	for _, line := range strings.Split(document, "\n") {
+		if !strings.HasPrefix(line, "#") {
+			break
+		}
+		comments = append(comments, line)
+	}
+	if len(comments) > 0 {
+		return strings.Join(comments, "\n") + "\n" + strings.TrimSuffix(string(out), "\n")
+	}
+	return strings.TrimSuffix(string(out), "\n")
+}
+
+// ReleaseArchiver writes release archives to a directory and lists them.
+type ReleaseArchiver struct {
+	config *ArchiveConfig
+}
+
+func NewReleaseArchiver(config *ArchiveConfig) (*ReleaseArchiver, error) {
+	if config.Dir == "" {
+		return nil, fmt.Errorf("release archive needs a dir")
+	}
+	if err := os.MkdirAll(config.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create archive dir: %v", err)
+	}
+	if config.RedactSecrets && len(config.RedactKeys) == 0 {
+		config.RedactKeys = DefaultRedactKeys
+	}
+	return &ReleaseArchiver{config: config}, nil
+}
+
+// redactKeys returns the keys to redact, none when redaction is disabled.
+func (a *ReleaseArchiver) redactKeys() []string {
+	if !a.config.RedactSecrets {
+		return nil
+	}
+	return a.config.RedactKeys
+}
+
+// Save writes archive, replacing the file only once it is complete.
+func (a *ReleaseArchiver) Save(archive *ReleaseArchive) error {
+	dir, err := a.releaseDir(archive.Namespace, archive.Release)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create archive dir: %v", err)
+	}
+
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal archive: %v", err)
+	}
+	path := filepath.Join(dir, archive.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return fmt.Errorf("failed to write archive: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write archive: %v", err)
+	}
+	return nil
+}
+
+// List returns the archives, newest first. Empty namespace or release list
+// every namespace or release.
+func (a *ReleaseArchiver) List(namespace, release string) ([]ArchiveSummary, error) {
+	pattern := filepath.Join(a.config.Dir, "*", "*", "*.json")
+	if namespace != "" || release != "" {
+		if namespace == "" {
+			namespace = "*"
+		} else if !validArchiveName(namespace) {
+			return nil, fmt.Errorf("invalid namespace %q", namespace)
+		}
+		if release == "" {
+			release = "*"
+		} else if !validArchiveName(release) {
+			return nil, fmt.Errorf("invalid release %q", release)
+		}
+		pattern = filepath.Join(a.config.Dir, namespace, release, "*.json")
+	}
+
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archives: %v", err)
+	}
+
+	archives := make([]ArchiveSummary, 0, len(paths))
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		archivedAt, err := time.Parse(archiveIDLayout, id)
+		if err != nil {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		releaseDir := filepath.Dir(path)
+		archives = append(archives, ArchiveSummary{
+			ID:         id,
+			Namespace:  filepath.Base(filepath.Dir(releaseDir)),
+			Release:    filepath.Base(releaseDir),
+			ArchivedAt: archivedAt,
+			Size:       info.Size(),
+		})
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		return archives[i].ArchivedAt.After(archives[j].ArchivedAt)
+	})
+	return archives, nil
+}
+
+// Path returns the file of an archive, or an error when it does not exist.
+func (a *ReleaseArchiver) Path(namespace, release, id string) (string, error) {
+	dir, err := a.releaseDir(namespace, release)
+	if err != nil {
+		return "", err
+	}
+	if _, err := time.Parse(archiveIDLayout, id); err != nil {
+		return "", fmt.Errorf("invalid archive id %q", id)
+	}
+	path := filepath.Join(dir, id+".json")
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (a *ReleaseArchiver) releaseDir(namespace, release string) (string, error) {
+	if !validArchiveName(namespace) {
+		return "", fmt.Errorf("invalid namespace %q", namespace)
+	}
+	if !validArchiveName(release) {
+		return "", fmt.Errorf("invalid release %q", release)
+	}
+	return filepath.Join(a.config.Dir, namespace, release), nil
+}
+
+// validArchiveName reports whether name is safe as a single path element.
+func validArchiveName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\*?[`)
+}
+
+// archiveError is a release that was not uninstalled because its history
+// could not be archived. It is not an uninstall failure: escalating it would
+// purge the history or delete the namespace with it.
+type archiveError struct {
+	release string
+	err     error
+}
+
+func (e *archiveError) Error() string {
+	return fmt.Sprintf("failed to archive Helm release %s: %v", e.release, e.err)
+}
+
+// isArchiveError reports whether err is an archiveError.
+func isArchiveError(err error) bool {
+	_, ok := err.(*archiveError)
+	return ok
+}
+
+// archiveRelease saves the history of release before it is uninstalled.
+func (gc *NamespaceGC) archiveRelease(ns *v1.Namespace, release *HelmRelease, runID, policy string) error {
+	history, err := gc.helmClient.ReleaseHistory(release.Name, ns.Name)
+	if err != nil {
+		return err
+	}
+
+	archive := newReleaseArchive(ns.Name, release.Name, history, gc.archiver.redactKeys(), time.Now())
+	archive.Cluster = gc.config.ClusterName
+	archive.RunID = runID
+	archive.Policy = policy
+	if err := gc.archiver.Save(archive); err != nil {
+		return err
+	}
+
+	gc.logger.Infof("Archived %d revisions of Helm release %s in namespace %s as %s", len(archive.Revisions), release.Name, ns.Name, archive.ID)
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testSecretManifest = `---
+# Source: api/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: creds
+stringData:
+  password: hunter2
+---
+# Source: api/templates/configmap.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  mode: preview
+`
+
+func TestNewReleaseArchive(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	history := []*release.Release{
+		{
+			Name:     "api",
+			Version:  2,
+			Info:     &release.Info{Status: release.StatusDeployed, Notes: "Visit http://api.preview"},
+			Chart:    &chart.Chart{Metadata: &chart.Metadata{Name: "api", Version: "1.2.0"}},
+			Config:   map[string]interface{}{"image": map[string]interface{}{"tag": "v2"}, "db": map[string]interface{}{"password": "hunter2"}},
+			Manifest: testSecretManifest,
+		},
+		{Name: "api", Version: 1, Info: &release.Info{Status: release.StatusSuperseded}},
+	}
+
+	archive := newReleaseArchive("preview-1", "api", history, DefaultRedactKeys, now)
+	if archive.ID != "20240601T120000.000Z" || !archive.Redacted || len(archive.Revisions) != 2 {
+		t.Fatalf("Unexpected archive %+v", archive)
+	}
+	if archive.Revisions[0].Revision != 1 || archive.Revisions[1].Status != "deployed" {
+		t.Errorf("Expected revisions oldest first, got %+v", archive.Revisions)
+	}
+
+	latest := archive.Revisions[1]
+	if latest.Chart.Version != "1.2.0" || latest.Notes != "Visit http://api.preview" {
+		t.Errorf("Expected chart metadata and notes, got %+v", latest)
+	}
+	if db := latest.Values["db"].(map[string]interface{}); db["password"] != redactedValue {
+		t.Errorf("Expected password to be redacted, got %v", db)
+	}
+	if image := latest.Values["image"].(map[string]interface{}); image["tag"] != "v2" {
+		t.Errorf("Expected image tag to be kept, got %v", image)
+	}
+	if history[0].Config["db"].(map[string]interface{})["password"] != "hunter2" {
+		t.Error("Expected redaction to leave the release values alone")
+	}
+	if strings.Contains(latest.Manifest, "hunter2") || !strings.Contains(latest.Manifest, "# Source: api/templates/secret.yaml") {
+		t.Errorf("Expected Secret data to be redacted, got:\n%s", latest.Manifest)
+	}
+	if !strings.Contains(latest.Manifest, "mode: preview") {
+		t.Errorf("Expected other documents to be kept, got:\n%s", latest.Manifest)
+	}
+
+	plain := newReleaseArchive("preview-1", "api", history[:1], nil, now)
+	if plain.Redacted || plain.Revisions[0].Manifest != testSecretManifest {
+		t.Errorf("Expected an unredacted archive, got %+v", plain)
+	}
+}
+
+func TestReleaseArchiver(t *testing.T) {
+	archiver, err := NewReleaseArchiver(&ArchiveConfig{Dir: t.TempDir(), RedactSecrets: true})
+	if err != nil {
+		t.Fatalf("NewReleaseArchiver failed: %v", err)
+	}
+	if len(archiver.redactKeys()) == 0 {
+		t.Error("Expected the default redact keys")
+	}
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for i, name := range []string{"api", "web", "api"} {
+		archive := newReleaseArchive("preview-1", name, nil, nil, now.Add(time.Duration(i)*time.Hour))
+		if err := archiver.Save(archive); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	all, err := archiver.List("", "")
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Expected 3 archives, got %+v, %v", all, err)
+	}
+	if all[0].Release != "api" || all[0].ID != "20240601T140000.000Z" || all[0].Namespace != "preview-1" {
+		t.Errorf("Expected the newest archive first, got %+v", all[0])
+	}
+	if api, _ := archiver.List("preview-1", "api"); len(api) != 2 {
+		t.Errorf("Expected 2 api archives, got %+v", api)
+	}
+
+	path, err := archiver.Path("preview-1", "web", "20240601T130000.000Z")
+	if err != nil {
+		t.Fatalf("Path failed: %v", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected archive file %s: %v", path, err)
+	}
+	if _, err := archiver.Path("preview-1", "web", "20240101T000000.000Z"); !os.IsNotExist(err) {
+		t.Errorf("Expected a missing archive, got %v", err)
+	}
+	if _, err := archiver.Path("..", "web", "20240601T130000.000Z"); err == nil {
+		t.Error("Expected a path outside the archive to be rejected")
+	}
+	if _, err := archiver.List("*", ""); err == nil {
+		t.Error("Expected a glob namespace to be rejected")
+	}
+}
+
+func TestArchiveFailureHoldsNamespace(t *testing.T) {
+	config := &Config{FailureEscalation: FailureEscalationConfig{Enabled: true, After: 1, PurgeStorage: true, DeleteNamespace: true, StateConfigMap: "kube-ns-gc-failures", StateNamespace: "kube-ns-gc"}}
+	failures, err := NewFailureTracker(&config.FailureEscalation, fake.NewSimpleClientset(), logrus.New())
+	if err != nil {
+		t.Fatalf("NewFailureTracker failed: %v", err)
+	}
+	gc := &NamespaceGC{config: config, logger: logrus.New(), failures: failures}
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1"}}
+	release := &HelmRelease{Name: "api", Namespace: "preview-1"}
+	archiveErr := &archiveError{release: "api", err: os.ErrPermission}
+
+	// Not archiving a release keeps the namespace, but is never escalated
+	if err := gc.holdNamespace(ns, []releaseFailure{{release: release, err: archiveErr}}, "run-1"); err == nil {
+		t.Error("Expected the namespace to be held")
+	}
+	if records := failures.Records(); len(records) != 0 {
+		t.Errorf("Expected archive failures not to be tracked, got %+v", records)
+	}
+
+	event := gc.releaseFailureEvent(ns, release, "run-1", PolicyNamespaceMaxAge, archiveErr)
+	if event.Failure != FailureHelmArchive || event.Error != "failed to archive Helm release api: permission denied" {
+		t.Errorf("Unexpected archive failure event: %+v", event)
+	}
+	event = gc.releaseFailureEvent(ns, release, "run-1", PolicyNamespaceMaxAge, os.ErrDeadlineExceeded)
+	if event.Failure != FailureHelmUninstall {
+		t.Errorf("Expected an uninstall failure, got %s", event.Failure)
+	}
+}
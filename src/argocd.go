@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// Actions on the Argo CD Applications that target a collected namespace.
+const (
+	ArgoCDActionDelete  = "delete"
+	ArgoCDActionSuspend = "suspend"
+	ArgoCDActionBlock   = "block"
+)
+
+const (
+	// ArgoCDActionAnnotation on an Application overrides argocd.action for it.
+	ArgoCDActionAnnotation = "kube-ns-gc/argocd-action"
+	// ArgoCDSuspendedAnnotation records when kube-ns-gc suspended the sync.
+	ArgoCDSuspendedAnnotation = "kube-ns-gc/suspended-at"
+	// argoCDCascadeFinalizer makes Argo CD delete the resources of an Application with it.
+	argoCDCascadeFinalizer = "resources-finalizer.argocd.argoproj.io"
+)
+
+var argoCDApplicationsResource = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"}
+
+// DefaultArgoCDDestinations are the destinations of the cluster Argo CD runs in.
+var DefaultArgoCDDestinations = []string{"https://kubernetes.default.svc", "in-cluster"}
+
+// ArgoCDConfig configures what happens to the Argo CD Applications whose
+// destination is a namespace about to be deleted, so that they do not recreate it.
+type ArgoCDConfig struct {
+	Enabled bool `json:"enabled"`
+	// Action is delete (the default), suspend or block
+	Action string `json:"action"`
+	// Cascade deletes the resources of an Application along with it
+	Cascade bool `json:"cascade"`
+	// Namespaces Applications are looked up in, all when empty
+	Namespaces []string `json:"namespaces"`
+	// Destinations are the server URLs or cluster names of this cluster
+	Destinations []string `json:"destinations"`
+}
+
+// ArgoCDApplication is an Application targeting a collected namespace.
+type ArgoCDApplication struct {
+	Namespace string
+	Name      string
+	Action    string
+	// Finalizers of the Application, to switch cascading deletion
+	Finalizers []string
+}
+
+func (a ArgoCDApplication) String() string {
+	return a.Namespace + "/" + a.Name
+}
+
+// ArgoCDApplications are the Applications of the cluster at the start of a
+// run, by destination namespace. A nil *ArgoCDApplications targets nothing.
+type ArgoCDApplications struct {
+	apps map[string][]ArgoCDApplication
+	// err is why the Applications could not be listed
+	err error
+}
+
+// unlistedArgoCDApplications stands in for the Applications of a run that
+// failed to list them. Any namespace may be targeted, so none is collected.
+func unlistedArgoCDApplications(err error) *ArgoCDApplications {
+	return &ArgoCDApplications{err: err}
+}
+
+// Targeting returns the Applications whose destination is namespace, or an
+// error when the Applications could not be listed.
+func (a *ArgoCDApplications) Targeting(namespace string) ([]ArgoCDApplication, error) {
+	if a == nil {
+		return nil, nil
+	}
+	if a.err != nil {
+		return nil, &unknownOwnerError{err: fmt.Errorf("Argo CD Applications could not be listed, namespace may be recreated by Argo CD: %v", a.err)}
+	}
+	return a.apps[namespace], nil
+}
+
+// ArgoCDClient finds, deletes and suspends Argo CD Applications.
+type ArgoCDClient struct {
+	config *ArgoCDConfig
+	client dynamic.Interface
+	logger *logrus.Logger
+}
+
+func NewArgoCDClient(restConfig *rest.Config, config *ArgoCDConfig, logger *logrus.Logger) (*ArgoCDClient, error) {
+	switch config.Action {
+	case "":
+		config.Action = ArgoCDActionDelete
+	case ArgoCDActionDelete, ArgoCDActionSuspend, ArgoCDActionBlock:
+	default:
+		return nil, fmt.Errorf("unsupported Argo CD action %q", config.Action)
+	}
+	if len(config.Destinations) == 0 {
+		config.Destinations = DefaultArgoCDDestinations
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %v", err)
+	}
+	return &ArgoCDClient{config: config, client: client, logger: logger}, nil
+}
+
+// Load lists the Applications whose destination is this cluster, once for a
+// whole run. A nil *ArgoCDClient loads nothing.
+func (c *ArgoCDClient) Load(ctx context.Context) (*ArgoCDApplications, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	namespaces := c.config.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	apps := &ArgoCDApplications{apps: map[string][]ArgoCDApplication{}}
+	for _, ns := range namespaces {
+		list, err := c.client.Resource(argoCDApplicationsResource).Namespace(ns).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Argo CD Applications: %v", err)
+		}
+		for i := range list.Items {
+			if app, destination, ok := c.inCluster(&list.Items[i]); ok {
+				apps.apps[destination] = append(apps.apps[destination], app)
+			}
+		}
+	}
+	return apps, nil
+}
+
+// inCluster converts item and returns its destination namespace when its
+// destination is this cluster.
+func (c *ArgoCDClient) inCluster(item *unstructured.Unstructured) (ArgoCDApplication, string, bool) {
+	destination, _, _ := unstructured.NestedStringMap(item.Object, "spec", "destination")
+	if destination["namespace"] == "" {
+		return ArgoCDApplication{}, "", false
+	}
+	if !containsString(c.config.Destinations, destination["server"]) && !containsString(c.config.Destinations, destination["name"]) {
+		return ArgoCDApplication{}, "", false
+	}
+
+	action := c.config.Action
+	if value := strings.TrimSpace(item.GetAnnotations()[ArgoCDActionAnnotation]); value != "" {
+		action = value
+	}
+	return ArgoCDApplication{
+		Namespace:  item.GetNamespace(),
+		Name:       item.GetName(),
+		Action:     action,
+		Finalizers: item.GetFinalizers(),
+	}, destination["namespace"], true
+}
+
+// Delete deletes app, switching the cascade finalizer first so that Argo CD
+// deletes the resources of app only with cascade set.
+func (c *ArgoCDClient) Delete(ctx context.Context, app ArgoCDApplication) error {
+	applications := c.client.Resource(argoCDApplicationsResource).Namespace(app.Namespace)
+
+	finalizers := cascadeFinalizers(app.Finalizers, c.config.Cascade)
+	if len(finalizers) != len(app.Finalizers) {
+		patch, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{"finalizers": finalizers},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal finalizers patch: %v", err)
+		}
+		if _, err := applications.Patch(ctx, app.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return fmt.Errorf("failed to patch finalizers of Argo CD Application %s: %v", app, err)
+		}
+	}
+
+	if err := applications.Delete(ctx, app.Name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("failed to delete Argo CD Application %s: %v", app, err)
+	}
+	return nil
+}
+
+// Suspend turns off automated sync of app, self-heal included.
+func (c *ArgoCDClient) Suspend(ctx context.Context, app ArgoCDApplication) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{ArgoCDSuspendedAnnotation: time.Now().UTC().Format(time.RFC3339)},
+		},
+		"spec": map[string]interface{}{
+			"syncPolicy": map[string]interface{}{"automated": nil},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal suspend patch: %v", err)
+	}
+
+	_, err = c.client.Resource(argoCDApplicationsResource).Namespace(app.Namespace).Patch(ctx, app.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to suspend Argo CD Application %s: %v", app, err)
+	}
+	return nil
+}
+
+// cascadeFinalizers adds or removes the cascade finalizer.
+func cascadeFinalizers(finalizers []string, cascade bool) []string {
+	result := []string{}
+	for _, finalizer := range finalizers {
+		if finalizer != argoCDCascadeFinalizer {
+			result = append(result, finalizer)
+		}
+	}
+	if cascade {
+		result = append(result, argoCDCascadeFinalizer)
+	}
+	return result
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if value != "" && v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// argoCDApplications returns the Applications targeting ns, or an error when
+// one of them blocks its deletion or they could not be listed.
+func (gc *NamespaceGC) argoCDApplications(ns *v1.Namespace, argoCD *ArgoCDApplications) ([]ArgoCDApplication, error) {
+	apps, err := argoCD.Targeting(ns.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := argoCDBlock(apps); err != nil {
+		return nil, err
+	}
+	return apps, nil
+}
+
+// argoCDBlock returns an error when one of apps blocks the deletion of its
+// destination namespace.
+func argoCDBlock(apps []ArgoCDApplication) error {
+	for _, app := range apps {
+		switch app.Action {
+		case ArgoCDActionDelete, ArgoCDActionSuspend:
+		case ArgoCDActionBlock:
+			return fmt.Errorf("Argo CD Application %s blocks deletion", app)
+		default:
+			return fmt.Errorf("Argo CD Application %s has unsupported %s %q", app, ArgoCDActionAnnotation, app.Action)
+		}
+	}
+	return nil
+}
+
+// unknownOwnerError is a namespace left alone because the Flux objects or the
+// Argo CD Applications that may own it could not be listed. Unlike a known
+// owner, it says nothing about whether the namespace is collected later.
+type unknownOwnerError struct {
+	err error
+}
+
+func (e *unknownOwnerError) Error() string {
+	return e.err.Error()
+}
+
+// isUnknownOwner reports whether err is an unknownOwnerError.
+func isUnknownOwner(err error) bool {
+	_, ok := err.(*unknownOwnerError)
+	return ok
+}
+
+// ownedElsewhere returns why ns is left to Flux or to Argo CD, or nil when it
+// is collected. Without the Applications of the run any namespace is left,
+// with an unknownOwnerError.
+func (gc *NamespaceGC) ownedElsewhere(ns *v1.Namespace, releases []HelmRelease, flux *FluxObjects, argoCD *ArgoCDApplications) error {
+	if _, err := gc.fluxOwners(ns, releases, flux); err != nil {
+		return err
+	}
+	_, err := gc.argoCDApplications(ns, argoCD)
+	return err
+}
+
+// handleArgoCDApplications deletes or suspends apps before ns is deleted.
+func (gc *NamespaceGC) handleArgoCDApplications(ns *v1.Namespace, apps []ArgoCDApplication, runID string, actor AuditActor) error {
+	for _, app := range apps {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		action, reason := AuditActionArgoCDDelete, ReasonArgoCDApplicationDeleted
+		var err error
+		if app.Action == ArgoCDActionSuspend {
+			action, reason = AuditActionArgoCDSuspend, ReasonArgoCDApplicationSuspended
+			err = gc.argoCD.Suspend(ctx, app)
+		} else {
+			err = gc.argoCD.Delete(ctx, app)
+		}
+		cancel()
+
+		entry := gc.auditEntry(action, ns, runID, actor, err)
+		entry.Application = app.String()
+		gc.audit.Record(entry)
+		if err != nil {
+			return err
+		}
+
+		gc.logger.Infof("Argo CD Application %s targeting namespace %s: %s", app, ns.Name, app.Action)
+		gc.events.Namespace(ns, v1.EventTypeNormal, reason, "Argo CD Application %s: %s before deleting the namespace", app, app.Action)
+	}
+	return nil
+}
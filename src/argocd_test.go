@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newTestApplication(name, server, destination string, annotations map[string]string, finalizers ...string) *unstructured.Unstructured {
+	app := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Application",
+		"metadata":   map[string]interface{}{"name": name, "namespace": "argocd"},
+		"spec": map[string]interface{}{
+			"destination": map[string]interface{}{"server": server, "namespace": destination},
+			"syncPolicy":  map[string]interface{}{"automated": map[string]interface{}{"selfHeal": true}},
+		},
+	}}
+	app.SetAnnotations(annotations)
+	app.SetFinalizers(finalizers)
+	return app
+}
+
+func newTestArgoCDClient(config *ArgoCDConfig, objects ...runtime.Object) *ArgoCDClient {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{argoCDApplicationsResource: "ApplicationList"}, objects...)
+	return &ArgoCDClient{config: config, client: client, logger: logrus.New()}
+}
+
+func TestArgoCDApplications(t *testing.T) {
+	client := newTestArgoCDClient(&ArgoCDConfig{Action: ArgoCDActionDelete, Destinations: DefaultArgoCDDestinations},
+		newTestApplication("preview-1-api", "https://kubernetes.default.svc", "preview-1", nil),
+		newTestApplication("preview-1-db", "https://kubernetes.default.svc", "preview-1", map[string]string{ArgoCDActionAnnotation: "block"}),
+		newTestApplication("preview-1-remote", "https://prod.example.com", "preview-1", nil),
+		newTestApplication("preview-2-api", "https://kubernetes.default.svc", "preview-2", nil),
+	)
+
+	loaded, err := client.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	apps, err := loaded.Targeting("preview-1")
+	if err != nil {
+		t.Fatalf("Targeting failed: %v", err)
+	}
+	actions := map[string]string{}
+	for _, app := range apps {
+		actions[app.String()] = app.Action
+	}
+	expected := map[string]string{"argocd/preview-1-api": ArgoCDActionDelete, "argocd/preview-1-db": ArgoCDActionBlock}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected %v, got %v", expected, actions)
+	}
+}
+
+func TestArgoCDDeleteSwitchesCascade(t *testing.T) {
+	ctx := context.Background()
+	client := newTestArgoCDClient(&ArgoCDConfig{Cascade: false},
+		newTestApplication("api", "https://kubernetes.default.svc", "preview-1", nil, argoCDCascadeFinalizer))
+
+	app := ArgoCDApplication{Namespace: "argocd", Name: "api", Finalizers: []string{argoCDCascadeFinalizer}}
+	if err := client.Delete(ctx, app); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	var patched []string
+	for _, action := range client.client.(*dynamicfake.FakeDynamicClient).Actions() {
+		patched = append(patched, action.GetVerb())
+	}
+	if !reflect.DeepEqual(patched, []string{"patch", "delete"}) {
+		t.Errorf("Expected the finalizer to be removed before deleting, got %v", patched)
+	}
+
+	if got := cascadeFinalizers([]string{"other"}, true); !reflect.DeepEqual(got, []string{"other", argoCDCascadeFinalizer}) {
+		t.Errorf("Unexpected finalizers %v", got)
+	}
+}
+
+func TestArgoCDSuspend(t *testing.T) {
+	ctx := context.Background()
+	client := newTestArgoCDClient(&ArgoCDConfig{},
+		newTestApplication("api", "https://kubernetes.default.svc", "preview-1", nil))
+
+	if err := client.Suspend(ctx, ArgoCDApplication{Namespace: "argocd", Name: "api"}); err != nil {
+		t.Fatalf("Suspend failed: %v", err)
+	}
+
+	app, err := client.client.Resource(argoCDApplicationsResource).Namespace("argocd").Get(ctx, "api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if _, found, _ := unstructured.NestedMap(app.Object, "spec", "syncPolicy", "automated"); found {
+		t.Error("Expected automated sync to be removed")
+	}
+	if app.GetAnnotations()[ArgoCDSuspendedAnnotation] == "" {
+		t.Error("Expected the suspend time to be recorded")
+	}
+}
+
+func TestOwnedElsewhereArgoCDBlock(t *testing.T) {
+	gc := &NamespaceGC{config: &Config{}, logger: logrus.New()}
+	gc.argoCD = newTestArgoCDClient(&ArgoCDConfig{Action: ArgoCDActionDelete, Destinations: DefaultArgoCDDestinations},
+		newTestApplication("preview-1-api", "https://kubernetes.default.svc", "preview-1", nil),
+		newTestApplication("preview-2-db", "https://kubernetes.default.svc", "preview-2", map[string]string{ArgoCDActionAnnotation: "block"}),
+	)
+
+	apps, err := gc.argoCD.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	collected := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1"}}
+	if err := gc.ownedElsewhere(collected, nil, nil, apps); err != nil {
+		t.Errorf("Expected a namespace with deletable Applications to be collected, got %v", err)
+	}
+	blocked := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-2"}}
+	if err := gc.ownedElsewhere(blocked, nil, nil, apps); err == nil {
+		t.Error("Expected a namespace with a blocking Application to be kept")
+	}
+
+	// Without the Applications any namespace may be recreated by Argo CD
+	unlisted := unlistedArgoCDApplications(fmt.Errorf("applications.argoproj.io is forbidden"))
+	if err := gc.ownedElsewhere(collected, nil, nil, unlisted); err == nil {
+		t.Error("Expected a namespace to be kept when the Applications could not be listed")
+	}
+}
+
+func TestCleanupNamespaceSuspendsArgoCDBeforeUninstalling(t *testing.T) {
+	gc, _, _ := newEscalationTestGC(t, FailureEscalationConfig{
+		Enabled:        true,
+		After:          3,
+		StateConfigMap: "kube-ns-gc-failures",
+		StateNamespace: "kube-ns-gc",
+	})
+	gc.argoCD = newTestArgoCDClient(&ArgoCDConfig{Action: ArgoCDActionSuspend, Destinations: DefaultArgoCDDestinations},
+		newTestApplication("preview-1-api", "https://kubernetes.default.svc", "preview-1", nil))
+
+	// The uninstall fails and the namespace is held, the Application must
+	// already be suspended so that it does not sync the release back
+	if _, err := runCleanup(t, gc, "run-1"); err == nil {
+		t.Fatal("Expected the namespace to be held")
+	}
+	app, err := gc.argoCD.client.Resource(argoCDApplicationsResource).Namespace("argocd").Get(context.Background(), "preview-1-api", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if app.GetAnnotations()[ArgoCDSuspendedAnnotation] == "" {
+		t.Error("Expected the Application to be suspended before the Helm releases are uninstalled")
+	}
+}
+
+func TestPerformCleanupKeepsExpiryAnnotationsWithoutApplications(t *testing.T) {
+	gc, _, notifier := newEscalationTestGC(t, FailureEscalationConfig{
+		Enabled:        true,
+		After:          3,
+		StateConfigMap: "kube-ns-gc-failures",
+		StateNamespace: "kube-ns-gc",
+	})
+	gc.config.ExpiryAnnotations = true
+	getter, err := newRESTConfigGetter(&rest.Config{Host: "https://127.0.0.1:6443"})
+	if err != nil {
+		t.Fatalf("newRESTConfigGetter failed: %v", err)
+	}
+	gc.helmClient.getter = getter
+	gc.argoCD = newTestArgoCDClient(&ArgoCDConfig{Action: ArgoCDActionDelete, Destinations: DefaultArgoCDDestinations})
+	gc.argoCD.client.(*dynamicfake.FakeDynamicClient).PrependReactor("list", "applications", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("applications.argoproj.io is forbidden")
+	})
+	clientset := gc.clientset.(*fake.Clientset)
+	ns, err := clientset.CoreV1().Namespaces().Get(context.Background(), "preview-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get namespace: %v", err)
+	}
+	ns.Annotations = expiryAnnotations(ns, gc.config.NamespaceMaxAge)
+	if _, err := clientset.CoreV1().Namespaces().Update(context.Background(), ns, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to annotate namespace: %v", err)
+	}
+	clientset.ClearActions()
+
+	gc.performCleanup()
+
+	for _, action := range clientset.Actions() {
+		if action.GetResource().Resource == "namespaces" && action.GetVerb() != "list" && action.GetVerb() != "get" {
+			t.Errorf("Expected namespaces to be left alone while the Applications are unknown, got %s", action.GetVerb())
+		}
+	}
+	ns, err = clientset.CoreV1().Namespaces().Get(context.Background(), "preview-1", metav1.GetOptions{})
+	if err != nil || ns.Annotations[ExpiresAtAnnotation] == "" {
+		t.Errorf("Expected the expiry annotations to be kept, got %v, %v", ns.Annotations, err)
+	}
+	var unavailable bool
+	for _, event := range notifier.events {
+		unavailable = unavailable || event.Failure == FailureArgoCDUnavailable
+	}
+	if !unavailable {
+		t.Error("Expected the missing Applications to be reported")
+	}
+
+	unknown := gc.ownedElsewhere(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1"}}, nil, nil, unlistedArgoCDApplications(fmt.Errorf("forbidden")))
+	if !isUnknownOwner(unknown) {
+		t.Errorf("Expected an unknown owner, got %v", unknown)
+	}
+	blocked := argoCDBlock([]ArgoCDApplication{{Namespace: "argocd", Name: "db", Action: ArgoCDActionBlock}})
+	if blocked == nil || isUnknownOwner(blocked) {
+		t.Errorf("Expected a known owner, got %v", blocked)
+	}
+}
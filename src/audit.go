@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Audited actions.
+const (
+	AuditActionNamespaceDelete   = "namespace_delete"
+	AuditActionHelmUninstall     = "helm_uninstall"
+	AuditActionHelmPurge         = "helm_purge"
+	AuditActionArgoCDDelete      = "argocd_delete"
+	AuditActionArgoCDSuspend     = "argocd_suspend"
+	AuditActionFluxDelete        = "flux_delete"
+	AuditActionNamespaceApprove  = "namespace_approve"
+	AuditActionNamespacePostpone = "namespace_postpone"
+	AuditActionNamespaceProtect  = "namespace_protect"
+)
+
+// Audit outcomes.
+const (
+	AuditOutcomeSuccess = "success"
+	AuditOutcomeFailure = "failure"
+	AuditOutcomeDryRun  = "dry_run"
+)
+
+// Actor types.
+const (
+	AuditActorScheduler = "scheduler"
+	AuditActorTelegram  = "telegram"
+)
+
+// AuditConfig configures the audit log of destructive actions.
+type AuditConfig struct {
+	Enabled bool `json:"enabled"`
+	// Output is "stdout" or the path of a file entries are appended to
+	Output string            `json:"output"`
+	Syslog AuditSyslogConfig `json:"syslog"`
+}
+
+// AuditSyslogConfig forwards audit entries to syslog. An empty address uses
+// the local syslog daemon.
+type AuditSyslogConfig struct {
+	Enabled bool   `json:"enabled"`
+	Network string `json:"network"`
+	Address string `json:"address"`
+	Tag     string `json:"tag"`
+}
+
+// AuditActor is who triggered an action.
+type AuditActor struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+// AuditEntry is one line of the audit log.
+type AuditEntry struct {
+	Time        time.Time    `json:"time"`
+	Cluster     string       `json:"cluster,omitempty"`
+	RunID       string       `json:"run_id,omitempty"`
+	Action      string       `json:"action"`
+	Actor       AuditActor   `json:"actor"`
+	Namespace   string       `json:"namespace"`
+	Release     string       `json:"release,omitempty"`
+	ReleaseInfo *HelmRelease `json:"release_info,omitempty"`
+	// Application is the namespace/name of an Argo CD Application
+	Application string `json:"application,omitempty"`
+	// FluxObject is the kind and namespace/name of a Flux object
+	FluxObject string `json:"flux_object,omitempty"`
+	// Attempts of a helm_uninstall, more than one when retried without hooks
+	Attempts    []UninstallAttempt `json:"attempts,omitempty"`
+	Policy      string             `json:"policy,omitempty"`
+	Rule        string             `json:"rule,omitempty"`
+	Labels      map[string]string  `json:"labels,omitempty"`
+	Annotations map[string]string  `json:"annotations,omitempty"`
+	Outcome     string             `json:"outcome"`
+	Error       string             `json:"error,omitempty"`
+}
+
+// AuditLogger appends audit entries as JSON lines. It is safe for concurrent
+// use; a nil *AuditLogger records nothing.
+type AuditLogger struct {
+	logger *logrus.Logger
+
+	mu     sync.Mutex
+	out    io.Writer
+	file   *os.File
+	syslog *syslog.Writer
+}
+
+func NewAuditLogger(config *AuditConfig, logger *logrus.Logger) (*AuditLogger, error) {
+	a := &AuditLogger{logger: logger, out: os.Stdout}
+
+	if config.Output != "" && config.Output != "stdout" {
+		file, err := os.OpenFile(config.Output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log: %v", err)
+		}
+		a.out, a.file = file, file
+	}
+
+	if config.Syslog.Enabled {
+		tag := config.Syslog.Tag
+		if tag == "" {
+			tag = "kube-ns-gc"
+		}
+		writer, err := syslog.Dial(config.Syslog.Network, config.Syslog.Address, syslog.LOG_NOTICE|syslog.LOG_AUTH, tag)
+		if err != nil {
+			a.Close()
+			return nil, fmt.Errorf("failed to connect to syslog: %v", err)
+		}
+		a.syslog = writer
+	}
+
+	return a, nil
+}
+
+// Record writes entry to the audit log and to syslog if configured. Failures
+// are logged, they never stop the action being audited.
+func (a *AuditLogger) Record(entry AuditEntry) {
+	if a == nil {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		a.logger.Errorf("Failed to marshal audit entry: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.out.Write(append(line, '\n')); err != nil {
+		a.logger.Errorf("Failed to write audit entry: %v", err)
+	}
+	if a.syslog != nil {
+		if err := a.syslog.Notice(string(line)); err != nil {
+			a.logger.Errorf("Failed to forward audit entry to syslog: %v", err)
+		}
+	}
+}
+
+// Close closes the audit file and the syslog connection.
+func (a *AuditLogger) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var err error
+	if a.syslog != nil {
+		err = a.syslog.Close()
+		a.syslog = nil
+	}
+	if a.file != nil {
+		if closeErr := a.file.Close(); closeErr != nil {
+			err = closeErr
+		}
+		a.file = nil
+		a.out = io.Discard
+	}
+	return err
+}
+
+// newAuditEntry returns an entry about ns with a snapshot of its labels and
+// annotations, taken before the action changes them.
+func newAuditEntry(action string, ns *v1.Namespace, actor AuditActor, err error) AuditEntry {
+	entry := AuditEntry{
+		Time:        time.Now(),
+		Action:      action,
+		Actor:       actor,
+		Namespace:   ns.Name,
+		Labels:      copyStringMap(ns.Labels),
+		Annotations: copyStringMap(ns.Annotations),
+		Outcome:     AuditOutcomeSuccess,
+	}
+	if err != nil {
+		entry.Outcome = AuditOutcomeFailure
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
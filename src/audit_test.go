@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testAuditNamespace() *v1.Namespace {
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:        "preview-1",
+		Labels:      map[string]string{"team": "web"},
+		Annotations: map[string]string{"kube-ns-gc/owner": "web@example.com"},
+	}}
+}
+
+func TestAuditLoggerAppendsJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(path, []byte("{\"action\":\"earlier\"}\n"), 0o640); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	audit, err := NewAuditLogger(&AuditConfig{Output: path}, logrus.New())
+	if err != nil {
+		t.Fatalf("NewAuditLogger failed: %v", err)
+	}
+
+	ns := testAuditNamespace()
+	entry := newAuditEntry(AuditActionNamespaceDelete, ns, AuditActor{Type: AuditActorScheduler}, nil)
+	entry.RunID = "run-1"
+	audit.Record(entry)
+
+	release := newAuditEntry(AuditActionHelmUninstall, ns, AuditActor{Type: AuditActorTelegram, Name: "@alice"}, errors.New("timed out"))
+	release.Release = "api"
+	audit.Record(release)
+
+	// The snapshot must not change with the namespace
+	ns.Labels["team"] = "changed"
+
+	if err := audit.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer file.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 3 || entries[0].Action != "earlier" {
+		t.Fatalf("Expected 2 entries appended to the existing one, got %+v", entries)
+	}
+
+	deleted := entries[1]
+	if deleted.Action != AuditActionNamespaceDelete || deleted.Outcome != AuditOutcomeSuccess || deleted.Actor.Type != AuditActorScheduler || deleted.RunID != "run-1" {
+		t.Errorf("Unexpected deletion entry: %+v", deleted)
+	}
+	if deleted.Labels["team"] != "web" || deleted.Annotations["kube-ns-gc/owner"] != "web@example.com" {
+		t.Errorf("Expected a snapshot of labels and annotations, got %+v", deleted)
+	}
+	if deleted.Time.IsZero() || deleted.Time.Location() != time.UTC {
+		t.Errorf("Expected a UTC timestamp, got %v", deleted.Time)
+	}
+
+	uninstall := entries[2]
+	if uninstall.Outcome != AuditOutcomeFailure || uninstall.Error != "timed out" || uninstall.Release != "api" || uninstall.Actor.Name != "@alice" {
+		t.Errorf("Unexpected uninstall entry: %+v", uninstall)
+	}
+}
+
+func TestAuditLoggerForwardsToSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	defer conn.Close()
+
+	audit, err := NewAuditLogger(&AuditConfig{
+		Output: filepath.Join(t.TempDir(), "audit.log"),
+		Syslog: AuditSyslogConfig{Enabled: true, Network: "udp", Address: conn.LocalAddr().String()},
+	}, logrus.New())
+	if err != nil {
+		t.Fatalf("NewAuditLogger failed: %v", err)
+	}
+	defer audit.Close()
+
+	audit.Record(newAuditEntry(AuditActionNamespaceProtect, testAuditNamespace(), AuditActor{Type: AuditActorTelegram, Name: "@bob"}, nil))
+
+	buf := make([]byte, 4096)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("No syslog message received: %v", err)
+	}
+	message := string(buf[:n])
+	if !strings.Contains(message, "kube-ns-gc") || !strings.Contains(message, `"action":"namespace_protect"`) {
+		t.Errorf("Unexpected syslog message: %s", message)
+	}
+}
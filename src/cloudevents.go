@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CloudEvents content modes.
+const (
+	CloudEventsModeStructured = "structured"
+	CloudEventsModeBinary     = "binary"
+)
+
+// DefaultCloudEventsSchemaURL is where the JSON schemas of the event data are published.
+const DefaultCloudEventsSchemaURL = "https://raw.githubusercontent.com/muroed/kube-ns-gc/main/schemas/cloudevents"
+
+// cloudEventTypes maps notification events to CloudEvents types and the name
+// of their data schema. Types are versioned and never change meaning.
+var cloudEventTypes = map[string]struct{ ceType, schema string }{
+	EventNamespaceExpiring:  {"io.kube-ns-gc.namespace.expiring.v1", "namespace.v1.json"},
+	EventNamespaceDeleted:   {"io.kube-ns-gc.namespace.deleted.v1", "namespace.v1.json"},
+	EventHelmReleaseDeleted: {"io.kube-ns-gc.release.uninstalled.v1", "release.v1.json"},
+	EventRunStarted:         {"io.kube-ns-gc.run.started.v1", "run.v1.json"},
+	EventCleanupSummary:     {"io.kube-ns-gc.run.finished.v1", "run.v1.json"},
+}
+
+// CloudEventsConfig configures the CloudEvents sink, which posts lifecycle
+// events to one or more HTTP endpoints.
+type CloudEventsConfig struct {
+	Enabled   bool                  `json:"enabled"`
+	Endpoints []CloudEventsEndpoint `json:"endpoints"`
+	// Source overrides the event source, which defaults to /kube-ns-gc/<cluster_name>
+	Source        string        `json:"source"`
+	SchemaBaseURL string        `json:"schema_base_url"`
+	Timeout       time.Duration `json:"timeout"`
+	RetryConfig
+}
+
+type CloudEventsEndpoint struct {
+	URL string `json:"url"`
+	// Mode is structured (the default) or binary
+	Mode    string            `json:"mode"`
+	Headers map[string]string `json:"headers"`
+}
+
+// CloudEvent is a CloudEvents 1.0 event in the JSON format.
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Subject         string      `json:"subject,omitempty"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	DataSchema      string      `json:"dataschema"`
+	Data            interface{} `json:"data"`
+}
+
+type CloudEventRelease struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	RunID     string `json:"run_id,omitempty"`
+}
+
+type CloudEventRun struct {
+	RunID   string          `json:"run_id"`
+	Summary *WebhookSummary `json:"summary,omitempty"`
+}
+
+type cloudEventsTarget struct {
+	endpoint CloudEventsEndpoint
+	queue    *deliveryQueue
+}
+
+// CloudEventsNotifier sends lifecycle events as CloudEvents over HTTP. Every
+// endpoint has its own queue, so a slow one does not hold up the others.
+type CloudEventsNotifier struct {
+	config  *CloudEventsConfig
+	logger  *logrus.Logger
+	client  *http.Client
+	source  string
+	targets []cloudEventsTarget
+}
+
+func NewCloudEventsNotifier(config *CloudEventsConfig, clusterName string, logger *logrus.Logger) (*CloudEventsNotifier, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, fmt.Errorf("at least one cloudevents endpoint is required")
+	}
+	for _, endpoint := range config.Endpoints {
+		parsed, err := url.Parse(endpoint.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid cloudevents endpoint url %q", endpoint.URL)
+		}
+		if endpoint.Mode != "" && endpoint.Mode != CloudEventsModeStructured && endpoint.Mode != CloudEventsModeBinary {
+			return nil, fmt.Errorf("invalid cloudevents mode %q for %s", endpoint.Mode, endpoint.URL)
+		}
+	}
+
+	source := config.Source
+	if source == "" {
+		source = "/kube-ns-gc"
+		if clusterName != "" {
+			source += "/" + url.PathEscape(clusterName)
+		}
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	n := &CloudEventsNotifier{
+		config: config,
+		logger: logger,
+		client: &http.Client{Timeout: timeout},
+		source: source,
+	}
+	for i, endpoint := range config.Endpoints {
+		n.targets = append(n.targets, cloudEventsTarget{
+			endpoint: endpoint,
+			queue:    newDeliveryQueue(fmt.Sprintf("cloudevents[%d]", i), config.RetryConfig, logger),
+		})
+	}
+	return n, nil
+}
+
+func (n *CloudEventsNotifier) Notify(event NotificationEvent) error {
+	ce, ok := n.cloudEvent(event)
+	if !ok {
+		return nil
+	}
+
+	structured, err := json.Marshal(ce)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cloudevent: %v", err)
+	}
+	data, err := json.Marshal(ce.Data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cloudevent data: %v", err)
+	}
+
+	var errs []string
+	for _, target := range n.targets {
+		endpoint := target.endpoint
+		body, headers := structured, map[string]string{"Content-Type": "application/cloudevents+json; charset=utf-8"}
+		if endpoint.Mode == CloudEventsModeBinary {
+			body, headers = data, binaryCloudEventHeaders(ce)
+		}
+		for name, value := range endpoint.Headers {
+			headers[name] = value
+		}
+
+		if err := target.queue.Enqueue(ce.Type+" event", func(ctx context.Context) error {
+			return postJSON(ctx, n.client, endpoint.URL, body, headers, nil)
+		}); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to queue cloudevent: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (n *CloudEventsNotifier) Close(ctx context.Context) error {
+	var errs []string
+	for _, target := range n.targets {
+		if err := target.queue.Close(ctx); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// cloudEvent converts event, returning false for events that are not emitted.
+// Dry runs are not emitted, the types describe things that happened.
+func (n *CloudEventsNotifier) cloudEvent(event NotificationEvent) (CloudEvent, bool) {
+	mapping, ok := cloudEventTypes[event.Type]
+	if !ok || event.DryRun {
+		return CloudEvent{}, false
+	}
+
+	payload := newWebhookEvent(event)
+	schemaBase := n.config.SchemaBaseURL
+	if schemaBase == "" {
+		schemaBase = DefaultCloudEventsSchemaURL
+	}
+
+	ce := CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              payload.ID,
+		Source:          n.source,
+		Type:            mapping.ceType,
+		Time:            payload.Time,
+		DataContentType: "application/json",
+		DataSchema:      strings.TrimSuffix(schemaBase, "/") + "/" + mapping.schema,
+	}
+
+	switch event.Type {
+	case EventNamespaceExpiring, EventNamespaceDeleted:
+		ce.Subject = event.Namespace
+		ce.Data = payload.Namespace
+	case EventHelmReleaseDeleted:
+		ce.Subject = event.Namespace + "/" + event.Release
+		ce.Data = CloudEventRelease{Name: event.Release, Namespace: event.Namespace, RunID: event.RunID}
+	case EventRunStarted, EventCleanupSummary:
+		ce.Subject = event.RunID
+		ce.Data = CloudEventRun{RunID: event.RunID, Summary: payload.Summary}
+	}
+	return ce, true
+}
+
+// binaryCloudEventHeaders returns the ce- headers of the binary content mode.
+func binaryCloudEventHeaders(ce CloudEvent) map[string]string {
+	headers := map[string]string{"Content-Type": ce.DataContentType}
+	attributes := map[string]string{
+		"specversion": ce.SpecVersion,
+		"id":          ce.ID,
+		"source":      ce.Source,
+		"type":        ce.Type,
+		"time":        ce.Time.Format(time.RFC3339Nano),
+		"dataschema":  ce.DataSchema,
+	}
+	if ce.Subject != "" {
+		attributes["subject"] = ce.Subject
+	}
+	for name, value := range attributes {
+		headers["ce-"+name] = cloudEventHeaderValue(value)
+	}
+	return headers
+}
+
+// cloudEventHeaderValue percent-encodes the UTF-8 bytes of space, '"', '%'
+// and of everything outside printable ASCII, as the HTTP binding requires
+// for ce- headers.
+func cloudEventHeaderValue(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// cloudEventsEndpointsFromEnv builds the endpoints from CLOUDEVENTS_URLS, a
+// comma-separated list sharing the CLOUDEVENTS_MODE content mode.
+func cloudEventsEndpointsFromEnv() []CloudEventsEndpoint {
+	var endpoints []CloudEventsEndpoint
+	for _, endpointURL := range getEnvStringSlice("CLOUDEVENTS_URLS", nil) {
+		if endpointURL = strings.TrimSpace(endpointURL); endpointURL != "" {
+			endpoints = append(endpoints, CloudEventsEndpoint{
+				URL:  endpointURL,
+				Mode: getEnvString("CLOUDEVENTS_MODE", CloudEventsModeStructured),
+			})
+		}
+	}
+	return endpoints
+}
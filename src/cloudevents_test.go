@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestCloudEventsModes(t *testing.T) {
+	structured := newFakeWebhook(t)
+	binary := newFakeWebhook(t)
+	notifier, err := NewCloudEventsNotifier(&CloudEventsConfig{
+		Endpoints: []CloudEventsEndpoint{
+			{URL: structured.server.URL},
+			{URL: binary.server.URL, Mode: CloudEventsModeBinary, Headers: map[string]string{"Authorization": "Bearer token"}},
+		},
+		RetryConfig: RetryConfig{InitialBackoff: time.Millisecond},
+	}, "prod", logrus.New())
+	if err != nil {
+		t.Fatalf("NewCloudEventsNotifier failed: %v", err)
+	}
+
+	event := NotificationEvent{
+		ID:        "event-1",
+		Type:      EventNamespaceDeleted,
+		Time:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		RunID:     "run-1",
+		Namespace: "preview-1",
+		Labels:    map[string]string{"team": "web"},
+	}
+	if err := notifier.Notify(event); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	closeNotifier(t, notifier)
+
+	requests := structured.received()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 structured request, got %d", len(requests))
+	}
+	if contentType := requests[0].Header.Get("Content-Type"); contentType != "application/cloudevents+json; charset=utf-8" {
+		t.Errorf("Unexpected content type %q", contentType)
+	}
+	var ce struct {
+		CloudEvent
+		Data WebhookNamespace `json:"data"`
+	}
+	if err := json.Unmarshal(requests[0].Body, &ce); err != nil {
+		t.Fatalf("Invalid cloudevent: %v", err)
+	}
+	if ce.SpecVersion != "1.0" || ce.ID != "event-1" || ce.Source != "/kube-ns-gc/prod" || ce.Type != "io.kube-ns-gc.namespace.deleted.v1" || ce.Subject != "preview-1" {
+		t.Errorf("Unexpected envelope: %+v", ce.CloudEvent)
+	}
+	if ce.DataSchema != DefaultCloudEventsSchemaURL+"/namespace.v1.json" {
+		t.Errorf("Unexpected data schema %q", ce.DataSchema)
+	}
+	if ce.Data.Name != "preview-1" || ce.Data.Labels["team"] != "web" {
+		t.Errorf("Unexpected data: %+v", ce.Data)
+	}
+
+	requests = binary.received()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 binary request, got %d", len(requests))
+	}
+	header := requests[0].Header
+	if header.Get("ce-specversion") != "1.0" || header.Get("ce-id") != "event-1" || header.Get("ce-type") != "io.kube-ns-gc.namespace.deleted.v1" ||
+		header.Get("ce-source") != "/kube-ns-gc/prod" || header.Get("ce-subject") != "preview-1" || header.Get("ce-time") != "2024-01-02T03:04:05Z" {
+		t.Errorf("Unexpected binary headers: %v", header)
+	}
+	if header.Get("Content-Type") != "application/json" || header.Get("Authorization") != "Bearer token" {
+		t.Errorf("Unexpected headers: %v", header)
+	}
+	var data WebhookNamespace
+	if err := json.Unmarshal(requests[0].Body, &data); err != nil || data.Name != "preview-1" {
+		t.Errorf("Expected namespace data as the body, got %s", requests[0].Body)
+	}
+}
+
+func TestCloudEventsTypes(t *testing.T) {
+	hook := newFakeWebhook(t)
+	notifier, err := NewCloudEventsNotifier(&CloudEventsConfig{
+		Endpoints:     []CloudEventsEndpoint{{URL: hook.server.URL, Mode: CloudEventsModeBinary}},
+		Source:        "urn:cluster:staging",
+		SchemaBaseURL: "https://schemas.example.com/",
+	}, "", logrus.New())
+	if err != nil {
+		t.Fatalf("NewCloudEventsNotifier failed: %v", err)
+	}
+
+	events := []NotificationEvent{
+		{Type: EventRunStarted, RunID: "run-1"},
+		{Type: EventNamespaceExpiring, RunID: "run-1", Namespace: "preview-1", ExpiresAt: time.Now().Add(time.Hour)},
+		{Type: EventHelmReleaseDeleted, RunID: "run-1", Namespace: "preview-2", Release: "api"},
+		{Type: EventHelmReleaseDeleted, RunID: "run-1", Namespace: "dev", Release: "pr-42", Policy: PolicyReleaseGC, DryRun: true},
+		{Type: EventStartup, Message: "started"},
+		{Type: EventError, RunID: "run-1", Message: "boom"},
+		{Type: EventCleanupSummary, RunID: "run-1", Report: &CleanupReport{}},
+	}
+	for _, event := range events {
+		if err := notifier.Notify(event); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+	}
+	closeNotifier(t, notifier)
+
+	expected := []struct{ ceType, subject, schema string }{
+		{"io.kube-ns-gc.run.started.v1", "run-1", "https://schemas.example.com/run.v1.json"},
+		{"io.kube-ns-gc.namespace.expiring.v1", "preview-1", "https://schemas.example.com/namespace.v1.json"},
+		{"io.kube-ns-gc.release.uninstalled.v1", "preview-2/api", "https://schemas.example.com/release.v1.json"},
+		{"io.kube-ns-gc.run.finished.v1", "run-1", "https://schemas.example.com/run.v1.json"},
+	}
+	requests := hook.received()
+	if len(requests) != len(expected) {
+		t.Fatalf("Expected %d events, got %d", len(expected), len(requests))
+	}
+	for i, want := range expected {
+		header := requests[i].Header
+		if header.Get("ce-type") != want.ceType || header.Get("ce-subject") != want.subject || header.Get("ce-dataschema") != want.schema || header.Get("ce-source") != "urn:cluster:staging" {
+			t.Errorf("Event %d: unexpected headers %v", i, header)
+		}
+	}
+
+	var run CloudEventRun
+	if err := json.Unmarshal(requests[3].Body, &run); err != nil || run.RunID != "run-1" || run.Summary == nil {
+		t.Errorf("Expected run data with a summary, got %s", requests[3].Body)
+	}
+}
+
+func TestCloudEventsConfigValidation(t *testing.T) {
+	configs := []CloudEventsConfig{
+		{},
+		{Endpoints: []CloudEventsEndpoint{{URL: "ftp://example.com"}}},
+		{Endpoints: []CloudEventsEndpoint{{URL: "https://example.com", Mode: "batched"}}},
+	}
+	for _, config := range configs {
+		if _, err := NewCloudEventsNotifier(&config, "prod", logrus.New()); err == nil {
+			t.Errorf("Expected config %+v to be rejected", config)
+		}
+	}
+}
+
+func TestCloudEventHeaderValue(t *testing.T) {
+	tests := map[string]string{
+		"preview-1/api":   "preview-1/api",
+		"preview 1":       "preview%201",
+		`say "100%"`:      "say%20%22100%25%22",
+		"ns-é":            "ns-%C3%A9",
+		"line\nbreak\x7f": "line%0Abreak%7F",
+	}
+	for value, expected := range tests {
+		if encoded := cloudEventHeaderValue(value); encoded != expected {
+			t.Errorf("cloudEventHeaderValue(%q) = %q, expected %q", value, encoded, expected)
+		}
+	}
+
+	headers := binaryCloudEventHeaders(CloudEvent{SpecVersion: "1.0", Subject: "run 1", DataContentType: "application/json"})
+	if headers["ce-subject"] != "run%201" || headers["Content-Type"] != "application/json" {
+		t.Errorf("Unexpected binary headers: %v", headers)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RetryConfig controls asynchronous delivery of HTTP notification sinks.
+type RetryConfig struct {
+	QueueSize      int           `json:"queue_size"`
+	MaxRetries     int           `json:"max_retries"`
+	InitialBackoff time.Duration `json:"initial_backoff"`
+	MaxBackoff     time.Duration `json:"max_backoff"`
+}
+
+// HTTPStatusError is returned when an endpoint answers with a non-2xx status.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.Body != "" {
+		return fmt.Sprintf("%s returned status %d: %s", e.URL, e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("%s returned status %d", e.URL, e.StatusCode)
+}
+
+// Status returns the HTTP status of the answer and how long it asked to wait.
+func (e *HTTPStatusError) Status() (int, time.Duration) {
+	return e.StatusCode, e.RetryAfter
+}
+
+// statusError is a delivery failure answered with an HTTP status.
+type statusError interface {
+	error
+	Status() (code int, retryAfter time.Duration)
+}
+
+// PermanentError marks a delivery failure that will not go away on retry.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// postJSON POSTs body to url and returns an HTTPStatusError for non-2xx answers.
+// If result is not nil, a successful JSON response is decoded into it.
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte, headers map[string]string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "kube-ns-gc")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		statusErr := &HTTPStatusError{URL: url, StatusCode: resp.StatusCode, Body: truncate(string(data), 200)}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			statusErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return statusErr
+	}
+
+	if result != nil {
+		if err := json.Unmarshal(data, result); err != nil {
+			// The request went through, so retrying could deliver it twice
+			return &PermanentError{Err: fmt.Errorf("failed to decode response from %s: %v", url, err)}
+		}
+	}
+	return nil
+}
+
+type delivery struct {
+	description string
+	send        func(ctx context.Context) error
+}
+
+// deliveryQueue sends notifications in the background so a slow or broken
+// endpoint never blocks a cleanup run. Failed deliveries are retried with
+// exponential backoff on 429, 5xx and network errors.
+type deliveryQueue struct {
+	name   string
+	config RetryConfig
+	logger *logrus.Logger
+
+	mu      sync.Mutex
+	closing bool
+	items   chan delivery
+
+	done    chan struct{}
+	stopCtx context.Context
+	stop    context.CancelFunc
+}
+
+func newDeliveryQueue(name string, config RetryConfig, logger *logrus.Logger) *deliveryQueue {
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 5 * time.Minute
+	}
+
+	stopCtx, stop := context.WithCancel(context.Background())
+	q := &deliveryQueue{
+		name:    name,
+		config:  config,
+		logger:  logger,
+		items:   make(chan delivery, config.QueueSize),
+		done:    make(chan struct{}),
+		stopCtx: stopCtx,
+		stop:    stop,
+	}
+	go q.run()
+	return q
+}
+
+// Enqueue schedules send for delivery.
+func (q *deliveryQueue) Enqueue(description string, send func(ctx context.Context) error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closing {
+		return fmt.Errorf("%s queue is closed", q.name)
+	}
+
+	select {
+	case q.items <- delivery{description: description, send: send}:
+		return nil
+	default:
+		return fmt.Errorf("%s queue is full (%d items)", q.name, q.config.QueueSize)
+	}
+}
+
+// Close stops accepting deliveries and drains the queue until ctx is done.
+func (q *deliveryQueue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closing {
+		q.closing = true
+		close(q.items)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		q.stop()
+		<-q.done
+	}
+
+	if pending := len(q.items); pending > 0 {
+		return fmt.Errorf("%d %s deliveries not sent", pending, q.name)
+	}
+	return nil
+}
+
+func (q *deliveryQueue) run() {
+	defer close(q.done)
+
+	for item := range q.items {
+		if q.stopCtx.Err() != nil {
+			return
+		}
+		q.deliver(item)
+	}
+}
+
+func (q *deliveryQueue) deliver(item delivery) {
+	for attempt := 1; ; attempt++ {
+		err := item.send(q.stopCtx)
+		if err == nil {
+			q.logger.Debugf("Delivered %s via %s", item.description, q.name)
+			return
+		}
+		if q.stopCtx.Err() != nil {
+			return
+		}
+
+		delay, retry := retryDelay(err, attempt, q.config.InitialBackoff, q.config.MaxBackoff)
+		if !retry || (q.config.MaxRetries > 0 && attempt > q.config.MaxRetries) {
+			q.logger.Errorf("Dropping %s for %s after %d attempts: %v", item.description, q.name, attempt, err)
+			return
+		}
+
+		q.logger.Warnf("Delivery of %s via %s failed (attempt %d), retrying in %s: %v", item.description, q.name, attempt, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-q.stopCtx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// retryDelay decides whether a delivery that failed with err in attempt is
+// retried and after how long. 429, 5xx and network errors are retried with
+// exponential backoff, or after the wait the endpoint asked for.
+func retryDelay(err error, attempt int, initialBackoff, maxBackoff time.Duration) (time.Duration, bool) {
+	backoff := initialBackoff << uint(attempt-1)
+	if backoff <= 0 || backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	var permanent *PermanentError
+	if errors.As(err, &permanent) {
+		return 0, false
+	}
+
+	var statusErr statusError
+	if !errors.As(err, &statusErr) {
+		// Network errors are transient
+		return backoff, true
+	}
+
+	code, retryAfter := statusErr.Status()
+	switch {
+	case code == http.StatusTooManyRequests || code >= 500:
+		if retryAfter > 0 {
+			return retryAfter, true
+		}
+		return backoff, true
+	default:
+		// Other 4xx responses will not succeed on retry
+		return 0, false
+	}
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultOwnerAnnotation holds the email addresses of a namespace's owners.
+const DefaultOwnerAnnotation = "kube-ns-gc/owner"
+
+// EmailConfig configures the SMTP sink. It mails pre-deletion warnings and
+// deletion receipts to namespace owners; everything else is ignored.
+type EmailConfig struct {
+	Enabled  bool   `json:"enabled"`
+	SMTPHost string `json:"smtp_host"`
+	SMTPPort int    `json:"smtp_port"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+	// StartTLS requires the server to upgrade the connection before authentication
+	StartTLS bool `json:"starttls"`
+	// OwnerAnnotation holds a comma-separated list of owner addresses
+	OwnerAnnotation string `json:"owner_annotation"`
+	// FallbackRecipients get the mail for namespaces without a valid owner
+	FallbackRecipients []string             `json:"fallback_recipients"`
+	NotifyExpiring     bool                 `json:"notify_expiring"`
+	NotifyDeleted      bool                 `json:"notify_deleted"`
+	Timeout            time.Duration        `json:"timeout"`
+	Templates          EmailTemplatesConfig `json:"templates"`
+	RetryConfig
+}
+
+// EmailTemplatesConfig overrides the mail templates. An inline template wins
+// over a <name>.tmpl file in Dir (subject.tmpl, text.tmpl, html.tmpl), which
+// wins over the built-in default.
+type EmailTemplatesConfig struct {
+	Dir     string `json:"dir"`
+	Subject string `json:"subject"`
+	Text    string `json:"text"`
+	HTML    string `json:"html"`
+}
+
+// EmailNamespace is a namespace listed in a mail.
+type EmailNamespace struct {
+	Name      string
+	Labels    map[string]string
+	Age       time.Duration
+	ExpiresAt time.Time
+	Releases  []string
+	Time      time.Time
+}
+
+// EmailData is the data the mail templates are executed with. It holds every
+// namespace of one recipient in a cleanup run.
+type EmailData struct {
+	Recipient   string
+	ClusterName string
+	RunID       string
+	Expiring    []EmailNamespace
+	Deleted     []EmailNamespace
+}
+
+const defaultEmailSubject = `[kube-ns-gc]{{ if .ClusterName }} {{ .ClusterName }}:{{ end }}
+{{- if .Expiring }} {{ len .Expiring }} namespace(s) scheduled for deletion{{ end }}
+{{- if and .Expiring .Deleted }},{{ end }}
+{{- if .Deleted }} {{ len .Deleted }} namespace(s) deleted{{ end }}`
+
+const defaultEmailText = `Hello,
+{{ if .Expiring }}
+The following namespaces{{ if .ClusterName }} in cluster {{ .ClusterName }}{{ end }} are scheduled for deletion by kube-ns-gc:
+{{ range .Expiring }}
+  - {{ .Name }}: after {{ time .ExpiresAt }} (age {{ age .Age }})
+{{- end }}
+
+If you still need a namespace, ask the cluster administrators to postpone its deletion.
+{{ end }}{{ if .Deleted }}
+The following namespaces{{ if .ClusterName }} in cluster {{ .ClusterName }}{{ end }} were deleted by kube-ns-gc:
+{{ range .Deleted }}
+  - {{ .Name }}: deleted at {{ time .Time }} (age {{ age .Age }}){{ if .Releases }}, Helm releases: {{ join .Releases ", " }}{{ end }}
+{{- end }}
+{{ end }}
+You receive this mail as an owner of these namespaces.
+`
+
+const defaultEmailHTML = `<p>Hello,</p>
+{{ if .Expiring }}
+<p>The following namespaces{{ if .ClusterName }} in cluster <b>{{ .ClusterName }}</b>{{ end }} are scheduled for deletion by kube-ns-gc:</p>
+<ul>
+{{- range .Expiring }}
+  <li><code>{{ .Name }}</code>: after {{ time .ExpiresAt }} (age {{ age .Age }})</li>
+{{- end }}
+</ul>
+<p>If you still need a namespace, ask the cluster administrators to postpone its deletion.</p>
+{{ end }}{{ if .Deleted }}
+<p>The following namespaces{{ if .ClusterName }} in cluster <b>{{ .ClusterName }}</b>{{ end }} were deleted by kube-ns-gc:</p>
+<ul>
+{{- range .Deleted }}
+  <li><code>{{ .Name }}</code>: deleted at {{ time .Time }} (age {{ age .Age }}){{ if .Releases }}, Helm releases: {{ join .Releases ", " }}{{ end }}</li>
+{{- end }}
+</ul>
+{{ end }}
+<p><small>You receive this mail as an owner of these namespaces.</small></p>
+`
+
+// emailTemplates holds the parsed subject, plain text and HTML templates.
+type emailTemplates struct {
+	subject *template.Template
+	text    *template.Template
+	html    *htmltemplate.Template
+}
+
+func emailTemplateFuncs() map[string]interface{} {
+	return map[string]interface{}{
+		"age":  formatAge,
+		"join": strings.Join,
+		"time": func(t time.Time) string {
+			return t.Format("2006-01-02 15:04 MST")
+		},
+	}
+}
+
+// loadEmailTemplates parses the configured templates and checks that they
+// render for a sample mail, so mistakes surface at startup.
+func loadEmailTemplates(config *EmailTemplatesConfig) (*emailTemplates, error) {
+	source := func(name, inline, fallback string) (string, error) {
+		if inline != "" {
+			return inline, nil
+		}
+		if config.Dir != "" {
+			data, err := os.ReadFile(filepath.Join(config.Dir, name+".tmpl"))
+			if err == nil {
+				return string(data), nil
+			}
+			if !os.IsNotExist(err) {
+				return "", fmt.Errorf("failed to read %s template: %v", name, err)
+			}
+		}
+		return fallback, nil
+	}
+
+	sources := make(map[string]string)
+	for _, name := range []string{"subject", "text", "html"} {
+		inline, fallback := config.Subject, defaultEmailSubject
+		switch name {
+		case "text":
+			inline, fallback = config.Text, defaultEmailText
+		case "html":
+			inline, fallback = config.HTML, defaultEmailHTML
+		}
+		text, err := source(name, inline, fallback)
+		if err != nil {
+			return nil, err
+		}
+		sources[name] = text
+	}
+
+	var err error
+	t := &emailTemplates{}
+	if t.subject, err = template.New("subject").Funcs(emailTemplateFuncs()).Parse(sources["subject"]); err != nil {
+		return nil, fmt.Errorf("failed to parse subject template: %v", err)
+	}
+	if t.text, err = template.New("text").Funcs(emailTemplateFuncs()).Parse(sources["text"]); err != nil {
+		return nil, fmt.Errorf("failed to parse text template: %v", err)
+	}
+	if t.html, err = htmltemplate.New("html").Funcs(emailTemplateFuncs()).Parse(sources["html"]); err != nil {
+		return nil, fmt.Errorf("failed to parse html template: %v", err)
+	}
+
+	if _, _, _, err := t.Render(sampleEmailData()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Render returns the subject, plain text and HTML body of a mail.
+func (t *emailTemplates) Render(data *EmailData) (subject, text, html string, err error) {
+	var out bytes.Buffer
+	if err := t.subject.Execute(&out, data); err != nil {
+		return "", "", "", fmt.Errorf("failed to render subject template: %v", err)
+	}
+	subject = strings.Join(strings.Fields(out.String()), " ")
+
+	out.Reset()
+	if err := t.text.Execute(&out, data); err != nil {
+		return "", "", "", fmt.Errorf("failed to render text template: %v", err)
+	}
+	text = out.String()
+
+	out.Reset()
+	if err := t.html.Execute(&out, data); err != nil {
+		return "", "", "", fmt.Errorf("failed to render html template: %v", err)
+	}
+	return subject, text, out.String(), nil
+}
+
+func sampleEmailData() *EmailData {
+	namespace := EmailNamespace{
+		Name:      "preview-1",
+		Labels:    map[string]string{"team": "web"},
+		Age:       8 * 24 * time.Hour,
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+		Releases:  []string{"api", "web"},
+		Time:      time.Now(),
+	}
+	return &EmailData{
+		Recipient:   "owner@example.com",
+		ClusterName: "cluster",
+		RunID:       "run",
+		Expiring:    []EmailNamespace{namespace},
+		Deleted:     []EmailNamespace{namespace},
+	}
+}
+
+// EmailNotifier mails namespace owners. Mails within a cleanup run are
+// collected per recipient and sent as one when the run summary arrives.
+type EmailNotifier struct {
+	config    *EmailConfig
+	logger    *logrus.Logger
+	from      string
+	templates *emailTemplates
+	queue     *deliveryQueue
+
+	mu      sync.Mutex
+	pending map[string]*EmailData
+}
+
+func NewEmailNotifier(config *EmailConfig, logger *logrus.Logger) (*EmailNotifier, error) {
+	if config.SMTPHost == "" {
+		return nil, fmt.Errorf("smtp host is required")
+	}
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address %q: %v", config.From, err)
+	}
+	for _, recipient := range config.FallbackRecipients {
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			return nil, fmt.Errorf("invalid fallback recipient %q: %v", recipient, err)
+		}
+	}
+
+	templates, err := loadEmailTemplates(&config.Templates)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EmailNotifier{
+		config:    config,
+		logger:    logger,
+		from:      from.Address,
+		templates: templates,
+		queue:     newDeliveryQueue("email", config.RetryConfig, logger),
+		pending:   make(map[string]*EmailData),
+	}, nil
+}
+
+func (e *EmailNotifier) Notify(event NotificationEvent) error {
+	switch event.Type {
+	case EventNamespaceExpiring:
+		if !e.config.NotifyExpiring {
+			return nil
+		}
+	case EventNamespaceDeleted:
+		if !e.config.NotifyDeleted {
+			return nil
+		}
+	case EventCleanupSummary:
+		return e.flush()
+	default:
+		return nil
+	}
+
+	recipients := e.recipients(event)
+	if len(recipients) == 0 {
+		e.logger.Debugf("No email recipients for namespace %s", event.Namespace)
+		return nil
+	}
+
+	namespace := EmailNamespace{
+		Name:      event.Namespace,
+		Labels:    event.Labels,
+		Age:       event.Age,
+		ExpiresAt: event.ExpiresAt,
+		Releases:  event.Releases,
+		Time:      event.Time,
+	}
+	if namespace.Time.IsZero() {
+		namespace.Time = time.Now()
+	}
+
+	var direct []*EmailData
+	e.mu.Lock()
+	for _, recipient := range recipients {
+		data := e.pending[recipient]
+		if data == nil {
+			data = &EmailData{Recipient: recipient, ClusterName: event.ClusterName, RunID: event.RunID}
+		}
+		if event.Type == EventNamespaceExpiring {
+			data.Expiring = append(data.Expiring, namespace)
+		} else {
+			data.Deleted = append(data.Deleted, namespace)
+		}
+
+		// Events outside of a run have no summary to wait for
+		if event.RunID == "" {
+			direct = append(direct, data)
+			delete(e.pending, recipient)
+		} else {
+			e.pending[recipient] = data
+		}
+	}
+	e.mu.Unlock()
+
+	return e.send(direct)
+}
+
+// Close sends the mails still waiting for a run summary and flushes the queue.
+func (e *EmailNotifier) Close(ctx context.Context) error {
+	err := e.flush()
+	if closeErr := e.queue.Close(ctx); closeErr != nil {
+		return closeErr
+	}
+	return err
+}
+
+// recipients returns the owner addresses of the event's namespace, or the
+// fallback recipients if there are none.
+func (e *EmailNotifier) recipients(event NotificationEvent) []string {
+	annotation := e.config.OwnerAnnotation
+	if annotation == "" {
+		annotation = DefaultOwnerAnnotation
+	}
+
+	if value := strings.TrimSpace(event.Annotations[annotation]); value != "" {
+		addresses, err := mail.ParseAddressList(value)
+		if err == nil {
+			var recipients []string
+			seen := make(map[string]bool)
+			for _, address := range addresses {
+				recipient := strings.ToLower(address.Address)
+				if !seen[recipient] {
+					seen[recipient] = true
+					recipients = append(recipients, recipient)
+				}
+			}
+			return recipients
+		}
+		e.logger.Warnf("Invalid %s annotation on namespace %s: %v", annotation, event.Namespace, err)
+	}
+
+	recipients := make([]string, 0, len(e.config.FallbackRecipients))
+	for _, recipient := range e.config.FallbackRecipients {
+		address, _ := mail.ParseAddress(recipient)
+		recipients = append(recipients, strings.ToLower(address.Address))
+	}
+	return recipients
+}
+
+// flush sends every batched mail.
+func (e *EmailNotifier) flush() error {
+	e.mu.Lock()
+	batch := make([]*EmailData, 0, len(e.pending))
+	for _, data := range e.pending {
+		batch = append(batch, data)
+	}
+	e.pending = make(map[string]*EmailData)
+	e.mu.Unlock()
+
+	sort.Slice(batch, func(i, j int) bool { return batch[i].Recipient < batch[j].Recipient })
+	return e.send(batch)
+}
+
+func (e *EmailNotifier) send(batch []*EmailData) error {
+	var errs []string
+	for _, data := range batch {
+		subject, text, html, err := e.templates.Render(data)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		message, err := buildEmailMessage(e.config.From, data.Recipient, subject, text, html, time.Now())
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		recipient := data.Recipient
+		if err := e.queue.Enqueue("mail to "+recipient, func(ctx context.Context) error {
+			return e.sendMail(ctx, recipient, message)
+		}); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send mail: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// sendMail delivers one message over SMTP.
+func (e *EmailNotifier) sendMail(ctx context.Context, recipient string, message []byte) error {
+	port := e.config.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	timeout := e.config.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(e.config.SMTPHost, strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("failed to connect to smtp server: %v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	client, err := smtp.NewClient(conn, e.config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return smtpError("greeting", err)
+	}
+	defer client.Close()
+
+	if e.config.StartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return &PermanentError{Err: fmt.Errorf("smtp server %s does not support STARTTLS", e.config.SMTPHost)}
+		}
+		if err := client.StartTLS(&tls.Config{ServerName: e.config.SMTPHost}); err != nil {
+			return smtpError("STARTTLS", err)
+		}
+	}
+	if e.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.config.Username, e.config.Password, e.config.SMTPHost)); err != nil {
+			return smtpError("AUTH", err)
+		}
+	}
+
+	if err := client.Mail(e.from); err != nil {
+		return smtpError("MAIL FROM", err)
+	}
+	if err := client.Rcpt(recipient); err != nil {
+		return smtpError("RCPT TO", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return smtpError("DATA", err)
+	}
+	if _, err := w.Write(message); err != nil {
+		return smtpError("DATA", err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError("DATA", err)
+	}
+
+	// The message was accepted, retrying would send it twice
+	if err := client.Quit(); err != nil {
+		e.logger.Warnf("Failed to close smtp session with %s after sending email: %v", e.config.SMTPHost, err)
+	}
+	return nil
+}
+
+// smtpError wraps err from an SMTP command. 5xx replies are permanent.
+func smtpError(command string, err error) error {
+	wrapped := fmt.Errorf("smtp %s failed: %v", command, err)
+	if protoErr, ok := err.(*textproto.Error); ok && protoErr.Code >= 500 {
+		return &PermanentError{Err: wrapped}
+	}
+	return wrapped
+}
+
+// buildEmailMessage returns a multipart/alternative message with a plain text
+// and an HTML body.
+func buildEmailMessage(from, to, subject, text, html string, now time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create mail part: %v", err)
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, fmt.Errorf("failed to encode mail part: %v", err)
+		}
+		if err := qp.Close(); err != nil {
+			return nil, fmt.Errorf("failed to encode mail part: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to build mail: %v", err)
+	}
+
+	var message bytes.Buffer
+	headers := [][2]string{
+		{"From", from},
+		{"To", to},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"Message-ID", "<" + newEventID() + "@kube-ns-gc>"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + writer.Boundary()},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&message, "%s: %s\r\n", header[0], header[1])
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+	return message.Bytes(), nil
+}
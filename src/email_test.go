@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type smtpMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+// fakeSMTPServer is a minimal SMTP stand-in without TLS. Recipients listed in
+// reject are refused with a permanent error, and QUIT fails with failQuit.
+type fakeSMTPServer struct {
+	listener net.Listener
+	reject   map[string]bool
+	failQuit bool
+
+	mu          sync.Mutex
+	connections int
+	auth        []string
+	messages    []smtpMessage
+}
+
+func newFakeSMTPServer(t *testing.T, reject ...string) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := &fakeSMTPServer{listener: listener, reject: make(map[string]bool)}
+	for _, address := range reject {
+		server.reject[address] = true
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeSMTPServer) serve(conn net.Conn) {
+	defer conn.Close()
+	s.mu.Lock()
+	s.connections++
+	s.mu.Unlock()
+
+	r := textproto.NewReader(bufio.NewReader(conn))
+	reply := func(lines ...string) {
+		io.WriteString(conn, strings.Join(lines, "\r\n")+"\r\n")
+	}
+
+	reply("220 localhost ESMTP")
+	var message smtpMessage
+	for {
+		line, err := r.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250-localhost", "250 AUTH PLAIN")
+		case strings.HasPrefix(command, "AUTH PLAIN "):
+			decoded, _ := base64.StdEncoding.DecodeString(line[len("AUTH PLAIN "):])
+			s.mu.Lock()
+			s.auth = append(s.auth, string(decoded))
+			s.mu.Unlock()
+			reply("235 2.7.0 Authentication successful")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			message = smtpMessage{From: smtpPath(line)}
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			recipient := smtpPath(line)
+			if s.reject[recipient] {
+				reply("550 5.1.1 No such user")
+				continue
+			}
+			message.To = append(message.To, recipient)
+			reply("250 OK")
+		case command == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			data, err := r.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.Data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+			reply("250 OK")
+		case command == "QUIT":
+			if s.failQuit {
+				reply("421 4.4.2 Connection timed out")
+				return
+			}
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func smtpPath(line string) string {
+	start, end := strings.Index(line, "<"), strings.Index(line, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return line[start+1 : end]
+}
+
+func (s *fakeSMTPServer) received() []smtpMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpMessage(nil), s.messages...)
+}
+
+func (s *fakeSMTPServer) config() *EmailConfig {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	smtpPort, _ := strconv.Atoi(port)
+	return &EmailConfig{
+		SMTPHost:           host,
+		SMTPPort:           smtpPort,
+		From:               "kube-ns-gc <gc@example.com>",
+		FallbackRecipients: []string{"platform@example.com"},
+		NotifyExpiring:     true,
+		NotifyDeleted:      true,
+		RetryConfig:        RetryConfig{MaxRetries: 3, InitialBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond},
+	}
+}
+
+func newTestEmailNotifier(t *testing.T, config *EmailConfig) *EmailNotifier {
+	notifier, err := NewEmailNotifier(config, logrus.New())
+	if err != nil {
+		t.Fatalf("NewEmailNotifier failed: %v", err)
+	}
+	return notifier
+}
+
+// mailBodies decodes the plain text and HTML parts of a message.
+func mailBodies(t *testing.T, data string) (subject, text, html string) {
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Invalid message: %v", err)
+	}
+	subject, _ = new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Invalid content type: %v", err)
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if err != nil {
+			break
+		}
+		body, _ := io.ReadAll(quotedprintable.NewReader(part))
+		if strings.HasPrefix(part.Header.Get("Content-Type"), "text/html") {
+			html = string(body)
+		} else {
+			text = string(body)
+		}
+	}
+	return subject, text, html
+}
+
+func TestEmailBatchesRunPerRecipient(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	config := server.config()
+	config.Username = "gc"
+	config.Password = "secret"
+	notifier := newTestEmailNotifier(t, config)
+
+	owned := map[string]string{DefaultOwnerAnnotation: "Web Team <WEB@example.com>, ops@example.com"}
+	events := []NotificationEvent{
+		{Type: EventNamespaceExpiring, RunID: "run-1", ClusterName: "prod", Namespace: "preview-1", Annotations: owned, ExpiresAt: time.Now().Add(24 * time.Hour)},
+		{Type: EventNamespaceDeleted, RunID: "run-1", ClusterName: "prod", Namespace: "preview-2", Annotations: owned, Releases: []string{"api"}},
+		{Type: EventNamespaceDeleted, RunID: "run-1", ClusterName: "prod", Namespace: "preview-3"},
+		{Type: EventError, RunID: "run-1", Namespace: "preview-4", Annotations: owned},
+	}
+	for _, event := range events {
+		if err := notifier.Notify(event); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+	}
+	if pending := len(notifier.pending); pending != 3 {
+		t.Fatalf("Expected mails for 3 recipients to wait for the summary, got %d", pending)
+	}
+
+	if err := notifier.Notify(NotificationEvent{Type: EventCleanupSummary, RunID: "run-1"}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	closeNotifier(t, notifier)
+
+	messages := server.received()
+	if len(messages) != 3 {
+		t.Fatalf("Expected 3 mails, got %d", len(messages))
+	}
+	byRecipient := make(map[string]smtpMessage)
+	for _, message := range messages {
+		if message.From != "gc@example.com" || len(message.To) != 1 {
+			t.Errorf("Unexpected envelope: %+v", message)
+		}
+		byRecipient[message.To[0]] = message
+	}
+
+	subject, text, html := mailBodies(t, byRecipient["web@example.com"].Data)
+	if subject != "[kube-ns-gc] prod: 1 namespace(s) scheduled for deletion, 1 namespace(s) deleted" {
+		t.Errorf("Unexpected subject %q", subject)
+	}
+	for _, expected := range []string{"preview-1: after", "preview-2: deleted at", "Helm releases: api"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %q in text body:\n%s", expected, text)
+		}
+	}
+	if !strings.Contains(html, "<code>preview-1</code>") || strings.Contains(text, "preview-3") {
+		t.Errorf("Unexpected bodies:\n%s\n%s", text, html)
+	}
+
+	if _, text, _ := mailBodies(t, byRecipient["platform@example.com"].Data); !strings.Contains(text, "preview-3") {
+		t.Errorf("Expected fallback recipient to get preview-3:\n%s", text)
+	}
+
+	if len(server.auth) != 3 || server.auth[0] != "\x00gc\x00secret" {
+		t.Errorf("Unexpected authentication: %q", server.auth)
+	}
+}
+
+func TestEmailSendsOutsideRunImmediately(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	config := server.config()
+	config.NotifyExpiring = false
+	notifier := newTestEmailNotifier(t, config)
+
+	_ = notifier.Notify(NotificationEvent{Type: EventNamespaceExpiring, RunID: "run-1", Namespace: "preview-1"})
+	_ = notifier.Notify(NotificationEvent{Type: EventNamespaceDeleted, Namespace: "preview-2"})
+	closeNotifier(t, notifier)
+
+	if messages := server.received(); len(messages) != 1 || !strings.Contains(messages[0].Data, "preview-2") {
+		t.Errorf("Expected only the approved deletion to be mailed, got %+v", messages)
+	}
+}
+
+func TestEmailDropsRejectedRecipients(t *testing.T) {
+	server := newFakeSMTPServer(t, "platform@example.com")
+	notifier := newTestEmailNotifier(t, server.config())
+
+	_ = notifier.Notify(NotificationEvent{Type: EventNamespaceDeleted, Namespace: "preview-1"})
+	closeNotifier(t, notifier)
+
+	if server.connections != 1 || len(server.received()) != 0 {
+		t.Errorf("Expected no retry after 550, got %d connections", server.connections)
+	}
+}
+
+func TestEmailQuitFailureDoesNotResend(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.failQuit = true
+	notifier := newTestEmailNotifier(t, server.config())
+
+	_ = notifier.Notify(NotificationEvent{Type: EventNamespaceDeleted, Namespace: "preview-1"})
+	closeNotifier(t, notifier)
+
+	if messages := server.received(); len(messages) != 1 {
+		t.Errorf("Expected the accepted message to be sent once, got %d", len(messages))
+	}
+}
+
+func TestEmailRequiresStartTLS(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	config := server.config()
+	config.StartTLS = true
+	notifier := newTestEmailNotifier(t, config)
+
+	err := notifier.sendMail(context.Background(), "platform@example.com", []byte("Subject: test\r\n\r\ntest\r\n"))
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Errorf("Expected STARTTLS error, got %v", err)
+	}
+	closeNotifier(t, notifier)
+}
+
+func TestEmailTemplateValidation(t *testing.T) {
+	if _, err := loadEmailTemplates(&EmailTemplatesConfig{Text: "{{ .Missing }}"}); err == nil {
+		t.Errorf("Expected error for unknown field")
+	}
+	if _, err := NewEmailNotifier(&EmailConfig{SMTPHost: "localhost", From: "not an address"}, logrus.New()); err == nil {
+		t.Errorf("Expected error for invalid from address")
+	}
+}
+
+func TestExpiryWarningDue(t *testing.T) {
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:              "preview-1",
+		CreationTimestamp: metav1.NewTime(now.Add(-6 * 24 * time.Hour)),
+	}}
+	expiresAt := namespaceExpiresAt(ns, 7*24*time.Hour)
+
+	if !expiryWarningDue(ns, expiresAt, 48*time.Hour, now) {
+		t.Errorf("Expected warning one day before deletion")
+	}
+	if expiryWarningDue(ns, expiresAt, 12*time.Hour, now) {
+		t.Errorf("Expected no warning outside the warning window")
+	}
+	if expiryWarningDue(ns, expiresAt, 0, now) {
+		t.Errorf("Expected no warning when disabled")
+	}
+
+	ns.Annotations = map[string]string{ExpiryWarningSentAnnotation: now.Add(-time.Hour).Format(time.RFC3339)}
+	if expiryWarningDue(ns, expiresAt, 48*time.Hour, now) {
+		t.Errorf("Expected a single warning per deletion date")
+	}
+
+	// Postponing the deletion moves the warning window
+	ns.Annotations[PostponedUntilAnnotation] = now.Add(5 * 24 * time.Hour).Format(time.RFC3339)
+	expiresAt = namespaceExpiresAt(ns, 7*24*time.Hour)
+	if expiryWarningDue(ns, expiresAt, 48*time.Hour, now) {
+		t.Errorf("Expected no warning right after postponement")
+	}
+	if !expiryWarningDue(ns, expiresAt, 48*time.Hour, now.Add(4*24*time.Hour)) {
+		t.Errorf("Expected a new warning before the postponed deletion")
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1ac "k8s.io/client-go/applyconfigurations/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ExpiryWarningSentAnnotation holds the RFC3339 time the last pre-deletion
+// warning about a namespace was sent.
+const ExpiryWarningSentAnnotation = "kube-ns-gc/expiry-warning-sent"
+
+// Annotations that show developers when and why a namespace will be deleted.
+// They are owned by FieldManager through server-side apply.
+const (
+	ExpiresAtAnnotation = "kube-ns-gc/expires-at"
+	PolicyAnnotation    = "kube-ns-gc/policy"
+	ReasonAnnotation    = "kube-ns-gc/reason"
+
+	FieldManager = "kube-ns-gc"
+)
+
+var expiryAnnotationKeys = []string{ExpiresAtAnnotation, PolicyAnnotation, ReasonAnnotation}
+
+// namespaceExpiresAt returns when ns becomes eligible for deletion under maxAge,
+// taking a postponement into account.
+func namespaceExpiresAt(ns *v1.Namespace, maxAge time.Duration) time.Time {
+	expiresAt := ns.CreationTimestamp.Time.Add(maxAge)
+	if until, ok := postponedUntil(ns); ok && until.After(expiresAt) {
+		expiresAt = until
+	}
+	return expiresAt
+}
+
+// expiryWarningDue reports whether a warning about the deletion at expiresAt
+// should be sent now. A namespace is warned once per deletion date, so a
+// postponement leads to a new warning.
+func expiryWarningDue(ns *v1.Namespace, expiresAt time.Time, warningBefore time.Duration, now time.Time) bool {
+	if warningBefore <= 0 || !now.Before(expiresAt) || now.Before(expiresAt.Add(-warningBefore)) {
+		return false
+	}
+	if value, ok := ns.Annotations[ExpiryWarningSentAnnotation]; ok {
+		if sent, err := time.Parse(time.RFC3339, value); err == nil && !sent.Before(expiresAt.Add(-warningBefore)) {
+			return false
+		}
+	}
+	return true
+}
+
+// warnBeforeDeletion notifies about ns if its deletion is within WarningBefore
+// and records the warning on the namespace so it is sent only once.
+func (gc *NamespaceGC) warnBeforeDeletion(ns *v1.Namespace, runID string) {
+	now := time.Now()
+	expiresAt := namespaceExpiresAt(ns, gc.config.NamespaceMaxAge)
+	if !expiryWarningDue(ns, expiresAt, gc.config.WarningBefore, now) {
+		return
+	}
+
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				ExpiryWarningSentAnnotation: now.UTC().Format(time.RFC3339),
+			},
+		},
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		gc.logger.Errorf("Failed to marshal expiry warning patch: %v", err)
+		return
+	}
+
+	// Record the warning first: a missed warning is better than one per run
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := gc.clientset.CoreV1().Namespaces().Patch(ctx, ns.Name, types.MergePatchType, data, metav1.PatchOptions{}); err != nil {
+		gc.logger.Errorf("Failed to record expiry warning for namespace %s: %v", ns.Name, err)
+		return
+	}
+
+	gc.logger.Infof("Namespace %s will be deleted after %s", ns.Name, expiresAt.Format(time.RFC3339))
+	gc.events.Namespace(ns, v1.EventTypeWarning, ReasonDeletionScheduled, "Namespace will be deleted after %s under policy %s (%s)", expiresAt.UTC().Format(time.RFC3339), PolicyNamespaceMaxAge, gc.policyRule())
+	event := gc.namespaceEvent(EventNamespaceExpiring, ns, runID)
+	event.ExpiresAt = expiresAt
+	gc.notify(event)
+}
+
+// expiryAnnotations returns the expiry annotations ns should carry under the
+// max age policy.
+func expiryAnnotations(ns *v1.Namespace, maxAge time.Duration) map[string]string {
+	reason := fmt.Sprintf("older than %s since %s", shortDuration(maxAge), ns.CreationTimestamp.Time.UTC().Format(time.RFC3339))
+	expiresAt := namespaceExpiresAt(ns, maxAge)
+	if until, ok := postponedUntil(ns); ok && !until.Before(expiresAt) {
+		reason = "deletion postponed until " + until.UTC().Format(time.RFC3339)
+	}
+	return map[string]string{
+		ExpiresAtAnnotation: expiresAt.UTC().Format(time.RFC3339),
+		PolicyAnnotation:    PolicyNamespaceMaxAge,
+		ReasonAnnotation:    reason,
+	}
+}
+
+// expiryAnnotationsCurrent reports whether ns already carries exactly the
+// desired expiry annotations. nil desires none of them.
+func expiryAnnotationsCurrent(ns *v1.Namespace, desired map[string]string) bool {
+	for _, key := range expiryAnnotationKeys {
+		value, ok := ns.Annotations[key]
+		if want, wanted := desired[key]; ok != wanted || value != want {
+			return false
+		}
+	}
+	return true
+}
+
+// applyExpiryAnnotations sets the expiry annotations of ns to desired with
+// server-side apply. Applying without annotations removes the ones kube-ns-gc
+// applied before, other fields of the namespace are left alone.
+func applyExpiryAnnotations(ctx context.Context, clientset kubernetes.Interface, ns *v1.Namespace, desired map[string]string) error {
+	if expiryAnnotationsCurrent(ns, desired) {
+		return nil
+	}
+	config := corev1ac.Namespace(ns.Name)
+	if len(desired) > 0 {
+		config.WithAnnotations(desired)
+	}
+	_, err := clientset.CoreV1().Namespaces().Apply(ctx, config, metav1.ApplyOptions{FieldManager: FieldManager, Force: true})
+	if err != nil {
+		return fmt.Errorf("failed to apply expiry annotations: %v", err)
+	}
+	return nil
+}
+
+// updateExpiryAnnotations keeps the expiry annotations of ns in line with the
+// max age policy, or removes them if ns is no longer collected.
+func (gc *NamespaceGC) updateExpiryAnnotations(ns *v1.Namespace, collected bool) {
+	if !gc.config.ExpiryAnnotations {
+		return
+	}
+	var desired map[string]string
+	if collected {
+		desired = expiryAnnotations(ns, gc.config.NamespaceMaxAge)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := applyExpiryAnnotations(ctx, gc.clientset, ns, desired); err != nil {
+		gc.logger.Warnf("Failed to update expiry annotations of namespace %s: %v", ns.Name, err)
+	}
+}
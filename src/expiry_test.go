@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestExpiryAnnotations(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1", CreationTimestamp: metav1.NewTime(created)}}
+
+	annotations := expiryAnnotations(ns, 7*24*time.Hour)
+	if annotations[ExpiresAtAnnotation] != "2024-01-08T00:00:00Z" || annotations[PolicyAnnotation] != PolicyNamespaceMaxAge {
+		t.Errorf("Unexpected annotations: %v", annotations)
+	}
+	if annotations[ReasonAnnotation] != "older than 7d since 2024-01-01T00:00:00Z" {
+		t.Errorf("Unexpected reason %q", annotations[ReasonAnnotation])
+	}
+
+	ns.Annotations = map[string]string{PostponedUntilAnnotation: "2024-01-10T00:00:00Z"}
+	annotations = expiryAnnotations(ns, 7*24*time.Hour)
+	if annotations[ExpiresAtAnnotation] != "2024-01-10T00:00:00Z" || annotations[ReasonAnnotation] != "deletion postponed until 2024-01-10T00:00:00Z" {
+		t.Errorf("Expected the postponement to extend the expiry, got %v", annotations)
+	}
+}
+
+func TestExpiryAnnotationsCurrent(t *testing.T) {
+	desired := map[string]string{ExpiresAtAnnotation: "2024-01-08T00:00:00Z", PolicyAnnotation: PolicyNamespaceMaxAge, ReasonAnnotation: "older than 7d"}
+
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"team": "web"}}}
+	if !expiryAnnotationsCurrent(ns, nil) {
+		t.Errorf("Expected a namespace without expiry annotations to need no removal")
+	}
+	if expiryAnnotationsCurrent(ns, desired) {
+		t.Errorf("Expected missing annotations to be applied")
+	}
+
+	for key, value := range desired {
+		ns.Annotations[key] = value
+	}
+	if !expiryAnnotationsCurrent(ns, desired) {
+		t.Errorf("Expected matching annotations to be current")
+	}
+	if expiryAnnotationsCurrent(ns, nil) {
+		t.Errorf("Expected annotations of an excluded namespace to be removed")
+	}
+
+	ns.Annotations[ExpiresAtAnnotation] = "2024-01-09T00:00:00Z"
+	if expiryAnnotationsCurrent(ns, desired) {
+		t.Errorf("Expected a changed expiry to be applied")
+	}
+}
+
+func TestApplyExpiryAnnotations(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	var patches []map[string]interface{}
+	clientset.PrependReactor("patch", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			t.Errorf("Expected server-side apply, got %s", patch.GetPatchType())
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(patch.GetPatch(), &body); err != nil {
+			t.Fatalf("Invalid apply body: %v", err)
+		}
+		patches = append(patches, body)
+		return true, &v1.Namespace{}, nil
+	})
+
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1", Annotations: map[string]string{ExpiresAtAnnotation: "2024-01-08T00:00:00Z"}}}
+	desired := map[string]string{ExpiresAtAnnotation: "2024-01-10T00:00:00Z", PolicyAnnotation: PolicyNamespaceMaxAge}
+	if err := applyExpiryAnnotations(context.Background(), clientset, ns, desired); err != nil {
+		t.Fatalf("applyExpiryAnnotations failed: %v", err)
+	}
+	if err := applyExpiryAnnotations(context.Background(), clientset, ns, nil); err != nil {
+		t.Fatalf("applyExpiryAnnotations failed: %v", err)
+	}
+	ns.Annotations = nil
+	if err := applyExpiryAnnotations(context.Background(), clientset, ns, nil); err != nil {
+		t.Fatalf("applyExpiryAnnotations failed: %v", err)
+	}
+
+	if len(patches) != 2 {
+		t.Fatalf("Expected 2 applies, got %d", len(patches))
+	}
+	metadata := patches[0]["metadata"].(map[string]interface{})
+	if metadata["name"] != "preview-1" || metadata["annotations"].(map[string]interface{})[ExpiresAtAnnotation] != "2024-01-10T00:00:00Z" {
+		t.Errorf("Unexpected apply body: %v", patches[0])
+	}
+	if _, ok := patches[1]["metadata"].(map[string]interface{})["annotations"]; ok {
+		t.Errorf("Expected removal to apply no annotations, got %v", patches[1])
+	}
+}
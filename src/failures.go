@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const failureStateKey = "failures.json"
+
+// FailureEscalationConfig escalates namespaces and Helm releases that fail
+// in several cleanup runs in a row.
+type FailureEscalationConfig struct {
+	Enabled bool `json:"enabled"`
+	// After is the number of consecutive failed runs before escalating
+	After int `json:"after"`
+	// PurgeStorage deletes the Helm storage of escalated releases
+	PurgeStorage bool `json:"purge_storage"`
+	// DeleteNamespace deletes a namespace anyway once its releases escalated
+	DeleteNamespace bool   `json:"delete_namespace"`
+	StateConfigMap  string `json:"state_configmap"`
+	StateNamespace  string `json:"state_namespace"`
+}
+
+// FailureRecord counts the consecutive failed runs of a namespace, or of a
+// release when Release is set.
+type FailureRecord struct {
+	Namespace     string     `json:"namespace"`
+	Release       string     `json:"release,omitempty"`
+	Count         int        `json:"count"`
+	FirstFailedAt time.Time  `json:"first_failed_at"`
+	LastFailedAt  time.Time  `json:"last_failed_at"`
+	LastError     string     `json:"last_error"`
+	EscalatedAt   *time.Time `json:"escalated_at,omitempty"`
+	Purged        bool       `json:"purged,omitempty"`
+}
+
+func (r *FailureRecord) key() string {
+	return failureKey(r.Namespace, r.Release)
+}
+
+func failureKey(namespace, release string) string {
+	if release == "" {
+		return namespace
+	}
+	return namespace + "/" + release
+}
+
+// FailureTracker keeps failure counts across runs and restarts in a
+// ConfigMap. A record is dropped when a run no longer fails it. A nil
+// *FailureTracker tracks nothing.
+type FailureTracker struct {
+	config    *FailureEscalationConfig
+	clientset kubernetes.Interface
+	logger    *logrus.Logger
+
+	mu      sync.Mutex
+	loaded  bool
+	records map[string]*FailureRecord
+	failed  map[string]bool
+}
+
+func NewFailureTracker(config *FailureEscalationConfig, clientset kubernetes.Interface, logger *logrus.Logger) (*FailureTracker, error) {
+	if config.After < 1 {
+		return nil, fmt.Errorf("failure escalation needs after >= 1, got %d", config.After)
+	}
+	if config.StateConfigMap == "" {
+		return nil, fmt.Errorf("failure escalation needs a state configmap")
+	}
+	if config.StateNamespace == "" {
+		config.StateNamespace = getEnvString("POD_NAMESPACE", "default")
+	}
+
+	return &FailureTracker{
+		config:    config,
+		clientset: clientset,
+		logger:    logger,
+		records:   map[string]*FailureRecord{},
+		failed:    map[string]bool{},
+	}, nil
+}
+
+// Begin starts a run, loading the state on first use.
+func (t *FailureTracker) Begin(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.failed = map[string]bool{}
+	if t.loaded {
+		return nil
+	}
+	if err := t.load(ctx); err != nil {
+		return err
+	}
+	t.loaded = true
+	return nil
+}
+
+// Fail records a failure of namespace, or of its release, in the current run.
+// It returns the updated record and whether it is due for escalation. A record
+// is due once, escalated records stay escalated until they are dropped.
+func (t *FailureTracker) Fail(namespace, release string, err error) (FailureRecord, bool) {
+	if t == nil {
+		return FailureRecord{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := failureKey(namespace, release)
+	now := time.Now()
+	record, ok := t.records[key]
+	if !ok {
+		record = &FailureRecord{Namespace: namespace, Release: release, FirstFailedAt: now}
+		t.records[key] = record
+	}
+	// Several failures within a run count once
+	if !t.failed[key] {
+		record.Count++
+		t.failed[key] = true
+	}
+	record.LastFailedAt = now
+	record.LastError = err.Error()
+	return *record, record.Count >= t.config.After && record.EscalatedAt == nil
+}
+
+// Escalated marks a record as escalated, and its release storage as purged.
+func (t *FailureTracker) Escalated(namespace, release string, purged bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	record, ok := t.records[failureKey(namespace, release)]
+	if !ok {
+		return
+	}
+	if record.EscalatedAt == nil {
+		now := time.Now()
+		record.EscalatedAt = &now
+	}
+	record.Purged = record.Purged || purged
+}
+
+// Succeed clears the failures of a namespace, or of its release.
+func (t *FailureTracker) Succeed(namespace, release string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.records, failureKey(namespace, release))
+}
+
+// End drops the records that did not fail in the run and saves the state.
+func (t *FailureTracker) End(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key := range t.records {
+		if !t.failed[key] {
+			delete(t.records, key)
+		}
+	}
+	return t.save(ctx)
+}
+
+// Records returns the tracked failures ordered by namespace and release.
+func (t *FailureTracker) Records() []FailureRecord {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	records := make([]FailureRecord, 0, len(t.records))
+	for _, record := range t.records {
+		records = append(records, *record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].key() < records[j].key()
+	})
+	return records
+}
+
+// load reads the state from the ConfigMap. The caller must hold t.mu.
+func (t *FailureTracker) load(ctx context.Context) error {
+	cm, err := t.clientset.CoreV1().ConfigMaps(t.config.StateNamespace).Get(ctx, t.config.StateConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get failure state: %v", err)
+	}
+
+	var records []*FailureRecord
+	if data := cm.Data[failureStateKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &records); err != nil {
+			return fmt.Errorf("failed to parse failure state: %v", err)
+		}
+	}
+	for _, record := range records {
+		t.records[record.key()] = record
+	}
+
+	t.logger.Infof("Loaded %d tracked failures", len(records))
+	return nil
+}
+
+// save writes the state to the ConfigMap. The caller must hold t.mu.
+func (t *FailureTracker) save(ctx context.Context) error {
+	records := make([]*FailureRecord, 0, len(t.records))
+	for _, record := range t.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].key() < records[j].key()
+	})
+	data, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("failed to marshal failure state: %v", err)
+	}
+
+	configMaps := t.clientset.CoreV1().ConfigMaps(t.config.StateNamespace)
+	cm, err := configMaps.Get(ctx, t.config.StateConfigMap, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      t.config.StateConfigMap,
+				Namespace: t.config.StateNamespace,
+			},
+			Data: map[string]string{failureStateKey: string(data)},
+		}
+		if _, err := configMaps.Create(ctx, cm, metav1.CreateOptions{}); err != nil {
+			return fmt.Errorf("failed to create failure state: %v", err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get failure state: %v", err)
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[failureStateKey] = string(data)
+	if _, err := configMaps.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to update failure state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestFailureTracker(t *testing.T, clientset *fake.Clientset) *FailureTracker {
+	tracker, err := NewFailureTracker(&FailureEscalationConfig{
+		After:          2,
+		StateConfigMap: "kube-ns-gc-failures",
+		StateNamespace: "kube-ns-gc",
+	}, clientset, logrus.New())
+	if err != nil {
+		t.Fatalf("NewFailureTracker failed: %v", err)
+	}
+	return tracker
+}
+
+func TestFailureTrackerEscalatesConsecutiveRuns(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	tracker := newTestFailureTracker(t, clientset)
+	boom := fmt.Errorf("pre-delete hook failed")
+
+	if err := tracker.Begin(ctx); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	tracker.Fail("preview-1", "api", boom)
+	if record, due := tracker.Fail("preview-1", "api", boom); due || record.Count != 1 {
+		t.Errorf("Expected failures within a run to count once, got %+v, due=%v", record, due)
+	}
+	tracker.Fail("preview-2", "", boom)
+	if err := tracker.End(ctx); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+
+	// A restarted tracker continues from the saved state
+	tracker = newTestFailureTracker(t, clientset)
+	if err := tracker.Begin(ctx); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	record, due := tracker.Fail("preview-1", "api", boom)
+	if !due || record.Count != 2 || record.LastError != boom.Error() {
+		t.Errorf("Expected api to be due for escalation, got %+v, due=%v", record, due)
+	}
+	tracker.Escalated("preview-1", "api", true)
+	if err := tracker.End(ctx); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+
+	records := tracker.Records()
+	if len(records) != 1 || records[0].Release != "api" || records[0].EscalatedAt == nil || !records[0].Purged {
+		t.Errorf("Expected only the escalated api release to be tracked, got %+v", records)
+	}
+
+	// An escalated release is not escalated again while it keeps failing
+	if err := tracker.Begin(ctx); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	if record, due := tracker.Fail("preview-1", "api", boom); due || record.Count != 3 || record.EscalatedAt == nil {
+		t.Errorf("Expected api to stay escalated without escalating again, got %+v, due=%v", record, due)
+	}
+}
+
+func TestFailureTrackerSucceedClearsRecord(t *testing.T) {
+	ctx := context.Background()
+	tracker := newTestFailureTracker(t, fake.NewSimpleClientset())
+	if err := tracker.Begin(ctx); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+
+	tracker.Fail("preview-1", "", fmt.Errorf("timeout waiting for namespace deletion"))
+	tracker.Succeed("preview-1", "")
+	if records := tracker.Records(); len(records) != 0 {
+		t.Errorf("Expected no failures after success, got %+v", records)
+	}
+}
+
+func TestNilFailureTracker(t *testing.T) {
+	var tracker *FailureTracker
+	if err := tracker.Begin(context.Background()); err != nil {
+		t.Errorf("Begin failed: %v", err)
+	}
+	if _, due := tracker.Fail("preview-1", "api", fmt.Errorf("boom")); due {
+		t.Error("Expected a nil tracker to never escalate")
+	}
+	if records := tracker.Records(); records != nil {
+		t.Errorf("Expected no records, got %+v", records)
+	}
+}
+
+func TestEscalationIsNotInRun(t *testing.T) {
+	event := NotificationEvent{Type: EventError, RunID: "run-1", Namespace: "preview-1", Failure: FailureNamespaceCleanup}
+	if !event.InRun() {
+		t.Error("Expected namespace failures to be reported in the run")
+	}
+	event.Failure = FailureEscalated
+	if event.InRun() {
+		t.Error("Expected escalations to be sent on their own")
+	}
+}
+
+// newEscalationTestGC returns a NamespaceGC over a fake cluster with the
+// namespace preview-1, whose Helm release api is stored in memory and fails
+// to uninstall.
+func newEscalationTestGC(t *testing.T, escalation FailureEscalationConfig) (*NamespaceGC, *storage.Storage, *recordingNotifier) {
+	clientset := fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1", UID: "uid-1"}})
+	config := &Config{NamespaceMaxAge: 24 * time.Hour, HelmReleaseTimeout: time.Minute, FailureEscalation: escalation}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	failures, err := NewFailureTracker(&config.FailureEscalation, clientset, logger)
+	if err != nil {
+		t.Fatalf("NewFailureTracker failed: %v", err)
+	}
+
+	releases := storage.Init(driver.NewMemory())
+	err = releases.Create(&release.Release{
+		Name:      "api",
+		Namespace: "preview-1",
+		Version:   1,
+		Info:      &release.Info{Status: release.StatusDeployed},
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "api", Version: "1.0.0"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to store release: %v", err)
+	}
+	helmClient := &HelmClient{config: &config.Helm, logger: logger, fixed: &action.Configuration{
+		Releases:     releases,
+		Capabilities: chartutil.DefaultCapabilities,
+		KubeClient: &kubefake.FailingKubeClient{
+			PrintingKubeClient: kubefake.PrintingKubeClient{Out: io.Discard},
+			BuildError:         fmt.Errorf("connection refused"),
+		},
+		Log: func(string, ...interface{}) {},
+	}}
+
+	notifier := &recordingNotifier{}
+	gc := &NamespaceGC{
+		config:     config,
+		clientset:  clientset,
+		logger:     logger,
+		helmClient: helmClient,
+		notifier:   notifier,
+		failures:   failures,
+	}
+	return gc, releases, notifier
+}
+
+// runCleanup cleans up preview-1 in a run of its own.
+func runCleanup(t *testing.T, gc *NamespaceGC, runID string) (*DeletedNamespace, error) {
+	ctx := context.Background()
+	if err := gc.failures.Begin(ctx); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+	ns, err := gc.clientset.CoreV1().Namespaces().Get(ctx, "preview-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get namespace: %v", err)
+	}
+	releases, err := gc.helmClient.ListReleases("preview-1")
+	if err != nil {
+		t.Fatalf("ListReleases failed: %v", err)
+	}
+	apps, err := gc.argoCD.Load(ctx)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	deleted, cleanupErr := gc.cleanupNamespace(ns, releases, nil, apps, runID, AuditActor{Type: AuditActorScheduler})
+	if err := gc.failures.End(ctx); err != nil {
+		t.Fatalf("End failed: %v", err)
+	}
+	return deleted, cleanupErr
+}
+
+func escalations(events []NotificationEvent) []NotificationEvent {
+	var escalated []NotificationEvent
+	for _, event := range events {
+		if event.Failure == FailureEscalated {
+			escalated = append(escalated, event)
+		}
+	}
+	return escalated
+}
+
+func TestCleanupNamespaceEscalatesPurgesAndDeletes(t *testing.T) {
+	defer func(interval time.Duration) { namespaceDeletionPollInterval = interval }(namespaceDeletionPollInterval)
+	namespaceDeletionPollInterval = time.Millisecond
+
+	gc, releases, notifier := newEscalationTestGC(t, FailureEscalationConfig{
+		Enabled:         true,
+		After:           2,
+		PurgeStorage:    true,
+		DeleteNamespace: true,
+		StateConfigMap:  "kube-ns-gc-failures",
+		StateNamespace:  "kube-ns-gc",
+	})
+
+	// The namespace is held while its release is not escalated yet
+	if _, err := runCleanup(t, gc, "run-1"); err == nil {
+		t.Fatal("Expected the namespace to be held after the first failure")
+	}
+	if len(escalations(notifier.events)) != 0 {
+		t.Errorf("Expected no escalation after one failure, got %+v", notifier.events)
+	}
+	if _, err := gc.clientset.CoreV1().Namespaces().Get(context.Background(), "preview-1", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected the namespace to be kept: %v", err)
+	}
+
+	// The second failure escalates, purges the release and deletes the namespace anyway
+	deleted, err := runCleanup(t, gc, "run-2")
+	if err != nil {
+		t.Fatalf("Expected the namespace to be deleted anyway, got %v", err)
+	}
+	if deleted == nil || deleted.Name != "preview-1" || len(deleted.Releases) != 0 {
+		t.Errorf("Unexpected deleted namespace: %+v", deleted)
+	}
+	escalated := escalations(notifier.events)
+	if len(escalated) != 1 || escalated[0].Release != "api" || escalated[0].InRun() {
+		t.Errorf("Expected one escalation of api sent on its own, got %+v", escalated)
+	}
+	if history, _ := releases.History("api"); len(history) != 0 {
+		t.Errorf("Expected the storage of api to be purged, got %d revisions", len(history))
+	}
+	if _, err := gc.clientset.CoreV1().Namespaces().Get(context.Background(), "preview-1", metav1.GetOptions{}); err == nil {
+		t.Error("Expected the namespace to be deleted")
+	}
+}
+
+func TestCleanupNamespaceHeldWithoutDeleteNamespace(t *testing.T) {
+	gc, releases, notifier := newEscalationTestGC(t, FailureEscalationConfig{
+		Enabled:        true,
+		After:          1,
+		StateConfigMap: "kube-ns-gc-failures",
+		StateNamespace: "kube-ns-gc",
+	})
+
+	for _, runID := range []string{"run-1", "run-2"} {
+		if _, err := runCleanup(t, gc, runID); err == nil {
+			t.Fatalf("Expected the namespace to be held in %s", runID)
+		}
+	}
+	if escalated := escalations(notifier.events); len(escalated) != 1 {
+		t.Errorf("Expected the release to be escalated once, got %d escalations", len(escalated))
+	}
+	if history, _ := releases.History("api"); len(history) != 1 {
+		t.Errorf("Expected the storage of api to be kept without purge_storage, got %d revisions", len(history))
+	}
+}
+
+func TestReleaseGCEscalationDoesNotPurge(t *testing.T) {
+	gc, releases, notifier := newEscalationTestGC(t, FailureEscalationConfig{
+		Enabled:        true,
+		After:          1,
+		PurgeStorage:   true,
+		StateConfigMap: "kube-ns-gc-failures",
+		StateNamespace: "kube-ns-gc",
+	})
+	if err := gc.failures.Begin(context.Background()); err != nil {
+		t.Fatalf("Begin failed: %v", err)
+	}
+
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1"}}
+	gc.collectRelease(ns, HelmRelease{Name: "api", Namespace: "preview-1"}, "failed > 2h", "run-1")
+	if escalated := escalations(notifier.events); len(escalated) != 1 || escalated[0].Policy != PolicyReleaseGC {
+		t.Errorf("Expected one release_gc escalation, got %+v", escalated)
+	}
+	if history, _ := releases.History("api"); len(history) != 1 {
+		t.Errorf("Expected the storage of a release_gc release to be kept, got %d revisions", len(history))
+	}
+
+	// Kept resources that block an uninstall are not a failure
+	gc.config.Helm.Uninstall.KeptResources = KeptResourcesBlock
+	gc.collectRelease(ns, HelmRelease{Name: "db", Namespace: "preview-1", KeptResources: []KeptResource{{Kind: "PersistentVolumeClaim", Name: "data"}}}, "failed > 2h", "run-1")
+	for _, record := range gc.failures.Records() {
+		if record.Release == "db" {
+			t.Errorf("Expected a blocked release not to be tracked as failing, got %+v", record)
+		}
+	}
+}
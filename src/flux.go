@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// PolicyFlux is the policy of namespaces reconciled by Flux.
+const PolicyFlux = "flux"
+
+// Actions on namespaces reconciled by Flux.
+const (
+	FluxActionSkip   = "skip"
+	FluxActionDelete = "delete"
+)
+
+// Flux kinds and the API groups they are served in.
+const (
+	FluxKindKustomization = "Kustomization"
+	FluxKindHelmRelease   = "HelmRelease"
+	fluxKustomizeGroup    = "kustomize.toolkit.fluxcd.io"
+	fluxHelmGroup         = "helm.toolkit.fluxcd.io"
+)
+
+// fluxKinds lists Kustomizations first, they may recreate HelmReleases.
+var fluxKinds = []struct {
+	kind     string
+	group    string
+	resource string
+}{
+	{FluxKindKustomization, fluxKustomizeGroup, "kustomizations"},
+	{FluxKindHelmRelease, fluxHelmGroup, "helmreleases"},
+}
+
+// FluxConfig configures namespaces reconciled by Flux Kustomizations and
+// HelmReleases. They are skipped, or their Flux objects are suspended and
+// deleted before the namespace. Helm releases of HelmReleases are never
+// uninstalled directly.
+type FluxConfig struct {
+	Enabled bool `json:"enabled"`
+	// Action is skip (the default) or delete
+	Action string `json:"action"`
+}
+
+// FluxObject is a Flux Kustomization or HelmRelease.
+type FluxObject struct {
+	Kind            string
+	Namespace       string
+	Name            string
+	TargetNamespace string
+	// ReleaseName is the Helm release of a HelmRelease
+	ReleaseName string
+
+	resource schema.GroupVersionResource
+}
+
+func (o FluxObject) String() string {
+	return o.Kind + " " + o.Namespace + "/" + o.Name
+}
+
+// targets reports whether o reconciles into namespace.
+func (o FluxObject) targets(namespace string) bool {
+	return o.Namespace == namespace || o.TargetNamespace == namespace
+}
+
+// newFluxObject converts a Kustomization or HelmRelease.
+func newFluxObject(kind string, resource schema.GroupVersionResource, item *unstructured.Unstructured) FluxObject {
+	object := FluxObject{
+		Kind:      kind,
+		Namespace: item.GetNamespace(),
+		Name:      item.GetName(),
+		resource:  resource,
+	}
+	object.TargetNamespace, _, _ = unstructured.NestedString(item.Object, "spec", "targetNamespace")
+
+	if kind == FluxKindHelmRelease {
+		// The release name helm-controller uses when spec.releaseName is unset
+		object.ReleaseName, _, _ = unstructured.NestedString(item.Object, "spec", "releaseName")
+		if object.ReleaseName == "" {
+			object.ReleaseName = object.Name
+			if object.TargetNamespace != "" {
+				object.ReleaseName = object.TargetNamespace + "-" + object.Name
+			}
+		}
+	}
+	return object
+}
+
+// FluxObjects are the Flux objects of the cluster at the start of a run.
+// A nil *FluxObjects owns nothing.
+type FluxObjects struct {
+	objects []FluxObject
+	// err is why the objects could not be listed
+	err error
+}
+
+// unlistedFluxObjects stands in for the objects of a run that failed to list
+// them. Namespaces Flux may reconcile are skipped instead of collected.
+func unlistedFluxObjects(err error) *FluxObjects {
+	return &FluxObjects{err: err}
+}
+
+// unlisted returns an error when the objects could not be listed and Flux may
+// reconcile ns: it carries the labels Flux sets, or has Helm releases a
+// HelmRelease could manage.
+func (f *FluxObjects) unlisted(ns *v1.Namespace, releases []HelmRelease) error {
+	if f == nil || f.err == nil {
+		return nil
+	}
+	if len(releases) > 0 {
+		return &unknownOwnerError{err: fmt.Errorf("Flux objects could not be listed, Helm releases may be managed by Flux: %v", f.err)}
+	}
+	for _, kind := range fluxKinds {
+		if ns.Labels[kind.group+"/name"] != "" {
+			return &unknownOwnerError{err: fmt.Errorf("Flux objects could not be listed, namespace is applied by Flux: %v", f.err)}
+		}
+	}
+	return nil
+}
+
+// Owners returns the objects that reconcile into ns. It fails when ns is
+// applied by an object that also reconciles other namespaces, deleting that
+// object would delete them too.
+func (f *FluxObjects) Owners(ns *v1.Namespace) ([]FluxObject, error) {
+	if f == nil {
+		return nil, nil
+	}
+
+	var owners []FluxObject
+	for _, object := range f.objects {
+		if object.targets(ns.Name) {
+			owners = append(owners, object)
+		}
+	}
+
+	// kustomize-controller and helm-controller label the objects they apply
+	for _, kind := range fluxKinds {
+		name := ns.Labels[kind.group+"/name"]
+		namespace := ns.Labels[kind.group+"/namespace"]
+		if name == "" {
+			continue
+		}
+		for _, object := range f.objects {
+			if object.Kind == kind.kind && object.Namespace == namespace && object.Name == name && !object.targets(ns.Name) {
+				return nil, fmt.Errorf("namespace is applied by Flux %s", object)
+			}
+		}
+	}
+	return owners, nil
+}
+
+// ReleaseOwner returns the HelmRelease of a Helm release, or nil.
+func (f *FluxObjects) ReleaseOwner(release HelmRelease) *FluxObject {
+	if f == nil {
+		return nil
+	}
+	for i := range f.objects {
+		object := &f.objects[i]
+		if object.Kind != FluxKindHelmRelease || object.ReleaseName != release.Name {
+			continue
+		}
+		target := object.TargetNamespace
+		if target == "" {
+			target = object.Namespace
+		}
+		if target == release.Namespace {
+			return object
+		}
+	}
+	return nil
+}
+
+// FluxClient discovers, suspends and deletes Flux objects.
+type FluxClient struct {
+	config    *FluxConfig
+	client    dynamic.Interface
+	discovery discovery.DiscoveryInterface
+	logger    *logrus.Logger
+}
+
+func NewFluxClient(restConfig *rest.Config, discoveryClient discovery.DiscoveryInterface, config *FluxConfig, logger *logrus.Logger) (*FluxClient, error) {
+	switch config.Action {
+	case "":
+		config.Action = FluxActionSkip
+	case FluxActionSkip, FluxActionDelete:
+	default:
+		return nil, fmt.Errorf("unsupported Flux action %q", config.Action)
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %v", err)
+	}
+	return &FluxClient{config: config, client: client, discovery: discoveryClient, logger: logger}, nil
+}
+
+// Load lists the Kustomizations and HelmReleases in the preferred versions
+// the cluster serves. Kinds whose group is not served are left out. A nil
+// *FluxClient loads nothing.
+func (c *FluxClient) Load(ctx context.Context) (*FluxObjects, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	groups, err := c.discovery.ServerGroups()
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover Flux API groups: %v", err)
+	}
+	versions := map[string]string{}
+	for _, group := range groups.Groups {
+		versions[group.Name] = group.PreferredVersion.Version
+	}
+
+	objects := &FluxObjects{}
+	for _, kind := range fluxKinds {
+		version, ok := versions[kind.group]
+		if !ok {
+			continue
+		}
+		resource := schema.GroupVersionResource{Group: kind.group, Version: version, Resource: kind.resource}
+		list, err := c.client.Resource(resource).Namespace(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list Flux %ss: %v", kind.kind, err)
+		}
+		for i := range list.Items {
+			objects.objects = append(objects.objects, newFluxObject(kind.kind, resource, &list.Items[i]))
+		}
+	}
+	return objects, nil
+}
+
+// Delete suspends object so that it is not reconciled again, then deletes it.
+// helm-controller does not uninstall the release of a suspended HelmRelease,
+// it goes with the namespace.
+func (c *FluxClient) Delete(ctx context.Context, object FluxObject) error {
+	resources := c.client.Resource(object.resource).Namespace(object.Namespace)
+
+	patch := []byte(`{"spec":{"suspend":true}}`)
+	if _, err := resources.Patch(ctx, object.Name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+		return fmt.Errorf("failed to suspend Flux %s: %v", object, err)
+	}
+	if err := resources.Delete(ctx, object.Name, metav1.DeleteOptions{}); err != nil {
+		return fmt.Errorf("failed to delete Flux %s: %v", object, err)
+	}
+	return nil
+}
+
+// fluxOwners returns the Flux objects to delete with ns, or an error when
+// ns must be skipped because Flux reconciles it, or may.
+func (gc *NamespaceGC) fluxOwners(ns *v1.Namespace, releases []HelmRelease, flux *FluxObjects) ([]FluxObject, error) {
+	if err := flux.unlisted(ns, releases); err != nil {
+		return nil, err
+	}
+	owners, err := flux.Owners(ns)
+	if err != nil {
+		return nil, err
+	}
+	if len(owners) > 0 && gc.config.Flux.Action != FluxActionDelete {
+		names := make([]string, 0, len(owners))
+		for _, owner := range owners {
+			names = append(names, owner.String())
+		}
+		return nil, fmt.Errorf("namespace is reconciled by Flux %s", strings.Join(names, ", "))
+	}
+	return owners, nil
+}
+
+// withoutFluxReleases leaves out the Helm releases managed by Flux HelmReleases,
+// all of them when the HelmReleases could not be listed.
+func (gc *NamespaceGC) withoutFluxReleases(releases []HelmRelease, flux *FluxObjects) []HelmRelease {
+	if flux != nil && flux.err != nil {
+		return nil
+	}
+	var result []HelmRelease
+	for _, release := range releases {
+		if owner := flux.ReleaseOwner(release); owner != nil {
+			gc.logger.Debugf("Helm release %s in namespace %s is managed by Flux %s, not uninstalling it", release.Name, release.Namespace, owner)
+			continue
+		}
+		result = append(result, release)
+	}
+	return result
+}
+
+// deleteFluxOwners suspends and deletes the Flux objects of ns before it is deleted.
+func (gc *NamespaceGC) deleteFluxOwners(ns *v1.Namespace, owners []FluxObject, runID string, actor AuditActor) error {
+	for _, owner := range owners {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := gc.flux.Delete(ctx, owner)
+		cancel()
+
+		entry := gc.auditEntry(AuditActionFluxDelete, ns, runID, actor, err)
+		entry.Policy = PolicyFlux
+		entry.FluxObject = owner.String()
+		gc.audit.Record(entry)
+		if err != nil {
+			return err
+		}
+
+		gc.logger.Infof("Suspended and deleted Flux %s reconciling namespace %s", owner, ns.Name)
+		gc.events.Namespace(ns, v1.EventTypeNormal, ReasonFluxObjectDeleted, "Suspended and deleted Flux %s before deleting the namespace", owner)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	testKustomizations = schema.GroupVersionResource{Group: fluxKustomizeGroup, Version: "v1", Resource: "kustomizations"}
+	testHelmReleases   = schema.GroupVersionResource{Group: fluxHelmGroup, Version: "v2beta1", Resource: "helmreleases"}
+)
+
+func newTestFluxObject(resource schema.GroupVersionResource, kind, namespace, name string, spec map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": resource.GroupVersion().String(),
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		"spec":       spec,
+	}}
+}
+
+func newTestFluxClient(objects ...runtime.Object) *FluxClient {
+	discovery := fake.NewSimpleClientset().Discovery().(*fakediscovery.FakeDiscovery)
+	discovery.Resources = []*metav1.APIResourceList{
+		{GroupVersion: testKustomizations.GroupVersion().String(), APIResources: []metav1.APIResource{{Name: "kustomizations", Kind: FluxKindKustomization, Namespaced: true}}},
+		{GroupVersion: testHelmReleases.GroupVersion().String(), APIResources: []metav1.APIResource{{Name: "helmreleases", Kind: FluxKindHelmRelease, Namespaced: true}}},
+	}
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		testKustomizations: "KustomizationList",
+		testHelmReleases:   "HelmReleaseList",
+	}, objects...)
+	return &FluxClient{config: &FluxConfig{Action: FluxActionDelete}, client: client, discovery: discovery, logger: logrus.New()}
+}
+
+func TestFluxOwners(t *testing.T) {
+	client := newTestFluxClient(
+		newTestFluxObject(testKustomizations, FluxKindKustomization, "flux-system", "apps", map[string]interface{}{}),
+		newTestFluxObject(testKustomizations, FluxKindKustomization, "flux-system", "preview-1", map[string]interface{}{"targetNamespace": "preview-1"}),
+		newTestFluxObject(testHelmReleases, FluxKindHelmRelease, "preview-1", "api", map[string]interface{}{}),
+		newTestFluxObject(testHelmReleases, FluxKindHelmRelease, "flux-system", "db", map[string]interface{}{"targetNamespace": "preview-1"}),
+	)
+	flux, err := client.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	owners, err := flux.Owners(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1", Labels: map[string]string{
+		"kustomize.toolkit.fluxcd.io/name":      "preview-1",
+		"kustomize.toolkit.fluxcd.io/namespace": "flux-system",
+	}}})
+	if err != nil {
+		t.Fatalf("Owners failed: %v", err)
+	}
+	var names []string
+	for _, owner := range owners {
+		names = append(names, owner.String())
+	}
+	expected := []string{"Kustomization flux-system/preview-1", "HelmRelease flux-system/db", "HelmRelease preview-1/api"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected owners %v, got %v", expected, names)
+	}
+
+	// A namespace applied by a Kustomization reconciling other namespaces too
+	shared := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-2", Labels: map[string]string{
+		"kustomize.toolkit.fluxcd.io/name":      "apps",
+		"kustomize.toolkit.fluxcd.io/namespace": "flux-system",
+	}}}
+	if _, err := flux.Owners(shared); err == nil {
+		t.Error("Expected a namespace of a shared Kustomization to be refused")
+	}
+
+	if owner := flux.ReleaseOwner(HelmRelease{Name: "preview-1-db", Namespace: "preview-1"}); owner == nil || owner.Name != "db" {
+		t.Errorf("Expected release preview-1-db to be owned by HelmRelease db, got %v", owner)
+	}
+	if owner := flux.ReleaseOwner(HelmRelease{Name: "api", Namespace: "preview-1"}); owner == nil || owner.Name != "api" {
+		t.Errorf("Expected release api to be owned by HelmRelease api, got %v", owner)
+	}
+	if owner := flux.ReleaseOwner(HelmRelease{Name: "web", Namespace: "preview-1"}); owner != nil {
+		t.Errorf("Expected release web not to be owned, got %v", owner)
+	}
+}
+
+func TestFluxLoadSkipsMissingGroups(t *testing.T) {
+	client := newTestFluxClient()
+	client.discovery.(*fakediscovery.FakeDiscovery).Resources = nil
+
+	flux, err := client.Load(context.Background())
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if owners, err := flux.Owners(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1"}}); err != nil || len(owners) != 0 {
+		t.Errorf("Expected no owners without Flux, got %v, %v", owners, err)
+	}
+}
+
+func TestFluxDeleteSuspendsFirst(t *testing.T) {
+	client := newTestFluxClient(newTestFluxObject(testHelmReleases, FluxKindHelmRelease, "preview-1", "api", map[string]interface{}{}))
+	object := FluxObject{Kind: FluxKindHelmRelease, Namespace: "preview-1", Name: "api", resource: testHelmReleases}
+
+	if err := client.Delete(context.Background(), object); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	var verbs []string
+	for _, action := range client.client.(*dynamicfake.FakeDynamicClient).Actions() {
+		verbs = append(verbs, action.GetVerb())
+	}
+	if !reflect.DeepEqual(verbs, []string{"patch", "delete"}) {
+		t.Errorf("Expected suspend before delete, got %v", verbs)
+	}
+}
+
+func TestUnlistedFluxObjectsSkipOnlyPossibleOwners(t *testing.T) {
+	gc := &NamespaceGC{config: &Config{Flux: FluxConfig{Enabled: true, Action: FluxActionDelete}}, logger: logrus.New()}
+	flux := unlistedFluxObjects(fmt.Errorf("the server is currently unable to handle the request"))
+	releases := []HelmRelease{{Name: "api", Namespace: "preview-1"}}
+
+	plain := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1"}}
+	if _, err := gc.fluxOwners(plain, nil, flux); err != nil {
+		t.Errorf("Expected a namespace without releases or Flux labels to be collected, got %v", err)
+	}
+	if _, err := gc.fluxOwners(plain, releases, flux); !isUnknownOwner(err) {
+		t.Errorf("Expected a namespace with Helm releases to be skipped with an unknown owner, got %v", err)
+	}
+	labeled := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-2", Labels: map[string]string{
+		fluxKustomizeGroup + "/name":      "apps",
+		fluxKustomizeGroup + "/namespace": "flux-system",
+	}}}
+	if _, err := gc.fluxOwners(labeled, nil, flux); err == nil {
+		t.Error("Expected a namespace applied by Flux to be skipped")
+	}
+	if kept := gc.withoutFluxReleases(releases, flux); len(kept) != 0 {
+		t.Errorf("Expected no release to be collected, got %+v", kept)
+	}
+}
@@ -2,138 +2,450 @@ package main
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"helm.sh/helm/v3/pkg/action"
-	"helm.sh/helm/v3/pkg/cli"
+	"helm.sh/helm/v3/pkg/kube"
 	"helm.sh/helm/v3/pkg/release"
-	"k8s.io/client-go/kubernetes"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
+// Helm storage drivers.
+const (
+	HelmDriverSecrets    = "secrets"
+	HelmDriverConfigMaps = "configmaps"
+	HelmDriverSQL        = "sql"
+)
+
+type HelmConfig struct {
+	// Driver is the release storage: secrets, configmaps or sql
+	Driver string `json:"driver"`
+	// SQLConnectionString is the PostgreSQL DSN of the sql driver
+	SQLConnectionString string              `json:"sql_connection_string"`
+	Uninstall           HelmUninstallConfig `json:"uninstall"`
+}
+
+// HelmUninstallConfig holds the options of helm uninstall.
+type HelmUninstallConfig struct {
+	// Wait waits until the deleted resources are gone
+	Wait         bool `json:"wait"`
+	KeepHistory  bool `json:"keep_history"`
+	DisableHooks bool `json:"disable_hooks"`
+	// DeletionPropagation is background (the default), foreground or orphan
+	DeletionPropagation string `json:"deletion_propagation"`
+	// RetryWithoutHooks retries once with hooks disabled when a pre-delete hook fails
+	RetryWithoutHooks bool `json:"retry_without_hooks"`
+	// KeptResources is what happens to resources annotated
+	// helm.sh/resource-policy: keep: leave (the default), delete or block
+	KeptResources string `json:"kept_resources"`
+}
+
+// UninstallAttempt is the outcome of one try to uninstall a release.
+type UninstallAttempt struct {
+	DisableHooks    bool    `json:"disable_hooks"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Error           string  `json:"error,omitempty"`
+	// HookFailed is set when the pre-delete hook failed
+	HookFailed bool `json:"hook_failed,omitempty"`
+}
+
+// HelmClient runs Helm actions. Every action gets a configuration bound to
+// the namespace it works on.
 type HelmClient struct {
-	actionConfig *action.Configuration
-	logger       *logrus.Logger
+	getter *restConfigGetter
+	config *HelmConfig
+	logger *logrus.Logger
+
+	mu         sync.Mutex
+	sqlStorage map[string]*storage.Storage
+
+	// fixed replaces the cluster-backed configuration of every namespace, in tests
+	fixed *action.Configuration
 }
 
+// HelmRelease describes a Helm release as listed at the start of a run.
 type HelmRelease struct {
-	Name      string
-	Namespace string
-	Status    string
-	Version   int
+	Name          string    `json:"name"`
+	Namespace     string    `json:"namespace"`
+	Status        string    `json:"status"`
+	Version       int       `json:"revision"`
+	Chart         string    `json:"chart,omitempty"`
+	ChartVersion  string    `json:"chart_version,omitempty"`
+	AppVersion    string    `json:"app_version,omitempty"`
+	FirstDeployed time.Time `json:"first_deployed"`
+	LastDeployed  time.Time `json:"last_deployed"`
+	Description   string    `json:"description,omitempty"`
+	// KeptResources are left behind by uninstall
+	KeptResources []KeptResource `json:"kept_resources,omitempty"`
 }
 
-func NewHelmClient() (*HelmClient, error) {
-	logger := logrus.New()
+func newHelmRelease(rel *release.Release) HelmRelease {
+	helmRelease := HelmRelease{
+		Name:      rel.Name,
+		Namespace: rel.Namespace,
+		Version:   rel.Version,
+		// KeptResources are annotated helm.sh/resource-policy: keep
+		KeptResources: keptResources(rel.Manifest),
+	}
+	if rel.Info != nil {
+		helmRelease.Status = string(rel.Info.Status)
+		helmRelease.FirstDeployed = rel.Info.FirstDeployed.Time
+		helmRelease.LastDeployed = rel.Info.LastDeployed.Time
+		helmRelease.Description = rel.Info.Description
+	}
+	if rel.Chart != nil && rel.Chart.Metadata != nil {
+		helmRelease.Chart = rel.Chart.Metadata.Name
+		helmRelease.ChartVersion = rel.Chart.Metadata.Version
+		helmRelease.AppVersion = rel.Chart.Metadata.AppVersion
+	}
+	return helmRelease
+}
 
-	// Initialize Helm action configuration
-	actionConfig := new(action.Configuration)
+// ChartRef returns the chart as name-version, the way helm list shows it.
+func (r HelmRelease) ChartRef() string {
+	if r.Chart == "" || r.ChartVersion == "" {
+		return r.Chart
+	}
+	return r.Chart + "-" + r.ChartVersion
+}
 
-	// Get Kubernetes config
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		// Fallback to kubeconfig
-		config, err = getKubeConfig()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get Kubernetes config: %v", err)
+// NewHelmClient returns a client that talks to the cluster with restConfig,
+// the config shared with the Kubernetes client.
+func NewHelmClient(restConfig *rest.Config, config *HelmConfig, logger *logrus.Logger) (*HelmClient, error) {
+	switch config.Driver {
+	case "":
+		config.Driver = HelmDriverSecrets
+	case HelmDriverSecrets, "secret", HelmDriverConfigMaps, "configmap":
+	case HelmDriverSQL:
+		if config.SQLConnectionString == "" {
+			return nil, fmt.Errorf("helm sql driver requires a connection string")
 		}
+	default:
+		return nil, fmt.Errorf("unsupported Helm driver %q", config.Driver)
 	}
 
-	// Create Kubernetes client (not used directly but required for Helm)
-	_, err = kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Kubernetes client: %v", err)
+	switch config.Uninstall.DeletionPropagation {
+	case "", "background", "foreground", "orphan":
+	default:
+		return nil, fmt.Errorf("unsupported deletion propagation %q", config.Uninstall.DeletionPropagation)
 	}
 
-	// Initialize action configuration
-	cliEnv := cli.New()
-	if cliEnv == nil {
-		return nil, fmt.Errorf("failed to create CLI environment")
+	switch config.Uninstall.KeptResources {
+	case "":
+		config.Uninstall.KeptResources = KeptResourcesLeave
+	case KeptResourcesLeave, KeptResourcesDelete, KeptResourcesBlock:
+	default:
+		return nil, fmt.Errorf("unsupported kept resources policy %q", config.Uninstall.KeptResources)
 	}
 
-	if err := actionConfig.Init(cliEnv.RESTClientGetter(), "", "secrets", func(format string, v ...interface{}) {
-		logger.Debugf(format, v...)
-	}); err != nil {
-		return nil, fmt.Errorf("failed to initialize Helm action config: %v", err)
+	getter, err := newRESTConfigGetter(restConfig)
+	if err != nil {
+		return nil, err
 	}
 
 	return &HelmClient{
-		actionConfig: actionConfig,
-		logger:       logger,
+		getter:     getter,
+		config:     config,
+		logger:     logger,
+		sqlStorage: make(map[string]*storage.Storage),
 	}, nil
 }
 
-func (hc *HelmClient) ListReleases(namespace string) ([]HelmRelease, error) {
-	if hc.actionConfig == nil {
-		return nil, fmt.Errorf("Helm action config is nil")
+// actionConfig returns a configuration bound to namespace: release storage is
+// read in that namespace and release resources without an explicit namespace
+// are resolved against it. An empty namespace reads the storage of all
+// namespaces and is only suitable for listing.
+func (hc *HelmClient) actionConfig(namespace string) (*action.Configuration, error) {
+	if hc.fixed != nil {
+		return hc.fixed, nil
+	}
+
+	log := func(format string, v ...interface{}) {
+		hc.logger.Debugf(format, v...)
+	}
+
+	// The sql driver is set up separately below, Init would read its DSN from the environment
+	initDriver := hc.config.Driver
+	if initDriver == HelmDriverSQL {
+		initDriver = HelmDriverSecrets
+	}
+
+	actionConfig := new(action.Configuration)
+	if err := actionConfig.Init(hc.getter, namespace, initDriver, log); err != nil {
+		return nil, fmt.Errorf("failed to initialize Helm action config: %v", err)
 	}
+	if kubeClient, ok := actionConfig.KubeClient.(*kube.Client); ok && namespace != "" {
+		kubeClient.Namespace = namespace
+	}
+
+	if hc.config.Driver == HelmDriverSQL {
+		releases, err := hc.sqlReleases(namespace, log)
+		if err != nil {
+			return nil, err
+		}
+		actionConfig.Releases = releases
+	}
+	return actionConfig, nil
+}
+
+// sqlReleases returns the sql release storage of namespace. Each one holds a
+// connection pool, so they are kept for the lifetime of the client.
+func (hc *HelmClient) sqlReleases(namespace string, log func(string, ...interface{})) (*storage.Storage, error) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if releases, ok := hc.sqlStorage[namespace]; ok {
+		return releases, nil
+	}
+	sqlDriver, err := driver.NewSQL(hc.config.SQLConnectionString, log, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Helm sql storage: %v", err)
+	}
+	releases := storage.Init(sqlDriver)
+	hc.sqlStorage[namespace] = releases
+	return releases, nil
+}
 
-	// Create list action
-	listAction := action.NewList(hc.actionConfig)
-	if listAction == nil {
-		return nil, fmt.Errorf("failed to create list action")
+// ListAllReleases lists the releases of every namespace with a single query
+// and groups them by namespace. It is called once per cleanup run, so it also
+// forgets cached API discovery to pick up new resource types.
+func (hc *HelmClient) ListAllReleases() (map[string][]HelmRelease, error) {
+	hc.getter.Reset()
+
+	releases, err := hc.list("")
+	if err != nil {
+		return nil, err
 	}
 
-	listAction.AllNamespaces = false
+	byNamespace := make(map[string][]HelmRelease)
+	for _, release := range releases {
+		byNamespace[release.Namespace] = append(byNamespace[release.Namespace], release)
+	}
+	return byNamespace, nil
+}
+
+// ListReleases lists the releases of a single namespace.
+func (hc *HelmClient) ListReleases(namespace string) ([]HelmRelease, error) {
+	return hc.list(namespace)
+}
+
+func (hc *HelmClient) list(namespace string) ([]HelmRelease, error) {
+	actionConfig, err := hc.actionConfig(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	listAction := action.NewList(actionConfig)
+	listAction.AllNamespaces = namespace == ""
 	listAction.StateMask = action.ListAll
 
-	// List releases
 	releases, err := listAction.Run()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list Helm releases: %v", err)
 	}
 
-	var helmReleases []HelmRelease
+	helmReleases := make([]HelmRelease, 0, len(releases))
 	for _, release := range releases {
-		// Filter by namespace
-		if release.Namespace == namespace {
-			helmReleases = append(helmReleases, HelmRelease{
-				Name:      release.Name,
-				Namespace: release.Namespace,
-				Status:    string(release.Info.Status),
-				Version:   release.Version,
-			})
-		}
+		helmReleases = append(helmReleases, newHelmRelease(release))
 	}
 
 	return helmReleases, nil
 }
 
-func (hc *HelmClient) UninstallRelease(releaseName, namespace string, timeout time.Duration) error {
-	if hc.actionConfig == nil {
-		return fmt.Errorf("Helm action config is nil")
+// ReleaseHistory returns every stored revision of a release.
+func (hc *HelmClient) ReleaseHistory(releaseName, namespace string) ([]*release.Release, error) {
+	actionConfig, err := hc.actionConfig(namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	history, err := actionConfig.Releases.History(releaseName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history of Helm release %s: %v", releaseName, err)
+	}
+	return history, nil
+}
+
+// PurgeRelease deletes every stored revision of a release without touching
+// its resources, so Helm forgets about it.
+func (hc *HelmClient) PurgeRelease(releaseName, namespace string) error {
+	actionConfig, err := hc.actionConfig(namespace)
+	if err != nil {
+		return err
+	}
+
+	history, err := actionConfig.Releases.History(releaseName)
+	if err != nil {
+		return fmt.Errorf("failed to get history of Helm release %s: %v", releaseName, err)
+	}
+	for _, revision := range history {
+		if _, err := actionConfig.Releases.Delete(revision.Name, revision.Version); err != nil {
+			return fmt.Errorf("failed to purge revision %d of Helm release %s: %v", revision.Version, releaseName, err)
+		}
+	}
+
+	hc.logger.Infof("Purged %d revisions of Helm release %s in namespace %s", len(history), releaseName, namespace)
+	return nil
+}
+
+// DeleteKeptResources deletes the resources that uninstalling release kept and
+// marks them as deleted. Resources that are already gone count as deleted.
+func (hc *HelmClient) DeleteKeptResources(release *HelmRelease) error {
+	actionConfig, err := hc.actionConfig(release.Namespace)
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for i := range release.KeptResources {
+		kept := &release.KeptResources[i]
+		resources, err := actionConfig.KubeClient.Build(strings.NewReader(kept.manifest), false)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", kept, err))
+			continue
+		}
+		if _, deleteErrs := actionConfig.KubeClient.Delete(resources); len(deleteErrs) > 0 {
+			errs = append(errs, fmt.Sprintf("%s: %v", kept, deleteErrs[0]))
+			continue
+		}
+		kept.Deleted = true
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to delete kept resources: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// UninstallRelease uninstalls a release with the configured options. When the
+// pre-delete hook fails and retry_without_hooks is set, it is retried once with
+// hooks disabled. Every attempt is returned, also on failure.
+func (hc *HelmClient) UninstallRelease(releaseName, namespace string, timeout time.Duration) ([]UninstallAttempt, error) {
+	options := hc.config.Uninstall
+
+	attempt, err := hc.uninstall(releaseName, namespace, timeout, options.DisableHooks)
+	attempts := []UninstallAttempt{attempt}
+	if err != nil && attempt.HookFailed && options.RetryWithoutHooks {
+		hc.logger.Warnf("Pre-delete hook of Helm release %s in namespace %s failed, retrying without hooks: %v", releaseName, namespace, err)
+		attempt, err = hc.uninstall(releaseName, namespace, timeout, true)
+		attempts = append(attempts, attempt)
+	}
+	if err != nil {
+		return attempts, fmt.Errorf("failed to uninstall Helm release %s: %v", releaseName, err)
+	}
+
+	hc.logger.Infof("Successfully uninstalled Helm release %s in namespace %s", releaseName, namespace)
+	return attempts, nil
+}
+
+func (hc *HelmClient) uninstall(releaseName, namespace string, timeout time.Duration, disableHooks bool) (UninstallAttempt, error) {
+	attempt := UninstallAttempt{DisableHooks: disableHooks}
+
+	actionConfig, err := hc.actionConfig(namespace)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, err
 	}
 
 	// Create uninstall action
-	uninstallAction := action.NewUninstall(hc.actionConfig)
+	uninstallAction := action.NewUninstall(actionConfig)
 	if uninstallAction == nil {
-		return fmt.Errorf("failed to create uninstall action")
+		err := fmt.Errorf("failed to create uninstall action")
+		attempt.Error = err.Error()
+		return attempt, err
 	}
 
 	uninstallAction.Timeout = timeout
-	uninstallAction.Wait = true
+	uninstallAction.Wait = hc.config.Uninstall.Wait
+	uninstallAction.KeepHistory = hc.config.Uninstall.KeepHistory
+	uninstallAction.DeletionPropagation = hc.config.Uninstall.DeletionPropagation
+	uninstallAction.DisableHooks = disableHooks
 
 	// Uninstall release
-	_, err := uninstallAction.Run(releaseName)
+	start := time.Now()
+	response, err := uninstallAction.Run(releaseName)
+	attempt.DurationSeconds = time.Since(start).Seconds()
 	if err != nil {
-		return fmt.Errorf("failed to uninstall Helm release %s: %v", releaseName, err)
+		attempt.Error = err.Error()
+		attempt.HookFailed = preDeleteHookFailed(response)
 	}
+	return attempt, err
+}
 
-	hc.logger.Infof("Successfully uninstalled Helm release: %s", releaseName)
-	return nil
+// preDeleteHookFailed reports whether the uninstall stopped at a failed
+// pre-delete hook. Helm returns the release with its hook runs in that case.
+func preDeleteHookFailed(response *release.UninstallReleaseResponse) bool {
+	if response == nil || response.Release == nil {
+		return false
+	}
+	for _, hook := range response.Release.Hooks {
+		if hook.LastRun.Phase != release.HookPhaseFailed {
+			continue
+		}
+		for _, event := range hook.Events {
+			if event == release.HookPreDelete {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HelmTimeoutAnnotation overrides helm_release_timeout for the releases of a
+// namespace. Its value is a comma-separated list of a duration for all
+// releases and release=duration entries, e.g. "10m,api=30m".
+const HelmTimeoutAnnotation = "kube-ns-gc/helm-timeout"
+
+// releaseTimeout returns the uninstall timeout of releaseName set by
+// annotations, or fallback.
+func releaseTimeout(annotations map[string]string, releaseName string, fallback time.Duration) (time.Duration, error) {
+	value, ok := annotations[HelmTimeoutAnnotation]
+	if !ok {
+		return fallback, nil
+	}
+
+	timeout, found := fallback, false
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, duration, named := strings.Cut(entry, "=")
+		if !named {
+			duration = name
+		}
+		parsed, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil || parsed <= 0 {
+			return fallback, fmt.Errorf("invalid %s annotation %q", HelmTimeoutAnnotation, value)
+		}
+		switch {
+		case named && strings.TrimSpace(name) == releaseName:
+			timeout, found = parsed, true
+		case !named && !found:
+			timeout = parsed
+		}
+	}
+	return timeout, nil
 }
 
 func (hc *HelmClient) GetReleaseStatus(releaseName, namespace string) (*release.Release, error) {
-	if hc.actionConfig == nil {
-		return nil, fmt.Errorf("Helm action config is nil")
+	actionConfig, err := hc.actionConfig(namespace)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create get action
-	getAction := action.NewGet(hc.actionConfig)
+	getAction := action.NewGet(actionConfig)
 	if getAction == nil {
 		return nil, fmt.Errorf("failed to create get action")
 	}
@@ -147,18 +459,46 @@ func (hc *HelmClient) GetReleaseStatus(releaseName, namespace string) (*release.
 	return release, nil
 }
 
-func getKubeConfig() (*rest.Config, error) {
-	// Try to get kubeconfig from environment
-	kubeconfig := os.Getenv("KUBECONFIG")
-	if kubeconfig == "" {
-		kubeconfig = filepath.Join(os.Getenv("HOME"), ".kube", "config")
-	}
+// restConfigGetter hands a ready REST config to Helm in place of kubeconfig
+// flags. Discovery is cached in memory until Reset.
+type restConfigGetter struct {
+	config    *rest.Config
+	discovery discovery.CachedDiscoveryInterface
+	mapper    *restmapper.DeferredDiscoveryRESTMapper
+}
 
-	// Build config from kubeconfig file
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+func newRESTConfigGetter(config *rest.Config) (*restConfigGetter, error) {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to build config from kubeconfig: %v", err)
+		return nil, fmt.Errorf("failed to create discovery client: %v", err)
 	}
+	cached := memory.NewMemCacheClient(discoveryClient)
+	return &restConfigGetter{
+		config:    config,
+		discovery: cached,
+		mapper:    restmapper.NewDeferredDiscoveryRESTMapper(cached),
+	}, nil
+}
+
+func (g *restConfigGetter) ToRESTConfig() (*rest.Config, error) {
+	return rest.CopyConfig(g.config), nil
+}
+
+func (g *restConfigGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
+	return g.discovery, nil
+}
+
+func (g *restConfigGetter) ToRESTMapper() (meta.RESTMapper, error) {
+	return restmapper.NewShortcutExpander(g.mapper, g.discovery), nil
+}
+
+// ToRawKubeConfigLoader returns an empty kubeconfig. Helm only asks it for the
+// default namespace, which HelmClient always sets explicitly.
+func (g *restConfigGetter) ToRawKubeConfigLoader() clientcmd.ClientConfig {
+	return clientcmd.NewDefaultClientConfig(*clientcmdapi.NewConfig(), &clientcmd.ConfigOverrides{})
+}
 
-	return config, nil
+// Reset forgets the cached discovery information.
+func (g *restConfigGetter) Reset() {
+	g.mapper.Reset()
 }
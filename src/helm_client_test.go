@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	helmtime "helm.sh/helm/v3/pkg/time"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+)
+
+func newTestHelmClient(t *testing.T, config *HelmConfig) *HelmClient {
+	hc, err := NewHelmClient(&rest.Config{Host: "https://127.0.0.1:6443", QPS: 20, Burst: 40}, config, logrus.New())
+	if err != nil {
+		t.Fatalf("NewHelmClient failed: %v", err)
+	}
+	return hc
+}
+
+func TestHelmActionConfigBoundToNamespace(t *testing.T) {
+	hc := newTestHelmClient(t, &HelmConfig{Driver: HelmDriverConfigMaps})
+
+	actionConfig, err := hc.actionConfig("preview-1")
+	if err != nil {
+		t.Fatalf("actionConfig failed: %v", err)
+	}
+	kubeClient, ok := actionConfig.KubeClient.(*kube.Client)
+	if !ok || kubeClient.Namespace != "preview-1" {
+		t.Errorf("Expected the kube client to be bound to preview-1, got %+v", actionConfig.KubeClient)
+	}
+	if name := actionConfig.Releases.Name(); name != "ConfigMap" {
+		t.Errorf("Expected the configmaps driver, got %s", name)
+	}
+
+	actionConfig, err = hc.actionConfig("")
+	if err != nil {
+		t.Fatalf("actionConfig failed: %v", err)
+	}
+	if kubeClient := actionConfig.KubeClient.(*kube.Client); kubeClient.Namespace != "" {
+		t.Errorf("Expected the all-namespaces config to stay unbound, got %q", kubeClient.Namespace)
+	}
+}
+
+func TestHelmClientSharesRESTConfig(t *testing.T) {
+	hc := newTestHelmClient(t, &HelmConfig{})
+	if hc.config.Driver != HelmDriverSecrets {
+		t.Errorf("Expected the secrets driver by default, got %q", hc.config.Driver)
+	}
+
+	actionConfig, err := hc.actionConfig("preview-1")
+	if err != nil {
+		t.Fatalf("actionConfig failed: %v", err)
+	}
+	config, err := actionConfig.RESTClientGetter.ToRESTConfig()
+	if err != nil {
+		t.Fatalf("ToRESTConfig failed: %v", err)
+	}
+	if config.Host != "https://127.0.0.1:6443" || config.QPS != 20 || config.Burst != 40 {
+		t.Errorf("Expected Helm to use the shared REST config, got %+v", config)
+	}
+}
+
+func TestHelmConfigValidation(t *testing.T) {
+	configs := []HelmConfig{
+		{Driver: "memory"},
+		{Driver: HelmDriverSQL},
+		{Driver: HelmDriverSecrets, Uninstall: HelmUninstallConfig{DeletionPropagation: "cascade"}},
+		{Driver: HelmDriverSecrets, Uninstall: HelmUninstallConfig{KeptResources: "orphan"}},
+	}
+	for _, config := range configs {
+		if _, err := NewHelmClient(&rest.Config{Host: "https://127.0.0.1:6443"}, &config, logrus.New()); err == nil {
+			t.Errorf("Expected Helm config %+v to be rejected", config)
+		}
+	}
+}
+
+func TestNewHelmRelease(t *testing.T) {
+	firstDeployed := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	lastDeployed := firstDeployed.Add(48 * time.Hour)
+	got := newHelmRelease(&release.Release{
+		Name:      "api",
+		Namespace: "preview-1",
+		Version:   4,
+		Info: &release.Info{
+			Status:        release.StatusDeployed,
+			FirstDeployed: helmtime.Time{Time: firstDeployed},
+			LastDeployed:  helmtime.Time{Time: lastDeployed},
+			Description:   "Upgrade complete",
+		},
+		Chart: &chart.Chart{Metadata: &chart.Metadata{Name: "api", Version: "1.2.0", AppVersion: "2.0.1"}},
+	})
+
+	expected := HelmRelease{
+		Name:          "api",
+		Namespace:     "preview-1",
+		Status:        "deployed",
+		Version:       4,
+		Chart:         "api",
+		ChartVersion:  "1.2.0",
+		AppVersion:    "2.0.1",
+		FirstDeployed: firstDeployed,
+		LastDeployed:  lastDeployed,
+		Description:   "Upgrade complete",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+	if ref := got.ChartRef(); ref != "api-1.2.0" {
+		t.Errorf("Expected chart api-1.2.0, got %q", ref)
+	}
+
+	if bare := newHelmRelease(&release.Release{Name: "broken", Version: 1}); bare.Status != "" || bare.Chart != "" {
+		t.Errorf("Expected a release without info and chart to stay empty, got %+v", bare)
+	}
+}
+
+func TestReleaseTimeout(t *testing.T) {
+	tests := []struct {
+		annotation string
+		release    string
+		expected   time.Duration
+		valid      bool
+	}{
+		{"", "api", 5 * time.Minute, true},
+		{"10m", "api", 10 * time.Minute, true},
+		{"10m,api=30m", "api", 30 * time.Minute, true},
+		{"api=30m, 10m", "api", 30 * time.Minute, true},
+		{"10m,api=30m", "web", 10 * time.Minute, true},
+		{"api=30m", "web", 5 * time.Minute, true},
+		{"api=soon", "api", 5 * time.Minute, false},
+		{"-1m", "api", 5 * time.Minute, false},
+	}
+
+	for _, test := range tests {
+		annotations := map[string]string{}
+		if test.annotation != "" {
+			annotations[HelmTimeoutAnnotation] = test.annotation
+		}
+		timeout, err := releaseTimeout(annotations, test.release, 5*time.Minute)
+		if timeout != test.expected || (err == nil) != test.valid {
+			t.Errorf("releaseTimeout(%q, %s) = %v, %v; expected %v, valid=%v", test.annotation, test.release, timeout, err, test.expected, test.valid)
+		}
+	}
+}
+
+func TestPreDeleteHookFailed(t *testing.T) {
+	hook := func(event release.HookEvent, phase release.HookPhase) *release.Hook {
+		return &release.Hook{Events: []release.HookEvent{event}, LastRun: release.HookExecution{Phase: phase}}
+	}
+	response := func(hooks ...*release.Hook) *release.UninstallReleaseResponse {
+		return &release.UninstallReleaseResponse{Release: &release.Release{Name: "api", Hooks: hooks}}
+	}
+
+	tests := []struct {
+		response *release.UninstallReleaseResponse
+		expected bool
+	}{
+		{nil, false},
+		{response(hook(release.HookPreDelete, release.HookPhaseFailed)), true},
+		{response(hook(release.HookPreDelete, release.HookPhaseSucceeded)), false},
+		{response(hook(release.HookPostInstall, release.HookPhaseFailed), hook(release.HookPreDelete, release.HookPhaseUnknown)), false},
+	}
+	for i, test := range tests {
+		if failed := preDeleteHookFailed(test.response); failed != test.expected {
+			t.Errorf("Case %d: expected %v, got %v", i, test.expected, failed)
+		}
+	}
+}
+
+// unlistableDriver stores releases but fails to list them.
+type unlistableDriver struct {
+	*driver.Memory
+}
+
+func (d unlistableDriver) List(func(*release.Release) bool) ([]*release.Release, error) {
+	return nil, fmt.Errorf("secrets is forbidden")
+}
+
+func (d unlistableDriver) Query(map[string]string) ([]*release.Release, error) {
+	return nil, fmt.Errorf("secrets is forbidden")
+}
+
+func TestPerformCleanupContinuesWithoutReleaseList(t *testing.T) {
+	gc, _, notifier := newEscalationTestGC(t, FailureEscalationConfig{
+		Enabled:        true,
+		After:          1,
+		StateConfigMap: "kube-ns-gc-failures",
+		StateNamespace: "kube-ns-gc",
+	})
+	getter, err := newRESTConfigGetter(&rest.Config{Host: "https://127.0.0.1:6443"})
+	if err != nil {
+		t.Fatalf("newRESTConfigGetter failed: %v", err)
+	}
+	gc.helmClient.getter = getter
+	gc.helmClient.fixed.Releases = storage.Init(unlistableDriver{driver.NewMemory()})
+
+	gc.performCleanup()
+
+	var summary *CleanupReport
+	for _, event := range notifier.events {
+		if event.Type == EventCleanupSummary {
+			summary = event.Report
+		}
+	}
+	if summary == nil {
+		t.Fatal("Expected the run to complete without the cluster-wide release list")
+	}
+	if len(summary.Deleted) != 0 || len(summary.Failed) != 1 || summary.Failed[0].Name != "preview-1" {
+		t.Errorf("Expected preview-1 to fail without its releases, got deleted %+v, failed %+v", summary.Deleted, summary.Failed)
+	}
+}
+
+func TestPerformCleanupEscalatesUnlistedReleases(t *testing.T) {
+	defer func(interval time.Duration) { namespaceDeletionPollInterval = interval }(namespaceDeletionPollInterval)
+	namespaceDeletionPollInterval = time.Millisecond
+
+	gc, _, notifier := newEscalationTestGC(t, FailureEscalationConfig{
+		Enabled:         true,
+		After:           2,
+		DeleteNamespace: true,
+		StateConfigMap:  "kube-ns-gc-failures",
+		StateNamespace:  "kube-ns-gc",
+	})
+	getter, err := newRESTConfigGetter(&rest.Config{Host: "https://127.0.0.1:6443"})
+	if err != nil {
+		t.Fatalf("newRESTConfigGetter failed: %v", err)
+	}
+	gc.helmClient.getter = getter
+	gc.helmClient.fixed.Releases = storage.Init(unlistableDriver{driver.NewMemory()})
+
+	// A namespace too young to be deleted is not held for its releases
+	young := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-2", UID: "uid-2", CreationTimestamp: metav1.Now()}}
+	if _, err := gc.clientset.CoreV1().Namespaces().Create(context.Background(), young, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create namespace: %v", err)
+	}
+
+	// The first failure is kept across runs but not escalated yet
+	gc.performCleanup()
+	if records := gc.failures.Records(); len(records) != 1 || records[0].Namespace != "preview-1" || records[0].Count != 1 {
+		t.Fatalf("Expected one failure of preview-1 to be tracked, got %+v", records)
+	}
+	if len(escalations(notifier.events)) != 0 {
+		t.Errorf("Expected no escalation after one failure, got %+v", notifier.events)
+	}
+	if _, err := gc.clientset.CoreV1().Namespaces().Get(context.Background(), "preview-1", metav1.GetOptions{}); err != nil {
+		t.Fatalf("Expected the namespace to be kept: %v", err)
+	}
+
+	// The second failure escalates and deletes the namespace anyway
+	gc.performCleanup()
+	escalated := escalations(notifier.events)
+	if len(escalated) != 1 || escalated[0].Namespace != "preview-1" || escalated[0].Release != "" {
+		t.Errorf("Expected one escalation of preview-1, got %+v", escalated)
+	}
+	if _, err := gc.clientset.CoreV1().Namespaces().Get(context.Background(), "preview-1", metav1.GetOptions{}); err == nil {
+		t.Error("Expected the namespace to be deleted")
+	}
+	if records := gc.failures.Records(); len(records) != 0 {
+		t.Errorf("Expected the failure to be cleared, got %+v", records)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Policies for resources annotated helm.sh/resource-policy: keep.
+const (
+	KeptResourcesLeave  = "leave"
+	KeptResourcesDelete = "delete"
+	KeptResourcesBlock  = "block"
+)
+
+// KeptResource is a resource that helm uninstall leaves behind because of
+// helm.sh/resource-policy: keep.
+type KeptResource struct {
+	APIVersion string `json:"api_version"`
+	Kind       string `json:"kind"`
+	Namespace  string `json:"namespace,omitempty"`
+	Name       string `json:"name"`
+	// Deleted is set once kube-ns-gc deleted the resource itself
+	Deleted bool `json:"deleted,omitempty"`
+
+	manifest string
+}
+
+func (r KeptResource) String() string {
+	return r.Kind + "/" + r.Name
+}
+
+// manifestHead is the part of a manifest needed to identify a resource.
+type manifestHead struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name        string            `json:"name"`
+		Namespace   string            `json:"namespace"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"metadata"`
+}
+
+// keptResources returns the resources of a release manifest that uninstall
+// keeps, matching the annotation the way Helm does.
+func keptResources(manifest string) []KeptResource {
+	documents := releaseutil.SplitManifests(manifest)
+	keys := make([]string, 0, len(documents))
+	for key := range documents {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	var kept []KeptResource
+	for _, key := range keys {
+		var head manifestHead
+		if err := yaml.Unmarshal([]byte(documents[key]), &head); err != nil || head.Kind == "" {
+			continue
+		}
+		policy := head.Metadata.Annotations[kube.ResourcePolicyAnno]
+		if strings.ToLower(strings.TrimSpace(policy)) != kube.KeepPolicy {
+			continue
+		}
+		kept = append(kept, KeptResource{
+			APIVersion: head.APIVersion,
+			Kind:       head.Kind,
+			Namespace:  head.Metadata.Namespace,
+			Name:       head.Metadata.Name,
+			manifest:   documents[key],
+		})
+	}
+	return kept
+}
+
+// KeptSummary lists the kept resources, e.g. "PersistentVolumeClaim/data (deleted)".
+func (r HelmRelease) KeptSummary() string {
+	names := make([]string, 0, len(r.KeptResources))
+	for _, kept := range r.KeptResources {
+		name := kept.String()
+		if kept.Deleted {
+			name += " (deleted)"
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// keptResourcesBlock returns an error when the kept resources of release
+// block its uninstall.
+func (gc *NamespaceGC) keptResourcesBlock(release *HelmRelease) error {
+	if gc.config.Helm.Uninstall.KeptResources != KeptResourcesBlock || len(release.KeptResources) == 0 {
+		return nil
+	}
+	return fmt.Errorf("Helm release %s keeps resources: %s", release.Name, release.KeptSummary())
+}
+
+// handleKeptResources deletes or reports the resources an uninstalled
+// release left behind.
+func (gc *NamespaceGC) handleKeptResources(ns *v1.Namespace, release *HelmRelease) {
+	if len(release.KeptResources) == 0 {
+		return
+	}
+
+	if gc.config.Helm.Uninstall.KeptResources != KeptResourcesDelete {
+		gc.logger.Warnf("Helm release %s left resources behind: %s", release.Name, release.KeptSummary())
+		gc.events.Namespace(ns, v1.EventTypeNormal, ReasonResourcesKept, "Helm release %s left resources behind: %s", release.Name, release.KeptSummary())
+		return
+	}
+
+	if err := gc.helmClient.DeleteKeptResources(release); err != nil {
+		gc.logger.Errorf("Failed to delete resources kept by Helm release %s: %v", release.Name, err)
+		gc.events.Namespace(ns, v1.EventTypeWarning, ReasonResourcesKept, "Failed to delete resources kept by Helm release %s: %v", release.Name, err)
+		return
+	}
+	gc.logger.Infof("Deleted resources kept by Helm release %s: %s", release.Name, release.KeptSummary())
+	gc.events.Namespace(ns, v1.EventTypeNormal, ReasonKeptResourcesDeleted, "Deleted resources kept by Helm release %s: %s", release.Name, release.KeptSummary())
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+const testReleaseManifest = `---
+# Source: api/templates/pvc.yaml
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+  annotations:
+    helm.sh/resource-policy: " Keep "
+spec:
+  accessModes: [ReadWriteOnce]
+---
+# Source: api/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+---
+# Source: api/templates/secret.yaml
+apiVersion: v1
+kind: Secret
+metadata:
+  name: creds
+  namespace: shared
+  annotations:
+    helm.sh/resource-policy: keep
+`
+
+func TestKeptResources(t *testing.T) {
+	kept := keptResources(testReleaseManifest)
+	if len(kept) != 2 {
+		t.Fatalf("Expected 2 kept resources, got %+v", kept)
+	}
+	if kept[0].String() != "PersistentVolumeClaim/data" || kept[0].APIVersion != "v1" || kept[0].Namespace != "" {
+		t.Errorf("Unexpected first kept resource %+v", kept[0])
+	}
+	if kept[1].String() != "Secret/creds" || kept[1].Namespace != "shared" || kept[1].manifest == "" {
+		t.Errorf("Unexpected second kept resource %+v", kept[1])
+	}
+
+	if kept := keptResources(""); kept != nil {
+		t.Errorf("Expected no kept resources for an empty manifest, got %+v", kept)
+	}
+}
+
+func TestKeptSummary(t *testing.T) {
+	release := HelmRelease{KeptResources: []KeptResource{
+		{Kind: "PersistentVolumeClaim", Name: "data", Deleted: true},
+		{Kind: "Secret", Name: "creds"},
+	}}
+	if summary := release.KeptSummary(); summary != "PersistentVolumeClaim/data (deleted), Secret/creds" {
+		t.Errorf("Unexpected summary %q", summary)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Event reasons.
+const (
+	ReasonDeletionScheduled          = "DeletionScheduled"
+	ReasonDeletionPostponed          = "DeletionPostponed"
+	ReasonProtected                  = "Protected"
+	ReasonDeleting                   = "Deleting"
+	ReasonNamespaceDeleted           = "NamespaceDeleted"
+	ReasonHelmReleaseUninstalled     = "HelmReleaseUninstalled"
+	ReasonResourcesKept              = "ResourcesKept"
+	ReasonKeptResourcesDeleted       = "KeptResourcesDeleted"
+	ReasonArgoCDApplicationDeleted   = "ArgoCDApplicationDeleted"
+	ReasonArgoCDApplicationSuspended = "ArgoCDApplicationSuspended"
+	ReasonFluxObjectDeleted          = "FluxObjectDeleted"
+	ReasonCleanupCompleted           = "CleanupCompleted"
+)
+
+// failureEventReasons are the Warning event reasons of each failure kind.
+var failureEventReasons = map[string]string{
+	FailureRunAborted:        "RunAborted",
+	FailureApproval:          "ApprovalFailed",
+	FailureNamespaceCleanup:  "NamespaceCleanupFailed",
+	FailureHelmUninstall:     "HelmUninstallFailed",
+	FailureHelmHook:          "HelmHookFailed",
+	FailureHelmArchive:       "HelmArchiveFailed",
+	FailureEscalated:         "CleanupEscalated",
+	FailureStuckTerminating:  "NamespaceStuckTerminating",
+	FailureFluxUnavailable:   "FluxUnavailable",
+	FailureArgoCDUnavailable: "ArgoCDUnavailable",
+}
+
+type KubernetesEventsConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// EventRecorder records Kubernetes Events about namespaces and about the
+// kube-ns-gc pod. A nil *EventRecorder records nothing.
+type EventRecorder struct {
+	recorder record.EventRecorder
+	pod      *v1.ObjectReference
+	shutdown func()
+}
+
+// NewEventRecorder starts an event broadcaster that writes to the API server.
+// The pod is taken from the POD_NAME, POD_NAMESPACE and POD_UID variables;
+// without them run events are only logged.
+func NewEventRecorder(clientset kubernetes.Interface, logger *logrus.Logger) *EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	broadcaster.StartEventWatcher(func(event *v1.Event) {
+		logger.Debugf("Event %s/%s %s: %s", event.InvolvedObject.Kind, event.InvolvedObject.Name, event.Reason, event.Message)
+	})
+
+	pod := podReference()
+	if pod == nil {
+		logger.Warn("POD_NAME and POD_NAMESPACE are not set, run events will not be recorded")
+	}
+
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "kube-ns-gc"})
+	return &EventRecorder{recorder: recorder, pod: pod, shutdown: broadcaster.Shutdown}
+}
+
+// podReference returns the reference to the pod kube-ns-gc runs in, or nil.
+func podReference() *v1.ObjectReference {
+	name, namespace := getEnvString("POD_NAME", ""), getEnvString("POD_NAMESPACE", "")
+	if name == "" || namespace == "" {
+		return nil
+	}
+	return &v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Name:       name,
+		Namespace:  namespace,
+		UID:        types.UID(getEnvString("POD_UID", "")),
+	}
+}
+
+// namespaceReference refers to ns. The event is stored in ns itself, so it
+// shows up in kubectl get events -n <namespace>.
+func namespaceReference(ns *v1.Namespace) *v1.ObjectReference {
+	return &v1.ObjectReference{
+		Kind:       "Namespace",
+		APIVersion: "v1",
+		Name:       ns.Name,
+		Namespace:  ns.Name,
+		UID:        ns.UID,
+	}
+}
+
+// Namespace records an event on ns.
+func (r *EventRecorder) Namespace(ns *v1.Namespace, eventType, reason, messageFmt string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+	r.recorder.Eventf(namespaceReference(ns), eventType, reason, messageFmt, args...)
+}
+
+// Pod records an event on the kube-ns-gc pod.
+func (r *EventRecorder) Pod(eventType, reason, messageFmt string, args ...interface{}) {
+	if r == nil || r.pod == nil {
+		return
+	}
+	r.recorder.Eventf(r.pod, eventType, reason, messageFmt, args...)
+}
+
+// Failure records a failure on the pod with the reason of its kind.
+func (r *EventRecorder) Failure(failure, message string, err error) {
+	reason := failureEventReasons[failure]
+	if reason == "" {
+		reason = "CleanupFailed"
+	}
+	r.Pod(v1.EventTypeWarning, reason, "%s: %v", message, err)
+}
+
+// Shutdown flushes and stops the broadcaster.
+func (r *EventRecorder) Shutdown(ctx context.Context) {
+	if r == nil || r.shutdown == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		r.shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// runSummaryMessage describes a finished cleanup run.
+func runSummaryMessage(report *CleanupReport) string {
+	return fmt.Sprintf("Run %s: checked %d namespaces, deleted %d, skipped %d, failed %d in %s",
+		report.RunID, report.TotalNamespaces, len(report.Deleted), len(report.Skipped), len(report.Failed), report.Duration.Round(time.Millisecond))
+}
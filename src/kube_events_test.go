@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestEventRecorder(t *testing.T) {
+	fake := record.NewFakeRecorder(10)
+	recorder := &EventRecorder{recorder: fake, pod: &v1.ObjectReference{Kind: "Pod", Name: "kube-ns-gc-0", Namespace: "kube-ns-gc"}}
+
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1", UID: "uid-1"}}
+	recorder.Namespace(ns, v1.EventTypeNormal, ReasonHelmReleaseUninstalled, "Uninstalled Helm release %s", "api")
+	recorder.Failure(FailureStuckTerminating, "Namespace preview-2 is stuck in Terminating", errors.New("terminating since 2024-01-02T03:04:05Z"))
+	recorder.Failure("unknown", "Something failed", errors.New("boom"))
+
+	expected := []string{
+		"Normal HelmReleaseUninstalled Uninstalled Helm release api",
+		"Warning NamespaceStuckTerminating Namespace preview-2 is stuck in Terminating: terminating since 2024-01-02T03:04:05Z",
+		"Warning CleanupFailed Something failed: boom",
+	}
+	for _, want := range expected {
+		select {
+		case got := <-fake.Events:
+			if got != want {
+				t.Errorf("Expected event %q, got %q", want, got)
+			}
+		default:
+			t.Fatalf("Expected event %q, got none", want)
+		}
+	}
+}
+
+func TestEventRecorderWithoutPod(t *testing.T) {
+	fake := record.NewFakeRecorder(10)
+	recorder := &EventRecorder{recorder: fake}
+	recorder.Pod(v1.EventTypeNormal, ReasonCleanupCompleted, "done")
+	if len(fake.Events) != 0 {
+		t.Errorf("Expected pod events to be dropped without a pod reference")
+	}
+
+	var disabled *EventRecorder
+	disabled.Namespace(&v1.Namespace{}, v1.EventTypeNormal, ReasonDeleting, "ignored")
+	disabled.Pod(v1.EventTypeNormal, ReasonCleanupCompleted, "ignored")
+}
+
+func TestEventReferences(t *testing.T) {
+	t.Setenv("POD_NAME", "kube-ns-gc-0")
+	t.Setenv("POD_NAMESPACE", "kube-ns-gc")
+	t.Setenv("POD_UID", "pod-uid")
+	if pod := podReference(); pod == nil || pod.Name != "kube-ns-gc-0" || pod.Namespace != "kube-ns-gc" || pod.UID != "pod-uid" {
+		t.Errorf("Unexpected pod reference: %+v", pod)
+	}
+
+	t.Setenv("POD_NAME", "")
+	if pod := podReference(); pod != nil {
+		t.Errorf("Expected no pod reference without POD_NAME, got %+v", pod)
+	}
+
+	ref := namespaceReference(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "preview-1", UID: "uid-1"}})
+	if ref.Kind != "Namespace" || ref.Namespace != "preview-1" || ref.UID != "uid-1" {
+		t.Errorf("Expected the event to be stored in the namespace itself, got %+v", ref)
+	}
+}
+
+func TestRunSummaryMessage(t *testing.T) {
+	report := NewCleanupReport(time.Now())
+	report.RunID = "run-1"
+	report.AddChecked("preview-1", nil)
+	report.AddChecked("preview-2", nil)
+	report.AddDeleted(DeletedNamespace{Name: "preview-1"})
+	report.AddSkipped("preview-2", "waiting for approval")
+	report.Duration = 1500 * time.Millisecond
+
+	expected := "Run run-1: checked 2 namespaces, deleted 1, skipped 1, failed 0 in 1.5s"
+	if message := runSummaryMessage(report); message != expected {
+		t.Errorf("Expected %q, got %q", expected, message)
+	}
+}
@@ -15,29 +15,59 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
 type Config struct {
-	CleanupInterval    time.Duration  `json:"cleanup_interval"`
-	NamespaceMaxAge    time.Duration  `json:"namespace_max_age"`
-	HelmReleaseTimeout time.Duration  `json:"helm_release_timeout"`
-	ExcludedNamespaces []string       `json:"excluded_namespaces"`
-	IgnoreLabel        string         `json:"ignore_label"`
-	LogLevel           string         `json:"log_level"`
-	Port               int            `json:"port"`
-	Telegram           TelegramConfig `json:"telegram"`
+	CleanupInterval       time.Duration           `json:"cleanup_interval"`
+	NamespaceMaxAge       time.Duration           `json:"namespace_max_age"`
+	WarningBefore         time.Duration           `json:"warning_before"`
+	ExpiryAnnotations     bool                    `json:"expiry_annotations"`
+	StuckTerminatingAfter time.Duration           `json:"stuck_terminating_after"`
+	HelmReleaseTimeout    time.Duration           `json:"helm_release_timeout"`
+	ReleaseGC             ReleaseGCConfig         `json:"release_gc"`
+	Helm                  HelmConfig              `json:"helm"`
+	Kubernetes            KubernetesConfig        `json:"kubernetes"`
+	ExcludedNamespaces    []string                `json:"excluded_namespaces"`
+	IgnoreLabel           string                  `json:"ignore_label"`
+	LogLevel              string                  `json:"log_level"`
+	Port                  int                     `json:"port"`
+	ClusterName           string                  `json:"cluster_name"`
+	Telegram              TelegramConfig          `json:"telegram"`
+	Webhook               WebhookConfig           `json:"webhook"`
+	Slack                 SlackConfig             `json:"slack"`
+	Email                 EmailConfig             `json:"email"`
+	Alertmanager          AlertmanagerConfig      `json:"alertmanager"`
+	PagerDuty             PagerDutyConfig         `json:"pagerduty"`
+	CloudEvents           CloudEventsConfig       `json:"cloudevents"`
+	Audit                 AuditConfig             `json:"audit"`
+	KubernetesEvents      KubernetesEventsConfig  `json:"kubernetes_events"`
+	Approval              ApprovalConfig          `json:"approval"`
+	FailureEscalation     FailureEscalationConfig `json:"failure_escalation"`
+	Archive               ArchiveConfig           `json:"archive"`
+	ArgoCD                ArgoCDConfig            `json:"argocd"`
+	Flux                  FluxConfig              `json:"flux"`
 }
 
 type NamespaceGC struct {
 	config         *Config
-	clientset      *kubernetes.Clientset
+	clientset      kubernetes.Interface
 	logger         *logrus.Logger
 	helmClient     *HelmClient
 	telegramClient *TelegramClient
+	audit          *AuditLogger
+	events         *EventRecorder
+	notifier       Notifier
+	approvals      *ApprovalManager
+	failures       *FailureTracker
+	archiver       *ReleaseArchiver
+	argoCD         *ArgoCDClient
+	flux           *FluxClient
 }
 
 func main() {
@@ -58,19 +88,91 @@ func main() {
 	logger.SetLevel(level)
 
 	// Initialize Kubernetes client
-	clientset, err := initKubernetesClient()
+	restConfig, clientset, err := initKubernetesClient(&config.Kubernetes)
 	if err != nil {
 		logger.Fatalf("Failed to initialize Kubernetes client: %v", err)
 	}
 
-	// Initialize Helm client
-	helmClient, err := NewHelmClient()
+	if config.ReleaseGC.Enabled {
+		if err := config.ReleaseGC.Validate(); err != nil {
+			logger.Fatalf("Invalid release_gc configuration: %v", err)
+		}
+	}
+
+	// Initialize Helm client with the same REST config
+	helmClient, err := NewHelmClient(restConfig, &config.Helm, logger)
 	if err != nil {
 		logger.Fatalf("Failed to initialize Helm client: %v", err)
 	}
 
 	// Initialize Telegram client
 	telegramClient := NewTelegramClient(&config.Telegram, logger)
+	if config.Telegram.Enabled {
+		if err := telegramClient.LoadTemplates(); err != nil {
+			logger.Fatalf("Failed to load Telegram templates: %v", err)
+		}
+		if err := telegramClient.LoadRoutes(); err != nil {
+			logger.Fatalf("Failed to load Telegram routes: %v", err)
+		}
+	}
+
+	// Initialize notification sinks
+	notifier := NewMultiNotifier()
+	if config.Telegram.Enabled {
+		notifier.Add("telegram", telegramClient)
+	}
+	if config.Webhook.Enabled {
+		webhook, err := NewWebhookNotifier(&config.Webhook, logger)
+		if err != nil {
+			logger.Fatalf("Failed to initialize webhook notifier: %v", err)
+		}
+		notifier.Add("webhook", webhook)
+	}
+	if config.Slack.Enabled {
+		slack, err := NewSlackNotifier(&config.Slack, logger)
+		if err != nil {
+			logger.Fatalf("Failed to initialize Slack notifier: %v", err)
+		}
+		notifier.Add("slack", slack)
+	}
+	if config.Email.Enabled {
+		email, err := NewEmailNotifier(&config.Email, logger)
+		if err != nil {
+			logger.Fatalf("Failed to initialize email notifier: %v", err)
+		}
+		notifier.Add("email", email)
+	}
+	if config.Alertmanager.Enabled {
+		alertmanager, err := NewAlertmanagerNotifier(&config.Alertmanager, clientset, logger)
+		if err != nil {
+			logger.Fatalf("Failed to initialize Alertmanager notifier: %v", err)
+		}
+		notifier.Add("alertmanager", alertmanager)
+	}
+	if config.PagerDuty.Enabled {
+		pagerDuty, err := NewPagerDutyNotifier(&config.PagerDuty, clientset, logger)
+		if err != nil {
+			logger.Fatalf("Failed to initialize PagerDuty notifier: %v", err)
+		}
+		notifier.Add("pagerduty", pagerDuty)
+	}
+	if config.CloudEvents.Enabled {
+		cloudEvents, err := NewCloudEventsNotifier(&config.CloudEvents, config.ClusterName, logger)
+		if err != nil {
+			logger.Fatalf("Failed to initialize CloudEvents notifier: %v", err)
+		}
+		notifier.Add("cloudevents", cloudEvents)
+	}
+
+	// Initialize audit log
+	var audit *AuditLogger
+	if config.Audit.Enabled {
+		audit, err = NewAuditLogger(&config.Audit, logger)
+		if err != nil {
+			logger.Fatalf("Failed to initialize audit log: %v", err)
+		}
+		defer audit.Close()
+	}
 
 	// Create namespace garbage collector
 	gc := &NamespaceGC{
@@ -79,6 +181,47 @@ func main() {
 		logger:         logger,
 		helmClient:     helmClient,
 		telegramClient: telegramClient,
+		audit:          audit,
+		notifier:       notifier,
+	}
+	if config.KubernetesEvents.Enabled {
+		gc.events = NewEventRecorder(clientset, logger)
+	}
+
+	if config.FailureEscalation.Enabled {
+		gc.failures, err = NewFailureTracker(&config.FailureEscalation, clientset, logger)
+		if err != nil {
+			logger.Fatalf("Failed to initialize failure escalation: %v", err)
+		}
+	}
+
+	if config.Archive.Enabled {
+		gc.archiver, err = NewReleaseArchiver(&config.Archive)
+		if err != nil {
+			logger.Fatalf("Failed to initialize release archive: %v", err)
+		}
+	}
+
+	if config.ArgoCD.Enabled {
+		gc.argoCD, err = NewArgoCDClient(restConfig, &config.ArgoCD, logger)
+		if err != nil {
+			logger.Fatalf("Failed to initialize Argo CD client: %v", err)
+		}
+	}
+
+	if config.Flux.Enabled {
+		gc.flux, err = NewFluxClient(restConfig, clientset.Discovery(), &config.Flux, logger)
+		if err != nil {
+			logger.Fatalf("Failed to initialize Flux client: %v", err)
+		}
+	}
+
+	// Initialize approval workflow
+	if config.Approval.Enabled {
+		gc.approvals, err = NewApprovalManager(&config.Approval, clientset, telegramClient, logger, gc.handleApprovalDecision)
+		if err != nil {
+			logger.Fatalf("Failed to initialize approval workflow: %v", err)
+		}
 	}
 
 	// Setup HTTP server for health checks
@@ -87,6 +230,10 @@ func main() {
 		c.JSON(200, gin.H{"status": "healthy"})
 	})
 	router.GET("/metrics", gc.getMetrics)
+	router.GET("/failures", gc.getFailures)
+	router.GET("/archive", gc.listArchives)
+	router.GET("/archive/:namespace/:release", gc.listArchives)
+	router.GET("/archive/:namespace/:release/:id", gc.getArchive)
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", config.Port),
@@ -102,16 +249,22 @@ func main() {
 	}()
 
 	// Send startup notification
-	if gc.telegramClient != nil {
-		if err := gc.telegramClient.SendStartupMessage(); err != nil {
-			logger.Warnf("Failed to send startup notification: %v", err)
-		}
-	}
+	gc.notify(NotificationEvent{Type: EventStartup, ClusterName: config.ClusterName})
 
 	// Start cleanup routine
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if gc.approvals != nil {
+		// Load pending approvals before the first run asks for them again
+		loadCtx, loadCancel := context.WithTimeout(ctx, 30*time.Second)
+		if err := gc.approvals.Load(loadCtx); err != nil {
+			logger.Errorf("Failed to load approval state: %v", err)
+		}
+		loadCancel()
+		go gc.approvals.Run(ctx)
+	}
+
 	go gc.startCleanupRoutine(ctx)
 
 	// Wait for shutdown signal
@@ -130,6 +283,11 @@ func main() {
 		logger.Errorf("Server forced to shutdown: %v", err)
 	}
 
+	if err := notifier.Close(shutdownCtx); err != nil {
+		logger.Warnf("Failed to flush notifications: %v", err)
+	}
+	gc.events.Shutdown(shutdownCtx)
+
 	logger.Info("Server exited")
 }
 
@@ -151,35 +309,266 @@ func loadConfig() (*Config, error) {
 
 func loadConfigFromEnv() *Config {
 	return &Config{
-		CleanupInterval:    getEnvDuration("CLEANUP_INTERVAL", 24*time.Hour),
-		NamespaceMaxAge:    getEnvDuration("NAMESPACE_MAX_AGE", 7*24*time.Hour),
-		HelmReleaseTimeout: getEnvDuration("HELM_RELEASE_TIMEOUT", 5*time.Minute),
+		CleanupInterval:       getEnvDuration("CLEANUP_INTERVAL", 24*time.Hour),
+		NamespaceMaxAge:       getEnvDuration("NAMESPACE_MAX_AGE", 7*24*time.Hour),
+		WarningBefore:         getEnvDuration("WARNING_BEFORE", 0),
+		ExpiryAnnotations:     getEnvBool("EXPIRY_ANNOTATIONS", true),
+		StuckTerminatingAfter: getEnvDuration("STUCK_TERMINATING_AFTER", time.Hour),
+		HelmReleaseTimeout:    getEnvDuration("HELM_RELEASE_TIMEOUT", 5*time.Minute),
+		ReleaseGC: ReleaseGCConfig{
+			Enabled:       getEnvBool("RELEASE_GC_ENABLED", false),
+			DryRun:        getEnvBool("RELEASE_GC_DRY_RUN", false),
+			Namespaces:    getEnvStringSlice("RELEASE_GC_NAMESPACES", nil),
+			Releases:      getEnvStringSlice("RELEASE_GC_RELEASES", nil),
+			Charts:        getEnvStringSlice("RELEASE_GC_CHARTS", nil),
+			TTL:           getEnvDuration("RELEASE_GC_TTL", 0),
+			StuckStatuses: getEnvStringSlice("RELEASE_GC_STUCK_STATUSES", DefaultStuckStatuses),
+			StuckAfter:    getEnvDuration("RELEASE_GC_STUCK_AFTER", 0),
+		},
+		Helm: HelmConfig{
+			Driver:              getEnvString("HELM_DRIVER", HelmDriverSecrets),
+			SQLConnectionString: getEnvString("HELM_DRIVER_SQL_CONNECTION_STRING", ""),
+			Uninstall: HelmUninstallConfig{
+				Wait:                getEnvBool("HELM_UNINSTALL_WAIT", true),
+				KeepHistory:         getEnvBool("HELM_UNINSTALL_KEEP_HISTORY", false),
+				DisableHooks:        getEnvBool("HELM_UNINSTALL_DISABLE_HOOKS", false),
+				DeletionPropagation: getEnvString("HELM_UNINSTALL_DELETION_PROPAGATION", "background"),
+				RetryWithoutHooks:   getEnvBool("HELM_UNINSTALL_RETRY_WITHOUT_HOOKS", true),
+				KeptResources:       getEnvString("HELM_UNINSTALL_KEPT_RESOURCES", KeptResourcesLeave),
+			},
+		},
+		Kubernetes: KubernetesConfig{
+			QPS:               float32(getEnvInt("KUBERNETES_QPS", 20)),
+			Burst:             getEnvInt("KUBERNETES_BURST", 40),
+			Impersonate:       getEnvString("KUBERNETES_IMPERSONATE", ""),
+			ImpersonateGroups: getEnvStringSlice("KUBERNETES_IMPERSONATE_GROUPS", nil),
+		},
 		ExcludedNamespaces: getEnvStringSlice("EXCLUDED_NAMESPACES", []string{"kube-system", "kube-public", "kube-node-lease", "default"}),
 		IgnoreLabel:        getEnvString("IGNORE_LABEL", "kube-ns-gc.ignore"),
 		LogLevel:           getEnvString("LOG_LEVEL", "info"),
 		Port:               getEnvInt("PORT", 8080),
+		ClusterName:        getEnvString("CLUSTER_NAME", ""),
 		Telegram: TelegramConfig{
-			Enabled:   getEnvBool("TELEGRAM_ENABLED", false),
-			BotToken:  getEnvString("TELEGRAM_BOT_TOKEN", ""),
-			ChatID:    getEnvString("TELEGRAM_CHAT_ID", ""),
+			Enabled:         getEnvBool("TELEGRAM_ENABLED", false),
+			BotToken:        getEnvString("TELEGRAM_BOT_TOKEN", ""),
+			ChatID:          getEnvString("TELEGRAM_CHAT_ID", ""),
+			MessageThreadID: getEnvInt("TELEGRAM_MESSAGE_THREAD_ID", 0),
+			ErrorsRoute: TelegramDestination{
+				ChatID:          getEnvString("TELEGRAM_ERRORS_CHAT_ID", ""),
+				MessageThreadID: getEnvInt("TELEGRAM_ERRORS_MESSAGE_THREAD_ID", 0),
+			},
 			ParseMode: getEnvString("TELEGRAM_PARSE_MODE", "Markdown"),
+			APIURL:    getEnvString("TELEGRAM_API_URL", DefaultTelegramAPIURL),
+			Queue: TelegramQueueConfig{
+				Enabled:        getEnvBool("TELEGRAM_QUEUE_ENABLED", true),
+				Size:           getEnvInt("TELEGRAM_QUEUE_SIZE", 1000),
+				ChatInterval:   getEnvDuration("TELEGRAM_QUEUE_CHAT_INTERVAL", time.Second),
+				MaxRetries:     getEnvInt("TELEGRAM_QUEUE_MAX_RETRIES", 10),
+				InitialBackoff: getEnvDuration("TELEGRAM_QUEUE_INITIAL_BACKOFF", time.Second),
+				MaxBackoff:     getEnvDuration("TELEGRAM_QUEUE_MAX_BACKOFF", 5*time.Minute),
+				SpoolDir:       getEnvString("TELEGRAM_QUEUE_SPOOL_DIR", ""),
+			},
+			Digest: TelegramDigestConfig{
+				Enabled:     getEnvBool("TELEGRAM_DIGEST_ENABLED", false),
+				MaxMessages: getEnvInt("TELEGRAM_DIGEST_MAX_MESSAGES", 3),
+			},
+			Templates: TelegramTemplatesConfig{
+				Dir: getEnvString("TELEGRAM_TEMPLATES_DIR", ""),
+			},
 			Notifications: TelegramNotifications{
 				Startup:            getEnvBool("TELEGRAM_NOTIFY_STARTUP", true),
+				RunStarted:         getEnvBool("TELEGRAM_NOTIFY_RUN_STARTED", false),
+				NamespaceExpiring:  getEnvBool("TELEGRAM_NOTIFY_NAMESPACE_EXPIRING", false),
 				NamespaceDeleted:   getEnvBool("TELEGRAM_NOTIFY_NAMESPACE_DELETED", true),
 				HelmReleaseDeleted: getEnvBool("TELEGRAM_NOTIFY_HELM_RELEASE_DELETED", true),
 				CleanupSummary:     getEnvBool("TELEGRAM_NOTIFY_CLEANUP_SUMMARY", true),
 				Errors:             getEnvBool("TELEGRAM_NOTIFY_ERRORS", true),
+				Warnings:           getEnvBool("TELEGRAM_NOTIFY_WARNINGS", true),
+			},
+		},
+		Webhook: WebhookConfig{
+			Enabled: getEnvBool("WEBHOOK_ENABLED", false),
+			URL:     getEnvString("WEBHOOK_URL", ""),
+			Secret:  getEnvString("WEBHOOK_SECRET", ""),
+			Events:  getEnvStringSlice("WEBHOOK_EVENTS", nil),
+			Timeout: getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+			RetryConfig: RetryConfig{
+				QueueSize:      getEnvInt("WEBHOOK_QUEUE_SIZE", 1000),
+				MaxRetries:     getEnvInt("WEBHOOK_MAX_RETRIES", 5),
+				InitialBackoff: getEnvDuration("WEBHOOK_INITIAL_BACKOFF", time.Second),
+				MaxBackoff:     getEnvDuration("WEBHOOK_MAX_BACKOFF", 5*time.Minute),
+			},
+		},
+		Slack: SlackConfig{
+			Enabled:    getEnvBool("SLACK_ENABLED", false),
+			WebhookURL: getEnvString("SLACK_WEBHOOK_URL", ""),
+			BotToken:   getEnvString("SLACK_BOT_TOKEN", ""),
+			Channel:    getEnvString("SLACK_CHANNEL", ""),
+			APIURL:     getEnvString("SLACK_API_URL", DefaultSlackAPIURL),
+			Timeout:    getEnvDuration("SLACK_TIMEOUT", 10*time.Second),
+			Notifications: NotificationToggles{
+				Startup:            getEnvBool("SLACK_NOTIFY_STARTUP", true),
+				RunStarted:         getEnvBool("SLACK_NOTIFY_RUN_STARTED", false),
+				NamespaceExpiring:  getEnvBool("SLACK_NOTIFY_NAMESPACE_EXPIRING", false),
+				NamespaceDeleted:   getEnvBool("SLACK_NOTIFY_NAMESPACE_DELETED", true),
+				HelmReleaseDeleted: getEnvBool("SLACK_NOTIFY_HELM_RELEASE_DELETED", true),
+				CleanupSummary:     getEnvBool("SLACK_NOTIFY_CLEANUP_SUMMARY", true),
+				Errors:             getEnvBool("SLACK_NOTIFY_ERRORS", true),
+				Warnings:           getEnvBool("SLACK_NOTIFY_WARNINGS", true),
+			},
+			RetryConfig: RetryConfig{
+				QueueSize:      getEnvInt("SLACK_QUEUE_SIZE", 1000),
+				MaxRetries:     getEnvInt("SLACK_MAX_RETRIES", 5),
+				InitialBackoff: getEnvDuration("SLACK_INITIAL_BACKOFF", time.Second),
+				MaxBackoff:     getEnvDuration("SLACK_MAX_BACKOFF", 5*time.Minute),
+			},
+		},
+		Email: EmailConfig{
+			Enabled:            getEnvBool("EMAIL_ENABLED", false),
+			SMTPHost:           getEnvString("EMAIL_SMTP_HOST", ""),
+			SMTPPort:           getEnvInt("EMAIL_SMTP_PORT", 587),
+			Username:           getEnvString("EMAIL_USERNAME", ""),
+			Password:           getEnvString("EMAIL_PASSWORD", ""),
+			From:               getEnvString("EMAIL_FROM", ""),
+			StartTLS:           getEnvBool("EMAIL_STARTTLS", true),
+			OwnerAnnotation:    getEnvString("EMAIL_OWNER_ANNOTATION", DefaultOwnerAnnotation),
+			FallbackRecipients: getEnvStringSlice("EMAIL_FALLBACK_RECIPIENTS", nil),
+			NotifyExpiring:     getEnvBool("EMAIL_NOTIFY_EXPIRING", true),
+			NotifyDeleted:      getEnvBool("EMAIL_NOTIFY_DELETED", true),
+			Timeout:            getEnvDuration("EMAIL_TIMEOUT", 30*time.Second),
+			Templates: EmailTemplatesConfig{
+				Dir: getEnvString("EMAIL_TEMPLATES_DIR", ""),
+			},
+			RetryConfig: RetryConfig{
+				QueueSize:      getEnvInt("EMAIL_QUEUE_SIZE", 1000),
+				MaxRetries:     getEnvInt("EMAIL_MAX_RETRIES", 5),
+				InitialBackoff: getEnvDuration("EMAIL_INITIAL_BACKOFF", time.Second),
+				MaxBackoff:     getEnvDuration("EMAIL_MAX_BACKOFF", 5*time.Minute),
 			},
 		},
+		Alertmanager: AlertmanagerConfig{
+			Enabled:        getEnvBool("ALERTMANAGER_ENABLED", false),
+			URL:            getEnvString("ALERTMANAGER_URL", ""),
+			ResolveTimeout: getEnvDuration("ALERTMANAGER_RESOLVE_TIMEOUT", 48*time.Hour),
+			Timeout:        getEnvDuration("ALERTMANAGER_TIMEOUT", 10*time.Second),
+			RetryConfig: RetryConfig{
+				QueueSize:      getEnvInt("ALERTMANAGER_QUEUE_SIZE", 1000),
+				MaxRetries:     getEnvInt("ALERTMANAGER_MAX_RETRIES", 5),
+				InitialBackoff: getEnvDuration("ALERTMANAGER_INITIAL_BACKOFF", time.Second),
+				MaxBackoff:     getEnvDuration("ALERTMANAGER_MAX_BACKOFF", 5*time.Minute),
+			},
+			AlertStateConfig: AlertStateConfig{
+				StateConfigMap: getEnvString("ALERTMANAGER_STATE_CONFIGMAP", "kube-ns-gc-alerts"),
+				StateNamespace: getEnvString("ALERTMANAGER_STATE_NAMESPACE", ""),
+			},
+		},
+		PagerDuty: PagerDutyConfig{
+			Enabled:    getEnvBool("PAGERDUTY_ENABLED", false),
+			RoutingKey: getEnvString("PAGERDUTY_ROUTING_KEY", ""),
+			APIURL:     getEnvString("PAGERDUTY_API_URL", DefaultPagerDutyAPIURL),
+			Timeout:    getEnvDuration("PAGERDUTY_TIMEOUT", 10*time.Second),
+			RetryConfig: RetryConfig{
+				QueueSize:      getEnvInt("PAGERDUTY_QUEUE_SIZE", 1000),
+				MaxRetries:     getEnvInt("PAGERDUTY_MAX_RETRIES", 5),
+				InitialBackoff: getEnvDuration("PAGERDUTY_INITIAL_BACKOFF", time.Second),
+				MaxBackoff:     getEnvDuration("PAGERDUTY_MAX_BACKOFF", 5*time.Minute),
+			},
+			AlertStateConfig: AlertStateConfig{
+				StateConfigMap: getEnvString("PAGERDUTY_STATE_CONFIGMAP", "kube-ns-gc-alerts"),
+				StateNamespace: getEnvString("PAGERDUTY_STATE_NAMESPACE", ""),
+			},
+		},
+		KubernetesEvents: KubernetesEventsConfig{
+			Enabled: getEnvBool("KUBERNETES_EVENTS_ENABLED", true),
+		},
+		Audit: AuditConfig{
+			Enabled: getEnvBool("AUDIT_ENABLED", false),
+			Output:  getEnvString("AUDIT_OUTPUT", "stdout"),
+			Syslog: AuditSyslogConfig{
+				Enabled: getEnvBool("AUDIT_SYSLOG_ENABLED", false),
+				Network: getEnvString("AUDIT_SYSLOG_NETWORK", ""),
+				Address: getEnvString("AUDIT_SYSLOG_ADDRESS", ""),
+				Tag:     getEnvString("AUDIT_SYSLOG_TAG", "kube-ns-gc"),
+			},
+		},
+		CloudEvents: CloudEventsConfig{
+			Enabled:       getEnvBool("CLOUDEVENTS_ENABLED", false),
+			Endpoints:     cloudEventsEndpointsFromEnv(),
+			Source:        getEnvString("CLOUDEVENTS_SOURCE", ""),
+			SchemaBaseURL: getEnvString("CLOUDEVENTS_SCHEMA_BASE_URL", DefaultCloudEventsSchemaURL),
+			Timeout:       getEnvDuration("CLOUDEVENTS_TIMEOUT", 10*time.Second),
+			RetryConfig: RetryConfig{
+				QueueSize:      getEnvInt("CLOUDEVENTS_QUEUE_SIZE", 1000),
+				MaxRetries:     getEnvInt("CLOUDEVENTS_MAX_RETRIES", 5),
+				InitialBackoff: getEnvDuration("CLOUDEVENTS_INITIAL_BACKOFF", time.Second),
+				MaxBackoff:     getEnvDuration("CLOUDEVENTS_MAX_BACKOFF", 5*time.Minute),
+			},
+		},
+		Approval: ApprovalConfig{
+			Enabled:        getEnvBool("APPROVAL_ENABLED", false),
+			Selector:       getEnvString("APPROVAL_SELECTOR", ""),
+			Timeout:        getEnvDuration("APPROVAL_TIMEOUT", 24*time.Hour),
+			DefaultAction:  getEnvString("APPROVAL_DEFAULT_ACTION", ApprovalActionPostpone),
+			PostponeFor:    getEnvDuration("APPROVAL_POSTPONE_FOR", 24*time.Hour),
+			AllowedUsers:   getEnvStringSlice("APPROVAL_ALLOWED_USERS", nil),
+			StateConfigMap: getEnvString("APPROVAL_STATE_CONFIGMAP", "kube-ns-gc-approvals"),
+			StateNamespace: getEnvString("APPROVAL_STATE_NAMESPACE", ""),
+		},
+		FailureEscalation: FailureEscalationConfig{
+			Enabled:         getEnvBool("FAILURE_ESCALATION_ENABLED", false),
+			After:           getEnvInt("FAILURE_ESCALATION_AFTER", 3),
+			PurgeStorage:    getEnvBool("FAILURE_ESCALATION_PURGE_STORAGE", false),
+			DeleteNamespace: getEnvBool("FAILURE_ESCALATION_DELETE_NAMESPACE", false),
+			StateConfigMap:  getEnvString("FAILURE_ESCALATION_STATE_CONFIGMAP", "kube-ns-gc-failures"),
+			StateNamespace:  getEnvString("FAILURE_ESCALATION_STATE_NAMESPACE", ""),
+		},
+		Archive: ArchiveConfig{
+			Enabled:       getEnvBool("ARCHIVE_ENABLED", false),
+			Dir:           getEnvString("ARCHIVE_DIR", "/var/lib/kube-ns-gc/archive"),
+			RedactSecrets: getEnvBool("ARCHIVE_REDACT_SECRETS", true),
+			RedactKeys:    getEnvStringSlice("ARCHIVE_REDACT_KEYS", nil),
+		},
+		ArgoCD: ArgoCDConfig{
+			Enabled:      getEnvBool("ARGOCD_ENABLED", false),
+			Action:       getEnvString("ARGOCD_ACTION", ArgoCDActionDelete),
+			Cascade:      getEnvBool("ARGOCD_CASCADE", false),
+			Namespaces:   getEnvStringSlice("ARGOCD_NAMESPACES", nil),
+			Destinations: getEnvStringSlice("ARGOCD_DESTINATIONS", nil),
+		},
+		Flux: FluxConfig{
+			Enabled: getEnvBool("FLUX_ENABLED", false),
+			Action:  getEnvString("FLUX_ACTION", FluxActionSkip),
+		},
 	}
 }
 
-func initKubernetesClient() (*kubernetes.Clientset, error) {
-	var config *rest.Config
-	var err error
+// KubernetesConfig tunes the REST config shared by the Kubernetes and Helm clients.
+type KubernetesConfig struct {
+	QPS               float32  `json:"qps"`
+	Burst             int      `json:"burst"`
+	Impersonate       string   `json:"impersonate"`
+	ImpersonateGroups []string `json:"impersonate_groups"`
+}
 
+// initKubernetesClient builds the REST config from the in-cluster environment
+// or KUBECONFIG and returns it with a clientset using it.
+func initKubernetesClient(settings *KubernetesConfig) (*rest.Config, *kubernetes.Clientset, error) {
+	config, err := restConfig(settings)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create clientset: %v", err)
+	}
+
+	return config, clientset, nil
+}
+
+func restConfig(settings *KubernetesConfig) (*rest.Config, error) {
 	// Try in-cluster config first
-	config, err = rest.InClusterConfig()
+	config, err := rest.InClusterConfig()
 	if err != nil {
 		// Fallback to kubeconfig
 		config, err = clientcmd.BuildConfigFromFlags("", os.Getenv("KUBECONFIG"))
@@ -188,12 +577,15 @@ func initKubernetesClient() (*kubernetes.Clientset, error) {
 		}
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create clientset: %v", err)
+	config.QPS = settings.QPS
+	config.Burst = settings.Burst
+	if settings.Impersonate != "" || len(settings.ImpersonateGroups) > 0 {
+		config.Impersonate = rest.ImpersonationConfig{
+			UserName: settings.Impersonate,
+			Groups:   settings.ImpersonateGroups,
+		}
 	}
-
-	return clientset, nil
+	return config, nil
 }
 
 func (gc *NamespaceGC) startCleanupRoutine(ctx context.Context) {
@@ -217,86 +609,418 @@ func (gc *NamespaceGC) startCleanupRoutine(ctx context.Context) {
 func (gc *NamespaceGC) performCleanup() {
 	startTime := time.Now()
 	gc.logger.Info("Starting namespace cleanup")
+	report := NewCleanupReport(startTime)
+	report.RunID = startTime.UTC().Format("20060102-150405")
+	gc.notify(NotificationEvent{Type: EventRunStarted, Time: startTime, ClusterName: gc.config.ClusterName, RunID: report.RunID})
 
 	// Get all namespaces
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-	namespaces, err := gc.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	listCtx, listCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	namespaces, err := gc.clientset.CoreV1().Namespaces().List(listCtx, metav1.ListOptions{})
+	listCancel()
 	if err != nil {
 		gc.logger.Errorf("Failed to list namespaces: %v", err)
-		if gc.telegramClient != nil {
-			if err := gc.telegramClient.SendError("Failed to list namespaces", err); err != nil {
-				gc.logger.Warnf("Failed to send error notification: %v", err)
-			}
-		}
+		gc.notifyError(report.RunID, FailureRunAborted, "Failed to list namespaces", err)
 		return
 	}
 
+	// List the Helm releases of all namespaces once for the whole run, or
+	// namespace by namespace when the cluster-wide query is not allowed
+	var unlisted map[string]error
+	releases, err := gc.helmClient.ListAllReleases()
+	if err != nil {
+		gc.logger.Warnf("Failed to list Helm releases of all namespaces, listing them per namespace: %v", err)
+		releases, unlisted = gc.listReleasesPerNamespace(namespaces.Items)
+	}
+
+	// Listing the releases may take long, the next calls get timeouts of their own
+	beginCtx, beginCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	err = gc.failures.Begin(beginCtx)
+	beginCancel()
+	if err != nil {
+		gc.logger.Errorf("Failed to load failure state: %v", err)
+		gc.notifyError(report.RunID, FailureRunAborted, "Failed to load failure state", err)
+		return
+	}
+
+	// Without the Flux objects, namespaces Flux may reconcile are skipped
+	fluxCtx, fluxCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	flux, err := gc.flux.Load(fluxCtx)
+	fluxCancel()
+	if err != nil {
+		gc.logger.Errorf("Failed to list Flux objects: %v", err)
+		gc.notifyError(report.RunID, FailureFluxUnavailable, "Failed to list Flux objects, skipping namespaces Flux may reconcile", err)
+		flux = unlistedFluxObjects(err)
+	}
+
+	// Without the Argo CD Applications no namespace is collected
+	argoCtx, argoCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	argoCD, err := gc.argoCD.Load(argoCtx)
+	argoCancel()
+	if err != nil {
+		gc.logger.Errorf("Failed to list Argo CD Applications: %v", err)
+		gc.notifyError(report.RunID, FailureArgoCDUnavailable, "Failed to list Argo CD Applications, skipping namespaces", err)
+		argoCD = unlistedArgoCDApplications(err)
+	}
+
 	cutoffTime := time.Now().Add(-gc.config.NamespaceMaxAge)
-	cleanedCount := 0
+	// Namespaces whose releases were uninstalled with them in this run
+	handled := make(map[string]bool)
 
 	for _, ns := range namespaces.Items {
+		report.AddChecked(ns.Name, ns.Labels)
+
 		// Check if namespace should be excluded
 		if gc.shouldExcludeNamespace(&ns) {
 			gc.logger.Debugf("Skipping excluded namespace: %s", ns.Name)
+			gc.updateExpiryAnnotations(&ns, false)
 			continue
 		}
 
 		// Check if namespace has ignore label
 		if gc.hasIgnoreLabel(&ns) {
 			gc.logger.Debugf("Skipping namespace with ignore label: %s", ns.Name)
+			gc.updateExpiryAnnotations(&ns, false)
 			continue
 		}
 
+		// Namespaces already being deleted are only checked for getting stuck
+		if ns.DeletionTimestamp != nil {
+			since := ns.DeletionTimestamp.Time
+			if gc.config.StuckTerminatingAfter > 0 && time.Since(since) > gc.config.StuckTerminatingAfter {
+				err := fmt.Errorf("terminating since %s", since.UTC().Format(time.RFC3339))
+				gc.logger.Errorf("Namespace %s is stuck in Terminating: %v", ns.Name, err)
+				report.AddFailed(ns.Name, err)
+				gc.notifyNamespaceError(&ns, report.RunID, FailureStuckTerminating, fmt.Sprintf("Namespace %s is stuck in Terminating", ns.Name), err)
+			}
+			continue
+		}
+
+		// Namespaces Flux or Argo CD keep are neither announced nor
+		// collected. When their owner is unknown in this run, the expiry
+		// annotations are left as they are.
+		owned := gc.ownedElsewhere(&ns, releases[ns.Name], flux, argoCD)
+		if !isUnknownOwner(owned) {
+			gc.updateExpiryAnnotations(&ns, owned == nil)
+		}
+
 		// Check if namespace is old enough
 		if ns.CreationTimestamp.Time.After(cutoffTime) {
 			gc.logger.Debugf("Namespace %s is not old enough (created: %s)", ns.Name, ns.CreationTimestamp.Time)
+			if owned == nil {
+				gc.warnBeforeDeletion(&ns, report.RunID)
+			}
 			continue
 		}
 
-		// Clean up Helm releases first
-		if err := gc.cleanupHelmReleases(ns.Name); err != nil {
-			gc.logger.Errorf("Failed to cleanup Helm releases in namespace %s: %v", ns.Name, err)
-			if gc.telegramClient != nil {
-				if err := gc.telegramClient.SendError(fmt.Sprintf("Failed to cleanup Helm releases in namespace %s", ns.Name), err); err != nil {
-					gc.logger.Warnf("Failed to send error notification: %v", err)
-				}
-			}
+		// Check if namespace deletion was postponed
+		if until, ok := postponedUntil(&ns); ok && time.Now().Before(until) && owned == nil {
+			gc.logger.Debugf("Namespace %s is postponed until %s", ns.Name, until)
+			report.AddSkipped(ns.Name, fmt.Sprintf("postponed until %s", until.Format("2006-01-02 15:04 MST")))
+			gc.warnBeforeDeletion(&ns, report.RunID)
+			continue
+		}
+
+		if owned != nil {
+			gc.logger.Infof("Skipping namespace %s: %v", ns.Name, owned)
+			report.AddSkipped(ns.Name, owned.Error())
 			continue
 		}
 
-		// Delete namespace
-		if err := gc.deleteNamespace(ns.Name); err != nil {
-			gc.logger.Errorf("Failed to delete namespace %s: %v", ns.Name, err)
-			if gc.telegramClient != nil {
-				if err := gc.telegramClient.SendError(fmt.Sprintf("Failed to delete namespace %s", ns.Name), err); err != nil {
-					gc.logger.Warnf("Failed to send error notification: %v", err)
-				}
+		// Ask for approval if required, approved deletions are made on
+		// behalf of whoever approved them
+		actor := AuditActor{Type: AuditActorScheduler}
+		if gc.approvals != nil && gc.approvals.Required(&ns) {
+			checkCtx, checkCancel := context.WithTimeout(context.Background(), 30*time.Second)
+			approval, err := gc.approvals.Check(checkCtx, &ns)
+			checkCancel()
+			if err != nil {
+				gc.logger.Errorf("Failed to check approval for namespace %s: %v", ns.Name, err)
+				report.AddFailed(ns.Name, err)
+				gc.notifyNamespaceError(&ns, report.RunID, FailureApproval, fmt.Sprintf("Failed to request approval for namespace %s", ns.Name), err)
+				continue
+			}
+			if approval == nil {
+				gc.logger.Infof("Namespace %s is waiting for approval", ns.Name)
+				report.AddSkipped(ns.Name, "waiting for approval")
+				continue
 			}
+			actor = approval.AuditActor()
+		}
+
+		// Without its releases a namespace is not deleted, unless it is
+		// escalated and deleted anyway
+		if err := unlisted[ns.Name]; err != nil && gc.holdUnlistedNamespace(&ns, err, report.RunID) {
+			gc.logger.Errorf("Failed to list Helm releases of namespace %s: %v", ns.Name, err)
+			report.AddFailed(ns.Name, err)
+			gc.notifyNamespaceError(&ns, report.RunID, FailureNamespaceCleanup, fmt.Sprintf("Failed to list Helm releases of namespace %s", ns.Name), err)
 			continue
 		}
 
-		// Send notification about deleted namespace
-		namespaceAge := time.Since(ns.CreationTimestamp.Time)
-		if gc.telegramClient != nil {
-			if err := gc.telegramClient.SendNamespaceDeleted(ns.Name, namespaceAge); err != nil {
-				gc.logger.Warnf("Failed to send namespace deletion notification: %v", err)
+		handled[ns.Name] = true
+		deleted, err := gc.cleanupNamespace(&ns, releases[ns.Name], flux, argoCD, report.RunID, actor)
+		if err != nil {
+			gc.logger.Errorf("Failed to clean up namespace %s: %v", ns.Name, err)
+			report.AddFailed(ns.Name, err)
+			gc.notifyNamespaceError(&ns, report.RunID, FailureNamespaceCleanup, fmt.Sprintf("Failed to clean up namespace %s", ns.Name), err)
+			continue
+		}
+		report.AddDeleted(*deleted)
+
+		if gc.approvals != nil {
+			completeCtx, completeCancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err := gc.approvals.Complete(completeCtx, string(ns.UID))
+			completeCancel()
+			if err != nil {
+				gc.logger.Warnf("Failed to complete approval for namespace %s: %v", ns.Name, err)
 			}
 		}
+	}
+
+	if gc.config.ReleaseGC.Enabled {
+		gc.collectReleases(namespaces.Items, releases, flux, handled, report.RunID)
+	}
 
-		cleanedCount++
-		gc.logger.Infof("Successfully cleaned up namespace: %s", ns.Name)
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := gc.failures.End(saveCtx); err != nil {
+		gc.logger.Errorf("Failed to save failure state: %v", err)
 	}
+	saveCancel()
 
-	duration := time.Since(startTime)
-	gc.logger.Infof("Cleanup completed. Cleaned %d namespaces", cleanedCount)
+	report.Duration = time.Since(startTime)
+	gc.logger.Infof("Cleanup completed. Cleaned %d namespaces", len(report.Deleted))
 
 	// Send cleanup summary
-	if gc.telegramClient != nil {
-		if err := gc.telegramClient.SendCleanupSummary(len(namespaces.Items), cleanedCount, duration); err != nil {
-			gc.logger.Warnf("Failed to send cleanup summary: %v", err)
+	gc.events.Pod(v1.EventTypeNormal, ReasonCleanupCompleted, "%s", runSummaryMessage(report))
+	gc.notify(NotificationEvent{
+		Type:              EventCleanupSummary,
+		ClusterName:       gc.config.ClusterName,
+		RunID:             report.RunID,
+		TotalNamespaces:   report.TotalNamespaces,
+		DeletedNamespaces: len(report.Deleted),
+		Duration:          report.Duration,
+		Report:            report,
+	})
+}
+
+// listReleasesPerNamespace lists the Helm releases of every namespace that is
+// not being deleted, one query per namespace, and returns the errors of the
+// namespaces whose releases could not be listed.
+func (gc *NamespaceGC) listReleasesPerNamespace(namespaces []v1.Namespace) (map[string][]HelmRelease, map[string]error) {
+	releases := make(map[string][]HelmRelease)
+	unlisted := make(map[string]error)
+	for _, ns := range namespaces {
+		if ns.DeletionTimestamp != nil {
+			continue
+		}
+		nsReleases, err := gc.helmClient.ListReleases(ns.Name)
+		if err != nil {
+			unlisted[ns.Name] = err
+			continue
+		}
+		releases[ns.Name] = nsReleases
+	}
+	return releases, unlisted
+}
+
+// cleanupNamespace uninstalls the Helm releases in a namespace and deletes it.
+// runID identifies the cleanup run in notifications.
+func (gc *NamespaceGC) cleanupNamespace(ns *v1.Namespace, releases []HelmRelease, flux *FluxObjects, argoCD *ArgoCDApplications, runID string, actor AuditActor) (*DeletedNamespace, error) {
+	// Flux objects are deleted instead of uninstalling their releases
+	fluxOwners, err := gc.fluxOwners(ns, releases, flux)
+	if err != nil {
+		return nil, err
+	}
+	releases = gc.withoutFluxReleases(releases, flux)
+
+	// Kept resources that block an uninstall keep the whole namespace
+	for i := range releases {
+		if err := gc.keptResourcesBlock(&releases[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	// So do Argo CD Applications that would recreate it
+	apps, err := gc.argoCDApplications(ns, argoCD)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stop Argo CD and Flux from syncing the releases back while they are
+	// uninstalled
+	if err := gc.handleArgoCDApplications(ns, apps, runID, actor); err != nil {
+		return nil, err
+	}
+	if err := gc.deleteFluxOwners(ns, fluxOwners, runID, actor); err != nil {
+		return nil, err
+	}
+
+	// Then clean up Helm releases
+	uninstalled, failures := gc.cleanupHelmReleases(ns, releases, runID, actor)
+	if err := gc.holdNamespace(ns, failures, runID); err != nil {
+		return nil, err
+	}
+
+	// Delete namespace
+	gc.events.Namespace(ns, v1.EventTypeNormal, ReasonDeleting, "Deleting namespace under policy %s (%s)", PolicyNamespaceMaxAge, gc.policyRule())
+	err = gc.deleteNamespace(ns.Name)
+	gc.auditNamespace(AuditActionNamespaceDelete, ns, runID, actor, err)
+	if err != nil {
+		if record, due := gc.failures.Fail(ns.Name, "", err); due {
+			gc.escalate(ns, nil, record, runID, PolicyNamespaceMaxAge)
+		}
+		return nil, err
+	}
+	gc.failures.Succeed(ns.Name, "")
+	gc.events.Pod(v1.EventTypeNormal, ReasonNamespaceDeleted, "Deleted namespace %s under policy %s (%s)", ns.Name, PolicyNamespaceMaxAge, gc.policyRule())
+
+	// Send notification about deleted namespace
+	event := gc.namespaceEvent(EventNamespaceDeleted, ns, runID)
+	event.Releases = uninstalled
+	gc.notify(event)
+
+	gc.logger.Infof("Successfully cleaned up namespace: %s", ns.Name)
+	return &DeletedNamespace{
+		Name:     ns.Name,
+		Age:      event.Age,
+		Releases: uninstalled,
+	}, nil
+}
+
+// handleApprovalDecision applies a postpone or protect decision made in Telegram by actor,
+// or by "timeout" when nobody decided in time. Approvals are only audited, the
+// next cleanup run deletes the namespace.
+func (gc *NamespaceGC) handleApprovalDecision(approval *PendingApproval, action, actor string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	ns, err := gc.clientset.CoreV1().Namespaces().Get(ctx, approval.Namespace, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return &PermanentDecisionError{Err: fmt.Errorf("namespace %s no longer exists", approval.Namespace)}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get namespace: %v", err)
+	}
+	if string(ns.UID) != approval.ID {
+		return &PermanentDecisionError{Err: fmt.Errorf("namespace %s was recreated since the approval was requested", approval.Namespace)}
+	}
+
+	auditActor := approvalAuditActor(actor)
+
+	var patch map[string]interface{}
+	var auditAction, until string
+	switch action {
+	case ApprovalActionApprove:
+		gc.auditNamespace(AuditActionNamespaceApprove, ns, "", auditActor, nil)
+		return nil
+	case ApprovalActionPostpone:
+		auditAction = AuditActionNamespacePostpone
+		until = time.Now().Add(gc.config.Approval.PostponeFor).UTC().Format(time.RFC3339)
+		patch = map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"annotations": map[string]string{PostponedUntilAnnotation: until},
+			},
+		}
+	case ApprovalActionProtect:
+		auditAction = AuditActionNamespaceProtect
+		if gc.config.IgnoreLabel == "" {
+			return &PermanentDecisionError{Err: fmt.Errorf("ignore label is not configured")}
+		}
+		patch = map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels": map[string]string{gc.config.IgnoreLabel: "true"},
+			},
 		}
+	default:
+		return &PermanentDecisionError{Err: fmt.Errorf("unknown approval action %q", action)}
 	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal namespace patch: %v", err)
+	}
+	updated, err := gc.clientset.CoreV1().Namespaces().Patch(ctx, ns.Name, types.MergePatchType, data, metav1.PatchOptions{})
+	if err != nil {
+		notFound := errors.IsNotFound(err)
+		err = fmt.Errorf("failed to patch namespace: %v", err)
+		gc.auditNamespace(auditAction, ns, "", auditActor, err)
+		if notFound {
+			return &PermanentDecisionError{Err: err}
+		}
+		return err
+	}
+	gc.auditNamespace(auditAction, ns, "", auditActor, nil)
+	gc.updateExpiryAnnotations(updated, action == ApprovalActionPostpone)
+	if action == ApprovalActionPostpone {
+		gc.events.Namespace(ns, v1.EventTypeNormal, ReasonDeletionPostponed, "Deletion postponed until %s by %s", until, actor)
+	} else {
+		gc.events.Namespace(ns, v1.EventTypeNormal, ReasonProtected, "Protected from deletion with label %s by %s", gc.config.IgnoreLabel, actor)
+	}
+
+	gc.logger.Infof("Namespace %s: %s decision applied", ns.Name, action)
+	return nil
+}
+
+// namespaceEvent returns a notification event about ns deleted by the max age policy.
+func (gc *NamespaceGC) namespaceEvent(eventType string, ns *v1.Namespace, runID string) NotificationEvent {
+	event := namespaceEvent(eventType, ns)
+	event.ClusterName = gc.config.ClusterName
+	event.RunID = runID
+	event.Policy = PolicyNamespaceMaxAge
+	return event
+}
+
+// auditNamespace records an action on ns that was taken under the max age
+// policy. err is the failure of the action, if any.
+func (gc *NamespaceGC) auditNamespace(action string, ns *v1.Namespace, runID string, actor AuditActor, err error) {
+	gc.audit.Record(gc.auditEntry(action, ns, runID, actor, err))
+}
+
+func (gc *NamespaceGC) auditEntry(action string, ns *v1.Namespace, runID string, actor AuditActor, err error) AuditEntry {
+	entry := newAuditEntry(action, ns, actor, err)
+	entry.Cluster = gc.config.ClusterName
+	entry.RunID = runID
+	entry.Policy = PolicyNamespaceMaxAge
+	entry.Rule = gc.policyRule()
+	return entry
+}
+
+// policyRule describes the rule of the max age policy.
+func (gc *NamespaceGC) policyRule() string {
+	return "age > " + shortDuration(gc.config.NamespaceMaxAge)
+}
+
+// notify sends event to every notification sink and logs delivery failures.
+func (gc *NamespaceGC) notify(event NotificationEvent) {
+	if gc.notifier == nil {
+		return
+	}
+	if err := gc.notifier.Notify(event); err != nil {
+		gc.logger.Warnf("Failed to send %s notification: %v", event.Type, err)
+	}
+}
+
+// notifyError reports a failure that is not about a single namespace.
+func (gc *NamespaceGC) notifyError(runID, failure, message string, err error) {
+	gc.notify(NotificationEvent{
+		Type:        EventError,
+		ClusterName: gc.config.ClusterName,
+		RunID:       runID,
+		Message:     message,
+		Error:       err.Error(),
+		Failure:     failure,
+	})
+	gc.events.Failure(failure, message, err)
+}
+
+// notifyNamespaceError reports a failure to clean up ns. Failures within a run
+// are also listed in the run summary.
+func (gc *NamespaceGC) notifyNamespaceError(ns *v1.Namespace, runID, failure, message string, err error) {
+	event := gc.namespaceEvent(EventError, ns, runID)
+	event.Message = message
+	event.Error = err.Error()
+	event.Failure = failure
+	gc.notify(event)
+	gc.events.Failure(failure, message, err)
 }
 
 func (gc *NamespaceGC) shouldExcludeNamespace(ns *v1.Namespace) bool {
@@ -317,35 +1041,218 @@ func (gc *NamespaceGC) hasIgnoreLabel(ns *v1.Namespace) bool {
 	return exists
 }
 
-func (gc *NamespaceGC) cleanupHelmReleases(namespace string) error {
-	gc.logger.Debugf("Cleaning up Helm releases in namespace: %s", namespace)
+// releaseFailure is a release that could not be uninstalled.
+type releaseFailure struct {
+	release *HelmRelease
+	err     error
+}
 
-	releases, err := gc.helmClient.ListReleases(namespace)
-	if err != nil {
-		return fmt.Errorf("failed to list Helm releases: %v", err)
-	}
+// cleanupHelmReleases uninstalls the Helm releases of a namespace and returns
+// the names of the releases that were uninstalled and the failed ones.
+func (gc *NamespaceGC) cleanupHelmReleases(ns *v1.Namespace, releases []HelmRelease, runID string, actor AuditActor) ([]string, []releaseFailure) {
+	namespace := ns.Name
+	gc.logger.Debugf("Cleaning up %d Helm releases in namespace: %s", len(releases), namespace)
 
-	for _, release := range releases {
+	var uninstalled []string
+	var failures []releaseFailure
+
+	for i := range releases {
+		release := &releases[i]
 		gc.logger.Debugf("Uninstalling Helm release: %s in namespace: %s", release.Name, namespace)
 
-		if err := gc.helmClient.UninstallRelease(release.Name, namespace, gc.config.HelmReleaseTimeout); err != nil {
+		attempts, err := gc.uninstallRelease(ns, release, runID, PolicyNamespaceMaxAge)
+		entry := gc.auditEntry(AuditActionHelmUninstall, ns, runID, actor, err)
+		entry.Release = release.Name
+		entry.ReleaseInfo = release
+		entry.Attempts = attempts
+		gc.audit.Record(entry)
+
+		if err != nil {
 			gc.logger.Errorf("Failed to uninstall Helm release %s: %v", release.Name, err)
+			event := gc.releaseFailureEvent(ns, release, runID, PolicyNamespaceMaxAge, err)
+			gc.notify(event)
+			gc.events.Namespace(ns, v1.EventTypeWarning, failureEventReasons[event.Failure], "%s: %v", event.Message, err)
+			gc.events.Failure(event.Failure, event.Message+" in namespace "+namespace, err)
+			failures = append(failures, releaseFailure{release: release, err: err})
 			// Continue with other releases
 		} else {
 			gc.logger.Infof("Successfully uninstalled Helm release: %s", release.Name)
+			uninstalled = append(uninstalled, release.Name)
+			gc.failures.Succeed(namespace, release.Name)
 
 			// Send notification about deleted Helm release
-			if gc.telegramClient != nil {
-				if err := gc.telegramClient.SendHelmReleaseDeleted(release.Name, namespace); err != nil {
-					gc.logger.Warnf("Failed to send Helm release deletion notification: %v", err)
-				}
-			}
+			event := gc.namespaceEvent(EventHelmReleaseDeleted, ns, runID)
+			event.Release = release.Name
+			event.ReleaseInfo = release
+			gc.notify(event)
+			gc.events.Namespace(ns, v1.EventTypeNormal, ReasonHelmReleaseUninstalled, "Uninstalled Helm release %s", release.Name)
 		}
 	}
 
+	return uninstalled, failures
+}
+
+// releaseFailureEvent reports a release that could not be uninstalled, or
+// could not be archived before.
+func (gc *NamespaceGC) releaseFailureEvent(ns *v1.Namespace, release *HelmRelease, runID, policy string, err error) NotificationEvent {
+	event := gc.namespaceEvent(EventWarning, ns, runID)
+	event.Policy = policy
+	event.Release = release.Name
+	event.ReleaseInfo = release
+	event.Message = fmt.Sprintf("Failed to uninstall Helm release %s", release.Name)
+	event.Error = err.Error()
+	event.Failure = FailureHelmUninstall
+	if isArchiveError(err) {
+		event.Message = fmt.Sprintf("Failed to archive Helm release %s, not uninstalling it", release.Name)
+		event.Failure = FailureHelmArchive
+	}
+	return event
+}
+
+// holdNamespace decides whether ns is deleted although some of its releases
+// could not be uninstalled. Without failure escalation it always is. With it,
+// the namespace is kept until every failed release is escalated, and then
+// only if delete_namespace is set. Releases that could not be archived always
+// keep the namespace, it would take their history with it.
+func (gc *NamespaceGC) holdNamespace(ns *v1.Namespace, failures []releaseFailure, runID string) error {
+	unarchived := 0
+	for _, failure := range failures {
+		if isArchiveError(failure.err) {
+			unarchived++
+		}
+	}
+	if unarchived > 0 {
+		return fmt.Errorf("%d Helm releases could not be archived", unarchived)
+	}
+	if gc.failures == nil || len(failures) == 0 {
+		return nil
+	}
+
+	pending := 0
+	for _, failure := range failures {
+		record, due := gc.failures.Fail(ns.Name, failure.release.Name, failure.err)
+		if due {
+			gc.escalate(ns, failure.release, record, runID, PolicyNamespaceMaxAge)
+		} else if record.EscalatedAt == nil {
+			pending++
+		}
+	}
+
+	if pending > 0 || !gc.config.FailureEscalation.DeleteNamespace {
+		return fmt.Errorf("%d Helm releases could not be uninstalled", len(failures))
+	}
+	gc.logger.Warnf("Deleting namespace %s although %d Helm releases could not be uninstalled", ns.Name, len(failures))
 	return nil
 }
 
+// holdUnlistedNamespace records that the Helm releases of ns could not be
+// listed and escalates the namespace once it failed often enough. It returns
+// false when the namespace is escalated and delete_namespace is set: it is
+// then collected as if it had no releases.
+func (gc *NamespaceGC) holdUnlistedNamespace(ns *v1.Namespace, err error, runID string) bool {
+	if gc.failures == nil {
+		return true
+	}
+
+	record, due := gc.failures.Fail(ns.Name, "", err)
+	if due {
+		gc.escalate(ns, nil, record, runID, PolicyNamespaceMaxAge)
+	} else if record.EscalatedAt == nil {
+		return true
+	}
+
+	if !gc.config.FailureEscalation.DeleteNamespace {
+		return true
+	}
+	gc.logger.Warnf("Collecting namespace %s although its Helm releases could not be listed: %v", ns.Name, err)
+	return false
+}
+
+// escalate reports a namespace, or its release, that failed in record.Count
+// runs in a row, and purges the storage of the release if configured. The
+// storage of releases collected by release_gc is never purged: their
+// namespace stays, and so would their workloads, no longer tracked by Helm.
+func (gc *NamespaceGC) escalate(ns *v1.Namespace, release *HelmRelease, record FailureRecord, runID, policy string) {
+	event := gc.namespaceEvent(EventError, ns, runID)
+	event.Policy = policy
+	event.Error = record.LastError
+	event.Failure = FailureEscalated
+	event.Message = fmt.Sprintf("Namespace %s failed to clean up in %d runs in a row", ns.Name, record.Count)
+
+	purged := false
+	if release != nil {
+		event.Release = release.Name
+		event.ReleaseInfo = release
+		event.Message = fmt.Sprintf("Helm release %s in namespace %s failed to uninstall in %d runs in a row", release.Name, ns.Name, record.Count)
+
+		if gc.config.FailureEscalation.PurgeStorage && policy != PolicyReleaseGC && !record.Purged {
+			err := gc.helmClient.PurgeRelease(release.Name, ns.Name)
+			entry := gc.auditEntry(AuditActionHelmPurge, ns, runID, AuditActor{Type: AuditActorScheduler}, err)
+			entry.Policy = policy
+			entry.Release = release.Name
+			entry.ReleaseInfo = release
+			gc.audit.Record(entry)
+			if err != nil {
+				gc.logger.Errorf("Failed to purge Helm release %s: %v", release.Name, err)
+			} else {
+				purged = true
+				event.Message += ", its Helm storage was purged"
+			}
+		}
+	}
+
+	gc.logger.Warn(event.Message)
+	gc.failures.Escalated(ns.Name, event.Release, purged)
+	gc.notify(event)
+	gc.events.Namespace(ns, v1.EventTypeWarning, failureEventReasons[FailureEscalated], "%s: %s", event.Message, record.LastError)
+	gc.events.Failure(FailureEscalated, event.Message, fmt.Errorf("%s", record.LastError))
+}
+
+// uninstallRelease uninstalls release with the timeout set on ns. A failed
+// pre-delete hook that was retried without hooks is reported as a warning,
+// the outcome of the last attempt is left to the caller.
+func (gc *NamespaceGC) uninstallRelease(ns *v1.Namespace, release *HelmRelease, runID, policy string) ([]UninstallAttempt, error) {
+	if err := gc.keptResourcesBlock(release); err != nil {
+		return nil, err
+	}
+
+	// Without an archive the history would be lost with the release
+	if gc.archiver != nil {
+		if err := gc.archiveRelease(ns, release, runID, policy); err != nil {
+			return nil, &archiveError{release: release.Name, err: err}
+		}
+	}
+
+	timeout, err := releaseTimeout(ns.Annotations, release.Name, gc.config.HelmReleaseTimeout)
+	if err != nil {
+		gc.logger.Warnf("Namespace %s: %v, using %s", ns.Name, err, timeout)
+	}
+
+	attempts, err := gc.helmClient.UninstallRelease(release.Name, ns.Name, timeout)
+	for _, attempt := range attempts[:len(attempts)-1] {
+		if !attempt.HookFailed {
+			continue
+		}
+		event := gc.namespaceEvent(EventWarning, ns, runID)
+		event.Policy = policy
+		event.Release = release.Name
+		event.ReleaseInfo = release
+		event.Message = fmt.Sprintf("Pre-delete hook of Helm release %s failed, retried without hooks", release.Name)
+		event.Error = attempt.Error
+		event.Failure = FailureHelmHook
+		gc.notify(event)
+		gc.events.Namespace(ns, v1.EventTypeWarning, failureEventReasons[FailureHelmHook], "%s: %s", event.Message, attempt.Error)
+	}
+	if err == nil {
+		gc.handleKeptResources(ns, release)
+	}
+	return attempts, err
+}
+
+// namespaceDeletionPollInterval is how often deleteNamespace checks whether
+// the namespace is gone.
+var namespaceDeletionPollInterval = 10 * time.Second
+
 func (gc *NamespaceGC) deleteNamespace(name string) error {
 	gc.logger.Debugf("Deleting namespace: %s", name)
 
@@ -359,7 +1266,7 @@ func (gc *NamespaceGC) deleteNamespace(name string) error {
 
 	// Wait for namespace to be deleted
 	timeout := time.After(5 * time.Minute)
-	ticker := time.NewTicker(10 * time.Second)
+	ticker := time.NewTicker(namespaceDeletionPollInterval)
 	defer ticker.Stop()
 
 	for {
@@ -397,15 +1304,79 @@ func (gc *NamespaceGC) getMetrics(c *gin.Context) {
 		}
 	}
 
+	failingNamespaces, failingReleases, escalated := 0, 0, 0
+	for _, record := range gc.failures.Records() {
+		if record.Release == "" {
+			failingNamespaces++
+		} else {
+			failingReleases++
+		}
+		if record.EscalatedAt != nil {
+			escalated++
+		}
+	}
+
 	c.JSON(200, gin.H{
 		"total_namespaces":    len(namespaces.Items),
 		"old_namespaces":      oldNamespaces,
 		"excluded_namespaces": len(gc.config.ExcludedNamespaces),
 		"cleanup_interval":    gc.config.CleanupInterval.String(),
 		"namespace_max_age":   gc.config.NamespaceMaxAge.String(),
+		"failing_namespaces":  failingNamespaces,
+		"failing_releases":    failingReleases,
+		"escalated":           escalated,
 	})
 }
 
+// getFailures lists the namespaces and releases that keep failing.
+func (gc *NamespaceGC) getFailures(c *gin.Context) {
+	records := gc.failures.Records()
+	if records == nil {
+		records = []FailureRecord{}
+	}
+	c.JSON(200, gin.H{"failures": records})
+}
+
+// listArchives lists the archived releases, optionally of one namespace
+// (?namespace=) or of one release.
+func (gc *NamespaceGC) listArchives(c *gin.Context) {
+	if gc.archiver == nil {
+		c.JSON(404, gin.H{"error": "Release archive is disabled"})
+		return
+	}
+
+	namespace := c.Param("namespace")
+	if namespace == "" {
+		namespace = c.Query("namespace")
+	}
+	archives, err := gc.archiver.List(namespace, c.Param("release"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"archives": archives})
+}
+
+// getArchive downloads an archived release.
+func (gc *NamespaceGC) getArchive(c *gin.Context) {
+	if gc.archiver == nil {
+		c.JSON(404, gin.H{"error": "Release archive is disabled"})
+		return
+	}
+
+	namespace, release, id := c.Param("namespace"), c.Param("release"), c.Param("id")
+	path, err := gc.archiver.Path(namespace, release, strings.TrimSuffix(id, ".json"))
+	if os.IsNotExist(err) {
+		c.JSON(404, gin.H{"error": "Archive not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.FileAttachment(path, fmt.Sprintf("%s-%s-%s.json", namespace, release, strings.TrimSuffix(id, ".json")))
+}
+
 // Helper functions for environment variables
 func getEnvString(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Notification event types.
+const (
+	EventStartup            = "startup"
+	EventRunStarted         = "run_started"
+	EventNamespaceExpiring  = "namespace_expiring"
+	EventNamespaceDeleted   = "namespace_deleted"
+	EventHelmReleaseDeleted = "helm_release_deleted"
+	EventCleanupSummary     = "cleanup_summary"
+	EventError              = "error"
+	EventWarning            = "warning"
+)
+
+// Failure kinds of error and warning events. Alerting sinks key their alerts
+// on the failure kind and the namespace and release it concerns.
+const (
+	FailureRunAborted        = "run_aborted"
+	FailureApproval          = "approval"
+	FailureNamespaceCleanup  = "namespace_cleanup"
+	FailureHelmUninstall     = "helm_uninstall"
+	FailureHelmHook          = "helm_hook"
+	FailureHelmArchive       = "helm_archive"
+	FailureEscalated         = "escalated"
+	FailureStuckTerminating  = "stuck_terminating"
+	FailureFluxUnavailable   = "flux_unavailable"
+	FailureArgoCDUnavailable = "argocd_unavailable"
+)
+
+// PolicyNamespaceMaxAge is the policy that deletes namespaces older than namespace_max_age.
+const PolicyNamespaceMaxAge = "namespace_max_age"
+
+// NotificationEvent describes something worth telling people about. Fields that
+// do not apply to an event type are left empty.
+type NotificationEvent struct {
+	ID          string
+	Type        string
+	Time        time.Time
+	ClusterName string
+	RunID       string
+
+	// Namespace the event is about
+	Namespace   string
+	Labels      map[string]string
+	Annotations map[string]string
+	Age         time.Duration
+	Policy      string
+	// DryRun is set when the action was only reported, not taken
+	DryRun bool
+	// ExpiresAt is when the namespace becomes eligible for deletion
+	ExpiresAt time.Time
+
+	// Helm releases: Release for a single uninstall, Releases for a deleted namespace
+	Release  string
+	Releases []string
+	// ReleaseInfo details Release when it is known
+	ReleaseInfo *HelmRelease
+
+	// Cleanup run totals
+	TotalNamespaces   int
+	DeletedNamespaces int
+	Duration          time.Duration
+	// Report is the full run report, set on cleanup summaries
+	Report *CleanupReport
+
+	// Error details
+	Message string
+	Error   string
+	Failure string
+}
+
+// InRun reports whether the event is about a namespace handled by a cleanup
+// run, so it also shows up in the run summary.
+func (e NotificationEvent) InRun() bool {
+	switch e.Type {
+	case EventNamespaceDeleted:
+		return true
+	case EventHelmReleaseDeleted:
+		// Releases collected by the release policy keep their namespace,
+		// which the run summary does not list
+		return e.Policy != PolicyReleaseGC
+	case EventError:
+		// The summary lists failed namespaces, but not that they escalated
+		return e.RunID != "" && e.Namespace != "" && e.Failure != FailureEscalated
+	default:
+		return false
+	}
+}
+
+// NotificationToggles enables or disables each event type for a sink.
+type NotificationToggles struct {
+	Startup            bool `json:"startup"`
+	RunStarted         bool `json:"run_started"`
+	NamespaceExpiring  bool `json:"namespace_expiring"`
+	NamespaceDeleted   bool `json:"namespace_deleted"`
+	HelmReleaseDeleted bool `json:"helm_release_deleted"`
+	CleanupSummary     bool `json:"cleanup_summary"`
+	Errors             bool `json:"errors"`
+	Warnings           bool `json:"warnings"`
+}
+
+// Enabled reports whether events of eventType should be sent.
+func (t NotificationToggles) Enabled(eventType string) (bool, error) {
+	switch eventType {
+	case EventStartup:
+		return t.Startup, nil
+	case EventRunStarted:
+		return t.RunStarted, nil
+	case EventNamespaceExpiring:
+		return t.NamespaceExpiring, nil
+	case EventNamespaceDeleted:
+		return t.NamespaceDeleted, nil
+	case EventHelmReleaseDeleted:
+		return t.HelmReleaseDeleted, nil
+	case EventCleanupSummary:
+		return t.CleanupSummary, nil
+	case EventError:
+		return t.Errors, nil
+	case EventWarning:
+		return t.Warnings, nil
+	default:
+		return false, fmt.Errorf("unknown notification event type %q", eventType)
+	}
+}
+
+// namespaceEvent returns an event of eventType describing ns.
+func namespaceEvent(eventType string, ns *v1.Namespace) NotificationEvent {
+	return NotificationEvent{
+		Type:        eventType,
+		Time:        time.Now(),
+		Namespace:   ns.Name,
+		Labels:      ns.Labels,
+		Annotations: ns.Annotations,
+		Age:         time.Since(ns.CreationTimestamp.Time),
+	}
+}
+
+// Notifier delivers notification events to one destination.
+type Notifier interface {
+	Notify(event NotificationEvent) error
+	// Close flushes pending deliveries, giving up when ctx is done.
+	Close(ctx context.Context) error
+}
+
+type namedNotifier struct {
+	name     string
+	notifier Notifier
+}
+
+// MultiNotifier fans events out to every registered sink. A failing sink does
+// not keep the others from receiving the event.
+type MultiNotifier struct {
+	sinks []namedNotifier
+}
+
+func NewMultiNotifier() *MultiNotifier {
+	return &MultiNotifier{}
+}
+
+// Add registers a sink under name, which is used in error messages.
+func (m *MultiNotifier) Add(name string, notifier Notifier) {
+	m.sinks = append(m.sinks, namedNotifier{name: name, notifier: notifier})
+}
+
+// Len returns the number of registered sinks.
+func (m *MultiNotifier) Len() int {
+	return len(m.sinks)
+}
+
+func (m *MultiNotifier) Notify(event NotificationEvent) error {
+	if event.ID == "" {
+		event.ID = newEventID()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	var errs []string
+	for _, sink := range m.sinks {
+		if err := sink.notifier.Notify(event); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", sink.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send %s notification: %s", event.Type, strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (m *MultiNotifier) Close(ctx context.Context) error {
+	var errs []string
+	for _, sink := range m.sinks {
+		if err := sink.notifier.Close(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", sink.name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to flush notifications: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// newEventID returns a random identifier shared by all deliveries of an event.
+func newEventID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b[:])
+}
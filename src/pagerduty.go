@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DefaultPagerDutyAPIURL is the Events API endpoint used when no base URL is configured.
+const DefaultPagerDutyAPIURL = "https://events.pagerduty.com"
+
+// PagerDutyConfig configures the PagerDuty sink, which triggers Events v2
+// incidents for failures.
+type PagerDutyConfig struct {
+	Enabled    bool          `json:"enabled"`
+	RoutingKey string        `json:"routing_key"`
+	APIURL     string        `json:"api_url"`
+	Timeout    time.Duration `json:"timeout"`
+	RetryConfig
+	AlertStateConfig
+}
+
+// PagerDutyEvent is an event of the PagerDuty Events v2 API.
+type PagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *PagerDutyPayload `json:"payload,omitempty"`
+}
+
+type PagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     time.Time         `json:"timestamp"`
+	Component     string            `json:"component"`
+	Group         string            `json:"group,omitempty"`
+	Class         string            `json:"class"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+// PagerDutyNotifier triggers an incident per failure and resolves it after a
+// cleanup run that no longer reports the failure.
+type PagerDutyNotifier struct {
+	config  *PagerDutyConfig
+	logger  *logrus.Logger
+	client  *http.Client
+	queue   *deliveryQueue
+	tracker *alertTracker
+}
+
+func NewPagerDutyNotifier(config *PagerDutyConfig, clientset kubernetes.Interface, logger *logrus.Logger) (*PagerDutyNotifier, error) {
+	if config.RoutingKey == "" {
+		return nil, fmt.Errorf("pagerduty routing key is required")
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &PagerDutyNotifier{
+		config:  config,
+		logger:  logger,
+		client:  &http.Client{Timeout: timeout},
+		queue:   newDeliveryQueue("pagerduty", config.RetryConfig, logger),
+		tracker: newAlertTracker("pagerduty", config.AlertStateConfig, clientset, logger),
+	}, nil
+}
+
+func (p *PagerDutyNotifier) Notify(event NotificationEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	if event.Type == EventCleanupSummary {
+		resolved := p.tracker.EndRun(event.RunID, event.Report)
+		var errs []string
+		for _, key := range sortedAlertKeys(resolved) {
+			resolve := PagerDutyEvent{RoutingKey: p.config.RoutingKey, EventAction: "resolve", DedupKey: key}
+			if err := p.send(key+" resolve", resolve); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("failed to resolve incidents: %s", strings.Join(errs, "; "))
+		}
+		return nil
+	}
+
+	key := alertKey(event)
+	if key == "" {
+		return nil
+	}
+	p.tracker.Fire(key, event)
+	return p.send(key+" trigger", p.trigger(key, event))
+}
+
+func (p *PagerDutyNotifier) Close(ctx context.Context) error {
+	return p.queue.Close(ctx)
+}
+
+func (p *PagerDutyNotifier) trigger(key string, event NotificationEvent) PagerDutyEvent {
+	source := event.ClusterName
+	if source == "" {
+		source = "kube-ns-gc"
+	}
+
+	details := map[string]string{"error": event.Error}
+	if event.Namespace != "" {
+		details["namespace"] = event.Namespace
+	}
+	if event.Release != "" {
+		details["release"] = event.Release
+	}
+	if event.RunID != "" {
+		details["run_id"] = event.RunID
+	}
+
+	return PagerDutyEvent{
+		RoutingKey:  p.config.RoutingKey,
+		EventAction: "trigger",
+		DedupKey:    key,
+		Payload: &PagerDutyPayload{
+			Summary:       truncate(event.Message, 1000),
+			Source:        source,
+			Severity:      alertSeverity(event),
+			Timestamp:     event.Time.UTC(),
+			Component:     "kube-ns-gc",
+			Group:         event.Namespace,
+			Class:         event.Failure,
+			CustomDetails: details,
+		},
+	}
+}
+
+func (p *PagerDutyNotifier) send(description string, event PagerDutyEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pagerduty event: %v", err)
+	}
+
+	apiURL := p.config.APIURL
+	if apiURL == "" {
+		apiURL = DefaultPagerDutyAPIURL
+	}
+	endpoint := strings.TrimSuffix(apiURL, "/") + "/v2/enqueue"
+	return p.queue.Enqueue(description, func(ctx context.Context) error {
+		return postJSON(ctx, p.client, endpoint, body, nil, nil)
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// PolicyReleaseGC is the policy that uninstalls single Helm releases while
+// leaving their namespace in place.
+const PolicyReleaseGC = "release_gc"
+
+// DefaultStuckStatuses are the release statuses that count as stuck.
+var DefaultStuckStatuses = []string{"failed", "pending-install", "pending-upgrade"}
+
+// ReleaseGCConfig configures the release policy. A release in one of the
+// Namespaces is uninstalled when its name matches Releases or its chart is one
+// of Charts and it was last deployed more than TTL ago, or when it has been in
+// one of StuckStatuses for more than StuckAfter. Zero durations disable a rule.
+type ReleaseGCConfig struct {
+	Enabled bool `json:"enabled"`
+	// DryRun only reports the releases that would be uninstalled
+	DryRun bool `json:"dry_run"`
+	// Namespaces are glob patterns, excluded namespaces are not skipped
+	Namespaces    []string      `json:"namespaces"`
+	Releases      []string      `json:"releases"`
+	Charts        []string      `json:"charts"`
+	TTL           time.Duration `json:"ttl"`
+	StuckStatuses []string      `json:"stuck_statuses"`
+	StuckAfter    time.Duration `json:"stuck_after"`
+}
+
+// Validate checks that the policy selects namespaces and has a rule.
+func (c *ReleaseGCConfig) Validate() error {
+	if len(c.Namespaces) == 0 {
+		return fmt.Errorf("release_gc needs namespace patterns")
+	}
+	for _, pattern := range append(append([]string{}, c.Namespaces...), c.Releases...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q in release_gc: %v", pattern, err)
+		}
+	}
+	if c.TTL <= 0 && c.StuckAfter <= 0 {
+		return fmt.Errorf("release_gc needs a ttl or stuck_after")
+	}
+	if c.TTL > 0 && len(c.Releases) == 0 && len(c.Charts) == 0 {
+		return fmt.Errorf("release_gc ttl needs release patterns or chart names")
+	}
+	return nil
+}
+
+// AppliesTo reports whether the releases of namespace are collected.
+func (c *ReleaseGCConfig) AppliesTo(namespace string) bool {
+	return matchesAny(c.Namespaces, namespace)
+}
+
+// Rule returns the rule release breaks at now, or "" when it is kept.
+func (c *ReleaseGCConfig) Rule(release HelmRelease, now time.Time) string {
+	if release.LastDeployed.IsZero() {
+		return ""
+	}
+	age := now.Sub(release.LastDeployed)
+
+	if c.StuckAfter > 0 && age > c.StuckAfter && c.stuck(release.Status) {
+		return fmt.Sprintf("%s > %s", release.Status, shortDuration(c.StuckAfter))
+	}
+	if c.TTL > 0 && age > c.TTL && (matchesAny(c.Releases, release.Name) || c.chart(release.Chart)) {
+		return "last deployed > " + shortDuration(c.TTL)
+	}
+	return ""
+}
+
+func (c *ReleaseGCConfig) stuck(status string) bool {
+	statuses := c.StuckStatuses
+	if len(statuses) == 0 {
+		statuses = DefaultStuckStatuses
+	}
+	for _, stuck := range statuses {
+		if status == stuck {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ReleaseGCConfig) chart(name string) bool {
+	for _, chart := range c.Charts {
+		if name != "" && name == chart {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether name matches one of the glob patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// collectReleases applies the release policy to the namespaces that are left
+// after a run. Namespaces handled by the run are skipped, their releases were
+// already uninstalled with them, and so are releases managed by Flux.
+func (gc *NamespaceGC) collectReleases(namespaces []v1.Namespace, releases map[string][]HelmRelease, flux *FluxObjects, handled map[string]bool, runID string) {
+	policy := &gc.config.ReleaseGC
+	now := time.Now()
+
+	for i := range namespaces {
+		ns := &namespaces[i]
+		if handled[ns.Name] || ns.DeletionTimestamp != nil || gc.hasIgnoreLabel(ns) || !policy.AppliesTo(ns.Name) {
+			continue
+		}
+
+		for _, release := range gc.withoutFluxReleases(releases[ns.Name], flux) {
+			rule := policy.Rule(release, now)
+			if rule == "" {
+				continue
+			}
+			gc.collectRelease(ns, release, rule, runID)
+		}
+	}
+}
+
+// collectRelease uninstalls release, which broke rule, or reports that it
+// would in dry-run mode.
+func (gc *NamespaceGC) collectRelease(ns *v1.Namespace, release HelmRelease, rule, runID string) {
+	entry := gc.auditEntry(AuditActionHelmUninstall, ns, runID, AuditActor{Type: AuditActorScheduler}, nil)
+	entry.Release = release.Name
+	entry.ReleaseInfo = &release
+	entry.Policy = PolicyReleaseGC
+	entry.Rule = rule
+
+	event := gc.namespaceEvent(EventHelmReleaseDeleted, ns, runID)
+	event.Release = release.Name
+	event.ReleaseInfo = &release
+	event.Policy = PolicyReleaseGC
+	event.Message = rule
+
+	// A policy block is not a failure, it is neither counted nor escalated
+	if err := gc.keptResourcesBlock(&release); err != nil {
+		gc.logger.Infof("Not uninstalling Helm release %s in namespace %s (%s): %v", release.Name, ns.Name, rule, err)
+		gc.events.Namespace(ns, v1.EventTypeNormal, ReasonResourcesKept, "Not uninstalling Helm release %s under policy %s: %v", release.Name, PolicyReleaseGC, err)
+		return
+	}
+
+	if gc.config.ReleaseGC.DryRun {
+		gc.logger.Infof("Dry run: would uninstall Helm release %s in namespace %s (%s)", release.Name, ns.Name, rule)
+		entry.Outcome = AuditOutcomeDryRun
+		gc.audit.Record(entry)
+		event.DryRun = true
+		gc.notify(event)
+		return
+	}
+
+	gc.logger.Infof("Uninstalling Helm release %s in namespace %s (%s)", release.Name, ns.Name, rule)
+	attempts, err := gc.uninstallRelease(ns, &release, runID, PolicyReleaseGC)
+	entry.Attempts = attempts
+	if err != nil {
+		entry.Outcome = AuditOutcomeFailure
+		entry.Error = err.Error()
+	}
+	gc.audit.Record(entry)
+
+	if err != nil {
+		gc.logger.Errorf("Failed to uninstall Helm release %s: %v", release.Name, err)
+		warning := gc.releaseFailureEvent(ns, &release, runID, PolicyReleaseGC, err)
+		gc.notify(warning)
+		gc.events.Namespace(ns, v1.EventTypeWarning, failureEventReasons[warning.Failure], "%s: %v", warning.Message, err)
+		gc.events.Failure(warning.Failure, warning.Message+" in namespace "+ns.Name, err)
+		if isArchiveError(err) {
+			return
+		}
+		if record, due := gc.failures.Fail(ns.Name, release.Name, err); due {
+			gc.escalate(ns, &release, record, runID, PolicyReleaseGC)
+		}
+		return
+	}
+	gc.failures.Succeed(ns.Name, release.Name)
+
+	gc.notify(event)
+	gc.events.Namespace(ns, v1.EventTypeNormal, ReasonHelmReleaseUninstalled, "Uninstalled Helm release %s under policy %s (%s)", release.Name, PolicyReleaseGC, rule)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReleaseGCRule(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	policy := &ReleaseGCConfig{
+		Namespaces: []string{"dev", "team-*"},
+		Releases:   []string{"pr-*"},
+		Charts:     []string{"preview"},
+		TTL:        7 * 24 * time.Hour,
+		StuckAfter: 2 * time.Hour,
+	}
+
+	tests := []struct {
+		release  HelmRelease
+		expected string
+	}{
+		{HelmRelease{Name: "pr-42", Status: "deployed", LastDeployed: now.Add(-8 * 24 * time.Hour)}, "last deployed > 7d"},
+		{HelmRelease{Name: "pr-43", Status: "deployed", LastDeployed: now.Add(-6 * 24 * time.Hour)}, ""},
+		{HelmRelease{Name: "web", Chart: "preview", Status: "deployed", LastDeployed: now.Add(-8 * 24 * time.Hour)}, "last deployed > 7d"},
+		{HelmRelease{Name: "postgres", Chart: "postgresql", Status: "deployed", LastDeployed: now.Add(-30 * 24 * time.Hour)}, ""},
+		{HelmRelease{Name: "postgres", Status: "failed", LastDeployed: now.Add(-3 * time.Hour)}, "failed > 2h0m0s"},
+		{HelmRelease{Name: "postgres", Status: "pending-upgrade", LastDeployed: now.Add(-time.Hour)}, ""},
+		{HelmRelease{Name: "postgres", Status: "superseded", LastDeployed: now.Add(-3 * time.Hour)}, ""},
+		{HelmRelease{Name: "pr-44", Status: "failed"}, ""},
+	}
+
+	for _, test := range tests {
+		if rule := policy.Rule(test.release, now); rule != test.expected {
+			t.Errorf("Rule(%+v) = %q, expected %q", test.release, rule, test.expected)
+		}
+	}
+
+	if !policy.AppliesTo("dev") || !policy.AppliesTo("team-a") || policy.AppliesTo("kube-system") {
+		t.Errorf("Unexpected namespace matching for %v", policy.Namespaces)
+	}
+}
+
+func TestReleaseGCStuckStatuses(t *testing.T) {
+	now := time.Now()
+	policy := &ReleaseGCConfig{StuckStatuses: []string{"pending-rollback"}, StuckAfter: time.Hour}
+
+	if rule := policy.Rule(HelmRelease{Status: "failed", LastDeployed: now.Add(-2 * time.Hour)}, now); rule != "" {
+		t.Errorf("Expected failed releases to be kept, got %q", rule)
+	}
+	if rule := policy.Rule(HelmRelease{Status: "pending-rollback", LastDeployed: now.Add(-2 * time.Hour)}, now); rule != "pending-rollback > 1h0m0s" {
+		t.Errorf("Unexpected rule %q", rule)
+	}
+}
+
+func TestReleaseGCValidate(t *testing.T) {
+	tests := []struct {
+		config ReleaseGCConfig
+		valid  bool
+	}{
+		{ReleaseGCConfig{Namespaces: []string{"*"}, StuckAfter: time.Hour}, true},
+		{ReleaseGCConfig{Namespaces: []string{"dev"}, Charts: []string{"preview"}, TTL: time.Hour}, true},
+		{ReleaseGCConfig{StuckAfter: time.Hour}, false},
+		{ReleaseGCConfig{Namespaces: []string{"dev"}}, false},
+		{ReleaseGCConfig{Namespaces: []string{"dev"}, TTL: time.Hour}, false},
+		{ReleaseGCConfig{Namespaces: []string{"["}, StuckAfter: time.Hour}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.config.Validate(); (err == nil) != test.valid {
+			t.Errorf("Validate(%+v) = %v, expected valid=%v", test.config, err, test.valid)
+		}
+	}
+}
+
+func TestReleaseGCEventsAreNotInRun(t *testing.T) {
+	event := NotificationEvent{Type: EventHelmReleaseDeleted, RunID: "run-1", Namespace: "dev", Release: "pr-42", Policy: PolicyReleaseGC}
+	if event.InRun() {
+		t.Error("Expected release policy events to be sent on their own")
+	}
+	event.Policy = PolicyNamespaceMaxAge
+	if !event.InRun() {
+		t.Error("Expected releases of deleted namespaces to be reported in the run")
+	}
+}
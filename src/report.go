@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// CleanupReport collects the outcome of a single cleanup run.
+type CleanupReport struct {
+	RunID           string
+	StartedAt       time.Time
+	Duration        time.Duration
+	TotalNamespaces int
+	// Labels of every namespace checked in the run, used to split the report
+	Labels  map[string]map[string]string
+	Deleted []DeletedNamespace
+	Skipped []SkippedNamespace
+	Failed  []FailedNamespace
+}
+
+type DeletedNamespace struct {
+	Name     string
+	Age      time.Duration
+	Releases []string
+}
+
+type SkippedNamespace struct {
+	Name   string
+	Reason string
+}
+
+type FailedNamespace struct {
+	Name   string
+	Reason string
+}
+
+func NewCleanupReport(startedAt time.Time) *CleanupReport {
+	return &CleanupReport{StartedAt: startedAt, Labels: make(map[string]map[string]string)}
+}
+
+// AddChecked records a namespace that was looked at during the run.
+func (r *CleanupReport) AddChecked(name string, labels map[string]string) {
+	r.Labels[name] = labels
+	r.TotalNamespaces = len(r.Labels)
+}
+
+// Filter returns the part of the report about namespaces for which keep is true.
+func (r *CleanupReport) Filter(keep func(name string, labels map[string]string) bool) *CleanupReport {
+	filtered := &CleanupReport{
+		RunID:     r.RunID,
+		StartedAt: r.StartedAt,
+		Duration:  r.Duration,
+		Labels:    make(map[string]map[string]string),
+	}
+	for name, labels := range r.Labels {
+		if keep(name, labels) {
+			filtered.Labels[name] = labels
+		}
+	}
+	filtered.TotalNamespaces = len(filtered.Labels)
+
+	for _, deleted := range r.Deleted {
+		if keep(deleted.Name, r.Labels[deleted.Name]) {
+			filtered.Deleted = append(filtered.Deleted, deleted)
+		}
+	}
+	for _, skipped := range r.Skipped {
+		if keep(skipped.Name, r.Labels[skipped.Name]) {
+			filtered.Skipped = append(filtered.Skipped, skipped)
+		}
+	}
+	for _, failed := range r.Failed {
+		if keep(failed.Name, r.Labels[failed.Name]) {
+			filtered.Failed = append(filtered.Failed, failed)
+		}
+	}
+	return filtered
+}
+
+func (r *CleanupReport) AddDeleted(deleted DeletedNamespace) {
+	r.Deleted = append(r.Deleted, deleted)
+}
+
+func (r *CleanupReport) AddSkipped(name, reason string) {
+	r.Skipped = append(r.Skipped, SkippedNamespace{Name: name, Reason: reason})
+}
+
+func (r *CleanupReport) AddFailed(name string, err error) {
+	r.Failed = append(r.Failed, FailedNamespace{Name: name, Reason: err.Error()})
+}
+
+// formatAge renders a duration as days and hours, e.g. "8d 3h".
+func formatAge(age time.Duration) string {
+	age = age.Round(time.Hour)
+	days := age / (24 * time.Hour)
+	hours := (age % (24 * time.Hour)) / time.Hour
+
+	if days == 0 {
+		if hours == 0 {
+			return "<1h"
+		}
+		return fmt.Sprintf("%dh", hours)
+	}
+	if hours == 0 {
+		return fmt.Sprintf("%dd", days)
+	}
+	return fmt.Sprintf("%dd %dh", days, hours)
+}
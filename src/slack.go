@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultSlackAPIURL is the Web API endpoint used when no base URL is configured.
+const DefaultSlackAPIURL = "https://slack.com/api"
+
+// slackMaxReplies caps the thread replies posted under one run summary.
+const slackMaxReplies = 100
+
+// Slack rejects blocks with longer texts with invalid_blocks.
+const (
+	slackMaxSectionText = 3000
+	slackMaxFieldText   = 2000
+)
+
+// SlackConfig configures the Slack sink. With a bot token messages are posted
+// via chat.postMessage and run details go into a thread under the summary;
+// otherwise they are posted to the incoming webhook one by one.
+type SlackConfig struct {
+	Enabled       bool                `json:"enabled"`
+	WebhookURL    string              `json:"webhook_url"`
+	BotToken      string              `json:"bot_token"`
+	Channel       string              `json:"channel"`
+	APIURL        string              `json:"api_url"`
+	Timeout       time.Duration       `json:"timeout"`
+	Notifications NotificationToggles `json:"notifications"`
+	RetryConfig
+}
+
+type SlackMessage struct {
+	Channel  string       `json:"channel,omitempty"`
+	Text     string       `json:"text"`
+	Blocks   []SlackBlock `json:"blocks,omitempty"`
+	ThreadTS string       `json:"thread_ts,omitempty"`
+}
+
+// SlackBlock is a Block Kit layout block.
+type SlackBlock struct {
+	Type     string      `json:"type"`
+	Text     *SlackText  `json:"text,omitempty"`
+	Fields   []SlackText `json:"fields,omitempty"`
+	Elements []SlackText `json:"elements,omitempty"`
+}
+
+type SlackText struct {
+	Type  string `json:"type"`
+	Text  string `json:"text"`
+	Emoji bool   `json:"emoji,omitempty"`
+}
+
+type slackResponse struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error"`
+	TS    string `json:"ts"`
+}
+
+// SlackNotifier posts Block Kit messages to Slack.
+type SlackNotifier struct {
+	config *SlackConfig
+	logger *logrus.Logger
+	client *http.Client
+	queue  *deliveryQueue
+}
+
+func NewSlackNotifier(config *SlackConfig, logger *logrus.Logger) (*SlackNotifier, error) {
+	if config.BotToken != "" && config.Channel == "" {
+		return nil, fmt.Errorf("slack channel is required with a bot token")
+	}
+	if config.BotToken == "" && config.WebhookURL == "" {
+		return nil, fmt.Errorf("slack webhook url or bot token is required")
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &SlackNotifier{
+		config: config,
+		logger: logger,
+		client: &http.Client{Timeout: timeout},
+		queue:  newDeliveryQueue("slack", config.RetryConfig, logger),
+	}, nil
+}
+
+// threads reports whether run details are posted as thread replies.
+func (s *SlackNotifier) threads() bool {
+	return s.config.BotToken != ""
+}
+
+func (s *SlackNotifier) Notify(event NotificationEvent) error {
+	enabled, err := s.config.Notifications.Enabled(event.Type)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		s.logger.Debugf("Slack %s notifications are disabled", event.Type)
+		return nil
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	// Namespaces of a run are detailed in the summary thread instead, unless
+	// the summary is not posted
+	if s.threads() && event.InRun() && s.config.Notifications.CleanupSummary {
+		return nil
+	}
+
+	message := slackEventMessage(event)
+	var replies []SlackMessage
+	if s.threads() && event.Type == EventCleanupSummary && event.Report != nil {
+		replies = s.summaryReplies(event.Report)
+	}
+
+	// Retries resume after the last message that went through, so the
+	// summary is never posted twice
+	var threadTS string
+	sent := 0
+	return s.queue.Enqueue(event.Type+" message", func(ctx context.Context) error {
+		if threadTS == "" {
+			ts, err := s.post(ctx, message)
+			if err != nil {
+				return err
+			}
+			if len(replies) == 0 {
+				return nil
+			}
+			threadTS = ts
+		}
+		for ; sent < len(replies); sent++ {
+			reply := replies[sent]
+			reply.ThreadTS = threadTS
+			if _, err := s.post(ctx, reply); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *SlackNotifier) Close(ctx context.Context) error {
+	return s.queue.Close(ctx)
+}
+
+func (s *SlackNotifier) apiURL() string {
+	if s.config.APIURL == "" {
+		return DefaultSlackAPIURL
+	}
+	return strings.TrimSuffix(s.config.APIURL, "/")
+}
+
+// post sends a message and returns its timestamp, which is only known when
+// posting with a bot token.
+func (s *SlackNotifier) post(ctx context.Context, message SlackMessage) (string, error) {
+	if !s.threads() {
+		body, err := json.Marshal(message)
+		if err != nil {
+			return "", &PermanentError{Err: fmt.Errorf("failed to marshal slack message: %v", err)}
+		}
+		return "", postJSON(ctx, s.client, s.config.WebhookURL, body, nil, nil)
+	}
+
+	message.Channel = s.config.Channel
+	body, err := json.Marshal(message)
+	if err != nil {
+		return "", &PermanentError{Err: fmt.Errorf("failed to marshal slack message: %v", err)}
+	}
+
+	var response slackResponse
+	headers := map[string]string{
+		"Authorization": "Bearer " + s.config.BotToken,
+		"Content-Type":  "application/json; charset=utf-8",
+	}
+	if err := postJSON(ctx, s.client, s.apiURL()+"/chat.postMessage", body, headers, &response); err != nil {
+		return "", err
+	}
+	if !response.OK {
+		err := fmt.Errorf("slack chat.postMessage failed: %s", response.Error)
+		if response.Error == "ratelimited" {
+			return "", err
+		}
+		return "", &PermanentError{Err: err}
+	}
+	return response.TS, nil
+}
+
+// summaryReplies renders one thread reply per namespace of the run.
+func (s *SlackNotifier) summaryReplies(report *CleanupReport) []SlackMessage {
+	var replies []SlackMessage
+	add := func(text string) {
+		replies = append(replies, SlackMessage{
+			Text:   text,
+			Blocks: []SlackBlock{slackSection(text)},
+		})
+	}
+
+	if s.config.Notifications.NamespaceDeleted {
+		for _, deleted := range report.Deleted {
+			text := fmt.Sprintf("🗑️ `%s` deleted (age %s)", slackEscape(deleted.Name), formatAge(deleted.Age))
+			if len(deleted.Releases) > 0 {
+				text += " — releases: "
+				text += slackCodeListTruncate(deleted.Releases, slackMaxSectionText-len(text))
+			}
+			add(text)
+		}
+	}
+	if s.config.Notifications.Warnings {
+		for _, skipped := range report.Skipped {
+			add(fmt.Sprintf("⏭️ `%s` skipped — %s", slackEscape(skipped.Name), slackEscapeTruncate(skipped.Reason, 500)))
+		}
+	}
+	if s.config.Notifications.Errors {
+		for _, failed := range report.Failed {
+			add(fmt.Sprintf("❌ `%s` failed — %s", slackEscape(failed.Name), slackEscapeTruncate(failed.Reason, 500)))
+		}
+	}
+
+	if len(replies) > slackMaxReplies {
+		more := len(replies) - slackMaxReplies + 1
+		replies = replies[:slackMaxReplies-1]
+		add(fmt.Sprintf("… and %d more namespaces", more))
+	}
+	return replies
+}
+
+// slackEventMessage renders an event as a Block Kit message.
+func slackEventMessage(event NotificationEvent) SlackMessage {
+	var title string
+	var fields []string
+	var details []SlackBlock
+
+	switch event.Type {
+	case EventStartup:
+		title = "🚀 kube-ns-gc Started"
+		details = append(details, slackSection("Service is now monitoring namespaces for cleanup"))
+	case EventRunStarted:
+		title = "▶️ Cleanup Started"
+		details = append(details, slackSection("Cleanup run started"))
+	case EventNamespaceExpiring:
+		title = "⏳ Namespace Expiring"
+		fields = append(fields,
+			slackField("Namespace", "`"+slackEscape(event.Namespace)+"`"),
+			slackField("Deletion after", event.ExpiresAt.Format("2006-01-02 15:04 MST")),
+			slackField("Age", formatAge(event.Age)))
+	case EventNamespaceDeleted:
+		title = "🗑️ Namespace Deleted"
+		fields = append(fields,
+			slackField("Namespace", "`"+slackEscape(event.Namespace)+"`"),
+			slackField("Age", formatAge(event.Age)))
+		if event.Policy != "" {
+			fields = append(fields, slackField("Policy", slackEscape(event.Policy)))
+		}
+		if len(event.Releases) > 0 {
+			fields = append(fields, slackField("Releases", slackCodeListTruncate(event.Releases, slackMaxFieldText-len(slackField("Releases", "")))))
+		}
+	case EventHelmReleaseDeleted:
+		title = "🧹 Helm Release Deleted"
+		if event.DryRun {
+			title = "🧪 Helm Release Would Be Deleted"
+		}
+		fields = append(fields,
+			slackField("Release", "`"+slackEscape(event.Release)+"`"),
+			slackField("Namespace", "`"+slackEscape(event.Namespace)+"`"))
+		if release := event.ReleaseInfo; release != nil {
+			if release.Chart != "" {
+				fields = append(fields, slackField("Chart", "`"+slackEscape(release.ChartRef())+"`"))
+			}
+			if release.AppVersion != "" {
+				fields = append(fields, slackField("App version", slackEscape(release.AppVersion)))
+			}
+			fields = append(fields, slackField("Last deployed", release.LastDeployed.UTC().Format("2006-01-02 15:04 MST")))
+			if len(release.KeptResources) > 0 {
+				fields = append(fields, slackField("Kept resources", slackEscapeTruncate(release.KeptSummary(), slackMaxFieldText-len(slackField("Kept resources", "")))))
+			}
+		}
+		if event.Message != "" {
+			fields = append(fields, slackField("Rule", slackEscapeTruncate(event.Message, slackMaxFieldText-len(slackField("Rule", "")))))
+		}
+	case EventCleanupSummary:
+		title = "📊 Cleanup Summary"
+		fields = append(fields,
+			slackField("Namespaces checked", fmt.Sprint(event.TotalNamespaces)),
+			slackField("Namespaces deleted", fmt.Sprint(event.DeletedNamespaces)))
+		if event.Report != nil {
+			fields = append(fields,
+				slackField("Skipped", fmt.Sprint(len(event.Report.Skipped))),
+				slackField("Failed", fmt.Sprint(len(event.Report.Failed))))
+		}
+		fields = append(fields, slackField("Duration", event.Duration.Round(time.Second).String()))
+	case EventError, EventWarning:
+		title = "❌ Error"
+		if event.Type == EventWarning {
+			title = "⚠️ Warning"
+		}
+		details = append(details, slackSection(slackEscapeTruncate(event.Message, slackMaxSectionText)))
+		if event.Release != "" {
+			fields = append(fields, slackField("Release", "`"+slackEscape(event.Release)+"`"))
+		}
+		if event.Namespace != "" {
+			fields = append(fields, slackField("Namespace", "`"+slackEscape(event.Namespace)+"`"))
+		}
+		if event.Error != "" {
+			details = append(details, slackSection("```"+slackEscapeTruncate(event.Error, slackMaxSectionText-6)+"```"))
+		}
+	default:
+		title = event.Type
+	}
+
+	blocks := []SlackBlock{{Type: "header", Text: &SlackText{Type: "plain_text", Text: title, Emoji: true}}}
+	if len(fields) > 0 {
+		block := SlackBlock{Type: "section"}
+		for _, field := range fields {
+			block.Fields = append(block.Fields, SlackText{Type: "mrkdwn", Text: field})
+		}
+		blocks = append(blocks, block)
+	}
+	blocks = append(blocks, details...)
+
+	context := []string{"🕐 " + event.Time.Format("2006-01-02 15:04:05 MST")}
+	if event.ClusterName != "" {
+		context = append(context, "Cluster: "+slackEscape(event.ClusterName))
+	}
+	if event.RunID != "" {
+		context = append(context, "Run: "+slackEscape(event.RunID))
+	}
+	blocks = append(blocks, SlackBlock{
+		Type:     "context",
+		Elements: []SlackText{{Type: "mrkdwn", Text: strings.Join(context, " · ")}},
+	})
+
+	text := title
+	if event.Namespace != "" {
+		text += ": " + event.Namespace
+	}
+	return SlackMessage{Text: text, Blocks: blocks}
+}
+
+func slackSection(text string) SlackBlock {
+	return SlackBlock{Type: "section", Text: &SlackText{Type: "mrkdwn", Text: text}}
+}
+
+func slackField(label, value string) string {
+	return "*" + label + "*\n" + value
+}
+
+func slackCodeList(values []string) string {
+	quoted := make([]string, 0, len(values))
+	for _, value := range values {
+		quoted = append(quoted, "`"+slackEscape(value)+"`")
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// slackCodeListTruncate renders values like slackCodeList in at most max
+// bytes, replacing the values that do not fit with "… and N more".
+func slackCodeListTruncate(values []string, max int) string {
+	if list := slackCodeList(values); len(list) <= max {
+		return list
+	}
+
+	suffix := func(n int) string { return fmt.Sprintf("… and %d more", n) }
+	list := ""
+	for i, value := range values {
+		item := "`" + slackEscape(value) + "`"
+		if i > 0 {
+			item = ", " + item
+		}
+		if len(list)+len(item)+len(" ")+len(suffix(len(values))) > max {
+			if list == "" {
+				return suffix(len(values))
+			}
+			return list + " " + suffix(len(values)-i)
+		}
+		list += item
+	}
+	return list
+}
+
+// slackEscapeTruncate escapes s and shortens it on a character boundary so
+// that the escaped text is at most max bytes long.
+func slackEscapeTruncate(s string, max int) string {
+	n := max
+	for {
+		escaped := slackEscape(truncate(s, n))
+		if len(escaped) <= max || n == 0 {
+			return escaped
+		}
+		n -= len(escaped) - max
+		if n < 0 {
+			n = 0
+		}
+	}
+}
+
+// slackEscape escapes the characters Slack treats as control sequences in mrkdwn.
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
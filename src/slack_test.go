@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeSlackAPI records chat.postMessage calls and answers with the queued
+// error codes in order ("" for ok), then ok.
+type fakeSlackAPI struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	errors   []string
+	messages []SlackMessage
+	tokens   []string
+}
+
+func newFakeSlackAPI(t *testing.T, errors ...string) *fakeSlackAPI {
+	api := &fakeSlackAPI{errors: errors}
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat.postMessage" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var message SlackMessage
+		if err := json.Unmarshal(body, &message); err != nil {
+			t.Errorf("Invalid message: %v", err)
+		}
+
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		api.tokens = append(api.tokens, r.Header.Get("Authorization"))
+		var code string
+		if len(api.errors) > 0 {
+			code, api.errors = api.errors[0], api.errors[1:]
+		}
+		if code != "" {
+			fmt.Fprintf(w, `{"ok":false,"error":%q}`, code)
+			return
+		}
+		api.messages = append(api.messages, message)
+		fmt.Fprintf(w, `{"ok":true,"ts":"1700000000.%06d"}`, len(api.messages))
+	}))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func (a *fakeSlackAPI) received() []SlackMessage {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]SlackMessage(nil), a.messages...)
+}
+
+func allNotifications() NotificationToggles {
+	return NotificationToggles{
+		Startup:            true,
+		NamespaceDeleted:   true,
+		HelmReleaseDeleted: true,
+		CleanupSummary:     true,
+		Errors:             true,
+		Warnings:           true,
+	}
+}
+
+func newTestSlackNotifier(t *testing.T, config *SlackConfig) *SlackNotifier {
+	config.InitialBackoff = time.Millisecond
+	config.MaxBackoff = 5 * time.Millisecond
+	notifier, err := NewSlackNotifier(config, logrus.New())
+	if err != nil {
+		t.Fatalf("NewSlackNotifier failed: %v", err)
+	}
+	return notifier
+}
+
+func TestSlackThreadsRunDetails(t *testing.T) {
+	api := newFakeSlackAPI(t)
+	notifier := newTestSlackNotifier(t, &SlackConfig{
+		BotToken:      "xoxb-test",
+		Channel:       "C123",
+		APIURL:        api.server.URL,
+		Notifications: allNotifications(),
+	})
+
+	// Namespaces of the run are only reported in the summary thread
+	_ = notifier.Notify(NotificationEvent{Type: EventNamespaceDeleted, RunID: "run-1", Namespace: "preview-000"})
+	report := testReport(1)
+	if err := notifier.Notify(NotificationEvent{Type: EventCleanupSummary, RunID: "run-1", TotalNamespaces: 3, DeletedNamespaces: 1, Report: report}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	closeNotifier(t, notifier)
+
+	messages := api.received()
+	if len(messages) != 4 {
+		t.Fatalf("Expected summary and 3 replies, got %d messages", len(messages))
+	}
+	if messages[0].Channel != "C123" || messages[0].ThreadTS != "" || messages[0].Blocks[0].Type != "header" {
+		t.Errorf("Unexpected summary message: %+v", messages[0])
+	}
+	for _, reply := range messages[1:] {
+		if reply.ThreadTS != "1700000000.000001" {
+			t.Errorf("Expected reply in the summary thread, got %+v", reply)
+		}
+	}
+	if !strings.Contains(messages[1].Text, "`preview-000` deleted") || !strings.Contains(messages[3].Text, "`preview-broken` failed") {
+		t.Errorf("Unexpected replies: %q, %q", messages[1].Text, messages[3].Text)
+	}
+	if token := api.tokens[0]; token != "Bearer xoxb-test" {
+		t.Errorf("Unexpected authorization %q", token)
+	}
+}
+
+func TestSlackThreadsFollowToggles(t *testing.T) {
+	api := newFakeSlackAPI(t)
+	toggles := allNotifications()
+	toggles.CleanupSummary = false
+	toggles.Warnings = false
+	notifier := newTestSlackNotifier(t, &SlackConfig{
+		BotToken:      "xoxb-test",
+		Channel:       "C123",
+		APIURL:        api.server.URL,
+		Notifications: toggles,
+	})
+
+	// Without a summary to thread under, the namespace is posted on its own
+	if err := notifier.Notify(NotificationEvent{Type: EventNamespaceDeleted, RunID: "run-1", Namespace: "preview-000"}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	closeNotifier(t, notifier)
+	if messages := api.received(); len(messages) != 1 || messages[0].ThreadTS != "" {
+		t.Fatalf("Expected the deleted namespace to be posted directly, got %+v", messages)
+	}
+
+	notifier.config.Notifications.CleanupSummary = true
+	for _, reply := range notifier.summaryReplies(testReport(1)) {
+		if strings.Contains(reply.Text, "skipped") {
+			t.Errorf("Expected no skipped replies with warnings disabled, got %q", reply.Text)
+		}
+	}
+}
+
+func TestSlackRetryResumesThread(t *testing.T) {
+	// Rate limit the first reply only
+	api := newFakeSlackAPI(t, "", "ratelimited")
+	notifier := newTestSlackNotifier(t, &SlackConfig{
+		BotToken:      "xoxb-test",
+		Channel:       "C123",
+		APIURL:        api.server.URL,
+		Notifications: allNotifications(),
+		RetryConfig:   RetryConfig{MaxRetries: 3},
+	})
+
+	_ = notifier.Notify(NotificationEvent{Type: EventCleanupSummary, Report: testReport(1)})
+	closeNotifier(t, notifier)
+
+	messages := api.received()
+	if len(messages) != 4 {
+		t.Fatalf("Expected the summary once and 3 replies, got %d messages", len(messages))
+	}
+	for _, reply := range messages[1:] {
+		if reply.ThreadTS != "1700000000.000001" {
+			t.Errorf("Expected reply in the original thread, got %q", reply.ThreadTS)
+		}
+	}
+}
+
+func TestSlackDropsAPIErrors(t *testing.T) {
+	api := newFakeSlackAPI(t, "channel_not_found")
+	notifier := newTestSlackNotifier(t, &SlackConfig{
+		BotToken:      "xoxb-test",
+		Channel:       "C404",
+		APIURL:        api.server.URL,
+		Notifications: allNotifications(),
+		RetryConfig:   RetryConfig{MaxRetries: 5},
+	})
+
+	_ = notifier.Notify(NotificationEvent{Type: EventError, Message: "boom"})
+	closeNotifier(t, notifier)
+
+	if len(api.tokens) != 1 || len(api.received()) != 0 {
+		t.Errorf("Expected no retry after channel_not_found, got %d requests", len(api.tokens))
+	}
+}
+
+func TestSlackIncomingWebhook(t *testing.T) {
+	hook := newFakeWebhook(t)
+	toggles := allNotifications()
+	toggles.Startup = false
+	notifier := newTestSlackNotifier(t, &SlackConfig{WebhookURL: hook.server.URL, Notifications: toggles})
+
+	_ = notifier.Notify(NotificationEvent{Type: EventStartup})
+	_ = notifier.Notify(NotificationEvent{Type: EventNamespaceDeleted, RunID: "run-1", Namespace: "preview-<1>"})
+	closeNotifier(t, notifier)
+
+	requests := hook.received()
+	if len(requests) != 1 {
+		t.Fatalf("Expected only the deletion to be posted, got %d requests", len(requests))
+	}
+	var message SlackMessage
+	if err := json.Unmarshal(requests[0].Body, &message); err != nil {
+		t.Fatalf("Invalid message: %v", err)
+	}
+	if message.ThreadTS != "" || message.Channel != "" {
+		t.Errorf("Unexpected webhook message: %+v", message)
+	}
+	if field := message.Blocks[1].Fields[0].Text; field != "*Namespace*\n`preview-&lt;1&gt;`" {
+		t.Errorf("Unexpected namespace field %q", field)
+	}
+}
+
+func TestSlackRequiresDestination(t *testing.T) {
+	for _, config := range []SlackConfig{{}, {BotToken: "xoxb-test"}} {
+		if _, err := NewSlackNotifier(&config, logrus.New()); err == nil {
+			t.Errorf("Expected error for %+v", config)
+		}
+	}
+}
+
+func TestSlackSectionsFitBlockLimits(t *testing.T) {
+	message := slackEventMessage(NotificationEvent{
+		Type:      EventError,
+		Namespace: "preview-1",
+		Message:   strings.Repeat("é", 2000),
+		Error:     strings.Repeat("<hook> failed & ", 500),
+	})
+
+	for _, block := range message.Blocks {
+		if block.Type != "section" || block.Text == nil {
+			continue
+		}
+		if len(block.Text.Text) > slackMaxSectionText {
+			t.Errorf("Expected section text of at most %d bytes, got %d", slackMaxSectionText, len(block.Text.Text))
+		}
+		if !utf8.ValidString(block.Text.Text) {
+			t.Error("Expected section text to be cut on a character boundary")
+		}
+		if strings.Contains(block.Text.Text, "&am...") || strings.Contains(block.Text.Text, "&l...") {
+			t.Error("Expected escaped entities not to be cut")
+		}
+	}
+}
+
+func TestSlackReleaseListsFitBlockLimits(t *testing.T) {
+	var releases []string
+	var kept []KeptResource
+	for i := 0; i < 500; i++ {
+		releases = append(releases, fmt.Sprintf("preview-release-<%d>", i))
+		kept = append(kept, KeptResource{Kind: "PersistentVolumeClaim", Name: fmt.Sprintf("data-%d", i)})
+	}
+
+	check := func(text string, max int) {
+		t.Helper()
+		if len(text) > max {
+			t.Errorf("Expected at most %d bytes, got %d", max, len(text))
+		}
+	}
+	deleted := slackEventMessage(NotificationEvent{Type: EventNamespaceDeleted, Namespace: "preview-1", Releases: releases})
+	uninstalled := slackEventMessage(NotificationEvent{Type: EventHelmReleaseDeleted, Namespace: "preview-1", Release: "api", ReleaseInfo: &HelmRelease{Name: "api", KeptResources: kept}})
+	for _, message := range []SlackMessage{deleted, uninstalled} {
+		for _, block := range message.Blocks {
+			for _, field := range block.Fields {
+				check(field.Text, slackMaxFieldText)
+			}
+		}
+	}
+	if field := deleted.Blocks[1].Fields[2].Text; !strings.HasSuffix(field, "more") || !strings.Contains(field, "&lt;0&gt;") {
+		t.Errorf("Expected the release list to be cut with a count of the rest, got %q", field)
+	}
+
+	notifier := newTestSlackNotifier(t, &SlackConfig{WebhookURL: "http://127.0.0.1:0", Notifications: allNotifications()})
+	defer closeNotifier(t, notifier)
+	report := NewCleanupReport(time.Now())
+	report.AddDeleted(DeletedNamespace{Name: "preview-1", Releases: releases})
+	for _, reply := range notifier.summaryReplies(report) {
+		for _, block := range reply.Blocks {
+			if block.Text != nil {
+				check(block.Text.Text, slackMaxSectionText)
+			}
+		}
+	}
+}
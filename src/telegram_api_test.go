@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fakeTelegramAPI is a minimal Bot API stand-in recording every request it receives.
+type fakeTelegramAPI struct {
+	server   *httptest.Server
+	requests []fakeTelegramRequest
+	handler  func(method string, body map[string]interface{}) (int, string)
+}
+
+type fakeTelegramRequest struct {
+	Path string
+	Body map[string]interface{}
+}
+
+func newFakeTelegramAPI(t *testing.T, handler func(method string, body map[string]interface{}) (int, string)) *fakeTelegramAPI {
+	api := &fakeTelegramAPI{handler: handler}
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]interface{}
+		_ = json.Unmarshal(data, &body)
+		api.requests = append(api.requests, fakeTelegramRequest{Path: r.URL.Path, Body: body})
+
+		method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		status, response := api.handler(method, body)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func (api *fakeTelegramAPI) client() *TelegramClient {
+	return NewTelegramClient(&TelegramConfig{
+		Enabled:   true,
+		BotToken:  "test-token",
+		ChatID:    "test-chat-id",
+		ParseMode: "Markdown",
+		APIURL:    api.server.URL + "/",
+		Notifications: TelegramNotifications{
+			Startup:            true,
+			NamespaceDeleted:   true,
+			HelmReleaseDeleted: true,
+			CleanupSummary:     true,
+			Errors:             true,
+		},
+	}, logrus.New())
+}
+
+func okHandler(method string, body map[string]interface{}) (int, string) {
+	return http.StatusOK, `{"ok":true,"result":{"message_id":1}}`
+}
+
+func TestTelegramSendMessageUsesConfiguredAPIURL(t *testing.T) {
+	api := newFakeTelegramAPI(t, okHandler)
+
+	if err := api.client().SendMessage("hello"); err != nil {
+		t.Fatalf("Expected message to be sent, got %v", err)
+	}
+
+	if len(api.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(api.requests))
+	}
+
+	request := api.requests[0]
+	if request.Path != "/bottest-token/sendMessage" {
+		t.Errorf("Expected path /bottest-token/sendMessage, got %s", request.Path)
+	}
+	if request.Body["chat_id"] != "test-chat-id" || request.Body["text"] != "hello" || request.Body["parse_mode"] != "Markdown" {
+		t.Errorf("Unexpected request body: %v", request.Body)
+	}
+}
+
+func TestTelegramAPIErrorDescription(t *testing.T) {
+	api := newFakeTelegramAPI(t, func(method string, body map[string]interface{}) (int, string) {
+		return http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities"}`
+	})
+
+	err := api.client().SendMessage("*broken")
+	var apiErr *TelegramAPIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected TelegramAPIError, got %v", err)
+	}
+
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.ErrorCode != 400 {
+		t.Errorf("Unexpected status in error: %+v", apiErr)
+	}
+	if !strings.Contains(err.Error(), "can't parse entities") {
+		t.Errorf("Expected description in error message, got %q", err.Error())
+	}
+}
+
+func TestTelegramAPIErrorRetryAfter(t *testing.T) {
+	api := newFakeTelegramAPI(t, func(method string, body map[string]interface{}) (int, string) {
+		return http.StatusTooManyRequests, `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 17","parameters":{"retry_after":17}}`
+	})
+
+	err := api.client().SendMessage("hello")
+	var apiErr *TelegramAPIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected TelegramAPIError, got %v", err)
+	}
+
+	if apiErr.RetryAfter != 17*time.Second {
+		t.Errorf("Expected retry after 17s, got %s", apiErr.RetryAfter)
+	}
+}
+
+func TestTelegramAPIErrorNonJSONBody(t *testing.T) {
+	api := newFakeTelegramAPI(t, func(method string, body map[string]interface{}) (int, string) {
+		return http.StatusBadGateway, "<html>502 Bad Gateway</html>"
+	})
+
+	err := api.client().SendMessage("hello")
+	var apiErr *TelegramAPIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected TelegramAPIError, got %v", err)
+	}
+
+	if apiErr.StatusCode != http.StatusBadGateway || !strings.Contains(apiErr.Description, "502 Bad Gateway") {
+		t.Errorf("Unexpected error for non-JSON body: %+v", apiErr)
+	}
+}
+
+func TestTelegramAPIOkFalseWithStatusOK(t *testing.T) {
+	api := newFakeTelegramAPI(t, func(method string, body map[string]interface{}) (int, string) {
+		return http.StatusOK, `{"ok":false,"description":"chat not found"}`
+	})
+
+	err := api.client().SendMessage("hello")
+	if err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("Expected chat not found error, got %v", err)
+	}
+}
+
+func TestTelegramNotificationsReachServer(t *testing.T) {
+	api := newFakeTelegramAPI(t, okHandler)
+	client := api.client()
+
+	if err := client.SendStartupMessage(); err != nil {
+		t.Errorf("SendStartupMessage failed: %v", err)
+	}
+	if err := client.SendNamespaceDeleted("test-ns", time.Hour); err != nil {
+		t.Errorf("SendNamespaceDeleted failed: %v", err)
+	}
+	if err := client.SendHelmReleaseDeleted("test-release", "test-ns"); err != nil {
+		t.Errorf("SendHelmReleaseDeleted failed: %v", err)
+	}
+	if err := client.SendCleanupSummary(10, 3, time.Minute); err != nil {
+		t.Errorf("SendCleanupSummary failed: %v", err)
+	}
+	if err := client.SendError("test error", &testError{message: "boom"}); err != nil {
+		t.Errorf("SendError failed: %v", err)
+	}
+
+	if len(api.requests) != 5 {
+		t.Errorf("Expected 5 requests, got %d", len(api.requests))
+	}
+}
+
+func TestTelegramApprovalRoundTrip(t *testing.T) {
+	api := newFakeTelegramAPI(t, func(method string, body map[string]interface{}) (int, string) {
+		switch method {
+		case "sendMessage":
+			return http.StatusOK, `{"ok":true,"result":{"message_id":314}}`
+		case "getUpdates":
+			return http.StatusOK, `{"ok":true,"result":[{"update_id":9,"callback_query":{"id":"cb-1","from":{"id":5,"username":"alice"},"message":{"message_id":314},"data":"approve:uid-1"}}]}`
+		default:
+			return http.StatusOK, `{"ok":true,"result":true}`
+		}
+	})
+	client := api.client()
+	ctx := context.Background()
+
+	messageID, err := client.SendApprovalRequest(ctx, "uid-1", "preview-1", time.Hour, time.Now().Add(time.Hour), ApprovalActionPostpone, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("SendApprovalRequest failed: %v", err)
+	}
+	if messageID != 314 {
+		t.Errorf("Expected message ID 314, got %d", messageID)
+	}
+
+	markup, ok := api.requests[0].Body["reply_markup"].(map[string]interface{})
+	if !ok || markup["inline_keyboard"] == nil {
+		t.Errorf("Expected inline keyboard in approval request, got %v", api.requests[0].Body)
+	}
+
+	updates, err := client.GetUpdates(ctx, 0, time.Second)
+	if err != nil {
+		t.Fatalf("GetUpdates failed: %v", err)
+	}
+	if len(updates) != 1 || updates[0].CallbackQuery == nil || updates[0].CallbackQuery.Data != "approve:uid-1" {
+		t.Fatalf("Unexpected updates: %+v", updates)
+	}
+	if updates[0].CallbackQuery.From.DisplayName() != "@alice" {
+		t.Errorf("Expected @alice, got %s", updates[0].CallbackQuery.From.DisplayName())
+	}
+
+	if err := client.AnswerCallbackQuery(ctx, "cb-1", "ok"); err != nil {
+		t.Errorf("AnswerCallbackQuery failed: %v", err)
+	}
+	if err := client.EditMessage(ctx, 314, func(f telegramFormatter) string { return f.Text("done") }); err != nil {
+		t.Errorf("EditMessage failed: %v", err)
+	}
+
+	last := api.requests[len(api.requests)-1]
+	if !strings.HasSuffix(last.Path, "/editMessageText") || last.Body["message_id"] != float64(314) {
+		t.Errorf("Unexpected edit request: %+v", last)
+	}
+}
@@ -2,53 +2,259 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
 )
 
+// DefaultTelegramAPIURL is the public Bot API endpoint used when no base URL is configured.
+const DefaultTelegramAPIURL = "https://api.telegram.org"
+
 type TelegramConfig struct {
-	Enabled       bool                  `json:"enabled"`
-	BotToken      string                `json:"bot_token"`
-	ChatID        string                `json:"chat_id"`
-	ParseMode     string                `json:"parse_mode"`
-	Notifications TelegramNotifications `json:"notifications"`
+	Enabled         bool                    `json:"enabled"`
+	BotToken        string                  `json:"bot_token"`
+	ChatID          string                  `json:"chat_id"`
+	MessageThreadID int                     `json:"message_thread_id"`
+	Routes          []TelegramRoute         `json:"routes"`
+	ErrorsRoute     TelegramDestination     `json:"errors_route"`
+	ParseMode       string                  `json:"parse_mode"`
+	APIURL          string                  `json:"api_url"`
+	Queue           TelegramQueueConfig     `json:"queue"`
+	Digest          TelegramDigestConfig    `json:"digest"`
+	Templates       TelegramTemplatesConfig `json:"templates"`
+	Notifications   TelegramNotifications   `json:"notifications"`
 }
 
-type TelegramNotifications struct {
-	Startup            bool `json:"startup"`
-	NamespaceDeleted   bool `json:"namespace_deleted"`
-	HelmReleaseDeleted bool `json:"helm_release_deleted"`
-	CleanupSummary     bool `json:"cleanup_summary"`
-	Errors             bool `json:"errors"`
-}
+type TelegramNotifications = NotificationToggles
 
 type TelegramMessage struct {
+	ChatID          string                `json:"chat_id"`
+	MessageThreadID int                   `json:"message_thread_id,omitempty"`
+	Text            string                `json:"text"`
+	ParseMode       string                `json:"parse_mode,omitempty"`
+	ReplyMarkup     *InlineKeyboardMarkup `json:"reply_markup,omitempty"`
+
+	// plainText is resent without a parse mode if Telegram rejects the markup
+	plainText string
+}
+
+// plain returns the plain-text fallback of the message.
+func (m TelegramMessage) plain() TelegramMessage {
+	if m.plainText != "" {
+		m.Text = m.plainText
+	}
+	m.ParseMode = ParseModePlain
+	return m
+}
+
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type TelegramEditMessage struct {
 	ChatID    string `json:"chat_id"`
+	MessageID int    `json:"message_id"`
 	Text      string `json:"text"`
 	ParseMode string `json:"parse_mode,omitempty"`
 }
 
+type TelegramUser struct {
+	ID        int64  `json:"id"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// DisplayName returns the @username of the user, falling back to the full name.
+func (u *TelegramUser) DisplayName() string {
+	if u == nil {
+		return "unknown"
+	}
+	if u.Username != "" {
+		return "@" + u.Username
+	}
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	if name == "" {
+		return fmt.Sprintf("user %d", u.ID)
+	}
+	return name
+}
+
+type TelegramSentMessage struct {
+	MessageID int           `json:"message_id"`
+	Chat      *TelegramChat `json:"chat,omitempty"`
+}
+
+type TelegramChat struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+// IsConfiguredChat reports whether chat is the configured chat_id, given as
+// a numeric ID or as the @username of a channel.
+func (tc *TelegramClient) IsConfiguredChat(chat *TelegramChat) bool {
+	if chat == nil || tc.config == nil {
+		return false
+	}
+	if id, err := strconv.ParseInt(tc.config.ChatID, 10, 64); err == nil {
+		return chat.ID == id
+	}
+	return chat.Username != "" && strings.TrimPrefix(tc.config.ChatID, "@") == chat.Username
+}
+
+type TelegramCallbackQuery struct {
+	ID      string               `json:"id"`
+	From    *TelegramUser        `json:"from"`
+	Message *TelegramSentMessage `json:"message"`
+	Data    string               `json:"data"`
+}
+
+type TelegramUpdate struct {
+	UpdateID      int                    `json:"update_id"`
+	CallbackQuery *TelegramCallbackQuery `json:"callback_query"`
+}
+
+type telegramResponse struct {
+	OK          bool                        `json:"ok"`
+	Result      json.RawMessage             `json:"result"`
+	ErrorCode   int                         `json:"error_code"`
+	Description string                      `json:"description"`
+	Parameters  *telegramResponseParameters `json:"parameters"`
+}
+
+type telegramResponseParameters struct {
+	RetryAfter      int   `json:"retry_after"`
+	MigrateToChatID int64 `json:"migrate_to_chat_id"`
+}
+
+// TelegramAPIError is returned when the Bot API rejects a request.
+type TelegramAPIError struct {
+	Method      string
+	StatusCode  int
+	ErrorCode   int
+	Description string
+	RetryAfter  time.Duration
+}
+
+// Status returns the HTTP status of the answer and the retry_after Telegram asked for.
+func (e *TelegramAPIError) Status() (int, time.Duration) {
+	return e.StatusCode, e.RetryAfter
+}
+
+func (e *TelegramAPIError) Error() string {
+	msg := fmt.Sprintf("telegram %s failed with status %d", e.Method, e.StatusCode)
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	if e.RetryAfter > 0 {
+		msg += fmt.Sprintf(" (retry after %s)", e.RetryAfter)
+	}
+	return msg
+}
+
 type TelegramClient struct {
-	config *TelegramConfig
-	logger *logrus.Logger
-	client *http.Client
+	config    *TelegramConfig
+	logger    *logrus.Logger
+	client    *http.Client
+	queue     *TelegramQueue
+	templates *TelegramTemplates
+	routes    []TelegramRoute
 }
 
 func NewTelegramClient(config *TelegramConfig, logger *logrus.Logger) *TelegramClient {
-	return &TelegramClient{
+	tc := &TelegramClient{
 		config: config,
 		logger: logger,
 		client: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 	}
+
+	if config != nil && config.Enabled && config.Queue.Enabled {
+		queue, err := NewTelegramQueue(&config.Queue, logger, tc)
+		if err != nil {
+			logger.Errorf("Failed to start telegram delivery queue, sending synchronously: %v", err)
+		} else {
+			tc.queue = queue
+		}
+	}
+
+	return tc
+}
+
+// Close drains the delivery queue, giving up when ctx is done.
+func (tc *TelegramClient) Close(ctx context.Context) error {
+	if tc.queue == nil {
+		return nil
+	}
+	return tc.queue.Close(ctx)
+}
+
+// deliver sends a message to the Bot API right away.
+func (tc *TelegramClient) deliver(ctx context.Context, message TelegramMessage) error {
+	return tc.callWithFallback(ctx, "sendMessage", message, message.plain(), nil)
+}
+
+// callWithFallback calls method with formatted and, if Telegram cannot parse its
+// markup, once more with the plain-text payload.
+func (tc *TelegramClient) callWithFallback(ctx context.Context, method string, formatted, plain interface{}, result interface{}) error {
+	err := tc.callMethod(ctx, method, formatted, result)
+	if err == nil || !isParseError(err) {
+		return err
+	}
+
+	tc.logger.Warnf("Telegram rejected message markup, resending as plain text: %v", err)
+	return tc.callMethod(ctx, method, plain, result)
+}
+
+// formatter returns the formatter for the configured parse mode.
+func (tc *TelegramClient) formatter() telegramFormatter {
+	if tc.config == nil {
+		return newTelegramFormatter(ParseModePlain)
+	}
+	return newTelegramFormatter(tc.config.ParseMode)
+}
+
+// render produces the formatted text and its plain-text fallback.
+func (tc *TelegramClient) render(renderer telegramRenderer) (string, string) {
+	return renderer(tc.formatter()), renderer(newTelegramFormatter(ParseModePlain))
 }
 
+// SendMessage sends text that is already formatted for the configured parse mode.
+// If Telegram rejects its markup, the same text is resent without a parse mode.
 func (tc *TelegramClient) SendMessage(text string) error {
+	return tc.send(text, text)
+}
+
+// sendFormatted renders a message for the configured parse mode with a plain-text fallback.
+func (tc *TelegramClient) sendFormatted(destination TelegramDestination, renderer telegramRenderer) error {
+	text, plainText := tc.render(renderer)
+	return tc.sendTo(destination, text, plainText)
+}
+
+// send sends a message to the default chat.
+func (tc *TelegramClient) send(text, plainText string) error {
+	if tc.config == nil {
+		tc.logger.Warn("Telegram config is nil")
+		return nil
+	}
+	return tc.sendTo(tc.defaultDestination(), text, plainText)
+}
+
+func (tc *TelegramClient) sendTo(destination TelegramDestination, text, plainText string) error {
 	if tc.config == nil {
 		tc.logger.Warn("Telegram config is nil")
 		return nil
@@ -59,125 +265,300 @@ func (tc *TelegramClient) SendMessage(text string) error {
 		return nil
 	}
 
-	if tc.config.BotToken == "" || tc.config.ChatID == "" {
+	if tc.config.BotToken == "" || destination.ChatID == "" {
 		tc.logger.Warn("Telegram bot token or chat ID is not configured")
 		return nil
 	}
 
 	message := TelegramMessage{
-		ChatID:    tc.config.ChatID,
-		Text:      text,
-		ParseMode: tc.config.ParseMode,
+		ChatID:          destination.ChatID,
+		MessageThreadID: destination.MessageThreadID,
+		Text:            text,
+		ParseMode:       tc.formatter().ParseMode(),
+		plainText:       plainText,
+	}
+
+	if tc.queue != nil {
+		return tc.queue.Enqueue(message)
+	}
+
+	if err := tc.deliver(context.Background(), message); err != nil {
+		return err
+	}
+
+	tc.logger.Debug("Telegram message sent successfully")
+	return nil
+}
+
+// Configured reports whether the client has everything it needs to talk to the Bot API.
+func (tc *TelegramClient) Configured() bool {
+	return tc.config != nil && tc.config.Enabled && tc.config.BotToken != "" && tc.config.ChatID != ""
+}
+
+// apiURL returns the Bot API base URL without a trailing slash.
+func (tc *TelegramClient) apiURL() string {
+	if tc.config == nil || tc.config.APIURL == "" {
+		return DefaultTelegramAPIURL
 	}
+	return strings.TrimRight(tc.config.APIURL, "/")
+}
 
-	jsonData, err := json.Marshal(message)
+// callMethod invokes a Bot API method and decodes its result into result (if non-nil).
+// Failed calls are returned as *TelegramAPIError carrying Telegram's description.
+func (tc *TelegramClient) callMethod(ctx context.Context, method string, payload interface{}, result interface{}) error {
+	jsonData, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal telegram message: %v", err)
+		return fmt.Errorf("failed to marshal telegram %s request: %v", method, err)
 	}
 
-	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendMessage", tc.config.BotToken)
+	return tc.doRequest(ctx, method, "application/json", bytes.NewBuffer(jsonData), result)
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+// doRequest posts an already encoded request body to a Bot API method.
+func (tc *TelegramClient) doRequest(ctx context.Context, method, contentType string, body io.Reader, result interface{}) error {
+	url := fmt.Sprintf("%s/bot%s/%s", tc.apiURL(), tc.config.BotToken, method)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
 	if err != nil {
 		return fmt.Errorf("failed to create telegram request: %v", err)
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
 
 	resp, err := tc.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send telegram message: %v", err)
+		return fmt.Errorf("failed to call telegram %s: %v", method, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("telegram API returned status %d", resp.StatusCode)
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read telegram %s response: %v", method, err)
+	}
+
+	var apiResp telegramResponse
+	if err := json.Unmarshal(respBody, &apiResp); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			// Proxies and load balancers answer with HTML or plain text
+			return &TelegramAPIError{
+				Method:      method,
+				StatusCode:  resp.StatusCode,
+				Description: strings.TrimSpace(truncate(string(respBody), 200)),
+			}
+		}
+		return fmt.Errorf("failed to decode telegram %s response: %v", method, err)
+	}
+
+	if resp.StatusCode != http.StatusOK || !apiResp.OK {
+		apiErr := &TelegramAPIError{
+			Method:      method,
+			StatusCode:  resp.StatusCode,
+			ErrorCode:   apiResp.ErrorCode,
+			Description: apiResp.Description,
+		}
+		if apiResp.Parameters != nil && apiResp.Parameters.RetryAfter > 0 {
+			apiErr.RetryAfter = time.Duration(apiResp.Parameters.RetryAfter) * time.Second
+		}
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := json.Unmarshal(apiResp.Result, result); err != nil {
+		return fmt.Errorf("failed to decode telegram %s result: %v", method, err)
 	}
 
-	tc.logger.Debug("Telegram message sent successfully")
 	return nil
 }
 
-func (tc *TelegramClient) SendNamespaceDeleted(namespace string, age time.Duration) error {
-	if tc.config == nil || !tc.config.Notifications.NamespaceDeleted {
-		tc.logger.Debug("Namespace deletion notifications are disabled")
-		return nil
+// truncate shortens s to at most max bytes, backing off to the start of a
+// character so that the result stays valid UTF-8.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max] + "..."
+}
+
+// SendApprovalRequest posts an approval prompt with Approve / Postpone / Protect buttons
+// and returns the message ID so the prompt can be edited once a decision is made.
+func (tc *TelegramClient) SendApprovalRequest(ctx context.Context, approvalID, namespace string, age time.Duration, expiresAt time.Time, defaultAction string, postponeFor time.Duration) (int, error) {
+	if !tc.Configured() {
+		return 0, fmt.Errorf("telegram is not configured")
+	}
+
+	text, plainText := tc.render(func(f telegramFormatter) string {
+		return f.Title("⚠️", "Approval Required") + "\n\n" +
+			f.Field("📦", "Namespace", f.Code(namespace)) + "\n" +
+			f.Field("⏰", "Age", f.Text(age.Round(time.Minute).String())) + "\n" +
+			f.Field("⌛", "Expires", f.Text(fmt.Sprintf("%s (then: %s)", expiresAt.Format("2006-01-02 15:04:05 MST"), defaultAction))) + "\n" +
+			f.Field("🕐", "Time", f.Text(time.Now().Format("2006-01-02 15:04:05 MST")))
+	})
+
+	message := TelegramMessage{
+		ChatID:          tc.config.ChatID,
+		MessageThreadID: tc.config.MessageThreadID,
+		Text:            text,
+		ParseMode:       tc.formatter().ParseMode(),
+		plainText:       plainText,
+		ReplyMarkup: &InlineKeyboardMarkup{
+			InlineKeyboard: [][]InlineKeyboardButton{{
+				{Text: "✅ Approve", CallbackData: ApprovalActionApprove + ":" + approvalID},
+				{Text: "⏸️ Postpone " + shortDuration(postponeFor), CallbackData: ApprovalActionPostpone + ":" + approvalID},
+				{Text: "🛡️ Protect", CallbackData: ApprovalActionProtect + ":" + approvalID},
+			}},
+		},
 	}
 
-	text := fmt.Sprintf("🗑️ *Namespace Deleted*\n\n"+
-		"📦 Namespace: `%s`\n"+
-		"⏰ Age: %s\n"+
-		"🕐 Time: %s",
-		namespace,
-		age.Round(time.Minute),
-		time.Now().Format("2006-01-02 15:04:05 MST"))
+	var sent TelegramSentMessage
+	if err := tc.callWithFallback(ctx, "sendMessage", message, message.plain(), &sent); err != nil {
+		return 0, err
+	}
 
-	return tc.SendMessage(text)
+	return sent.MessageID, nil
 }
 
-func (tc *TelegramClient) SendHelmReleaseDeleted(releaseName, namespace string) error {
-	if tc.config == nil || !tc.config.Notifications.HelmReleaseDeleted {
-		tc.logger.Debug("Helm release deletion notifications are disabled")
-		return nil
+// EditMessage replaces the text of a previously sent message and drops its inline keyboard.
+func (tc *TelegramClient) EditMessage(ctx context.Context, messageID int, renderer telegramRenderer) error {
+	if !tc.Configured() {
+		return fmt.Errorf("telegram is not configured")
 	}
 
-	text := fmt.Sprintf("🧹 *Helm Release Deleted*\n\n"+
-		"📦 Release: `%s`\n"+
-		"🏠 Namespace: `%s`\n"+
-		"🕐 Time: %s",
-		releaseName,
-		namespace,
-		time.Now().Format("2006-01-02 15:04:05 MST"))
+	text, plainText := tc.render(renderer)
+	edit := TelegramEditMessage{
+		ChatID:    tc.config.ChatID,
+		MessageID: messageID,
+		Text:      text,
+		ParseMode: tc.formatter().ParseMode(),
+	}
+	plain := edit
+	plain.Text = plainText
+	plain.ParseMode = ParseModePlain
 
-	return tc.SendMessage(text)
+	return tc.callWithFallback(ctx, "editMessageText", edit, plain, nil)
 }
 
-func (tc *TelegramClient) SendCleanupSummary(totalNamespaces, cleanedNamespaces int, duration time.Duration) error {
-	if tc.config == nil || !tc.config.Notifications.CleanupSummary {
-		tc.logger.Debug("Cleanup summary notifications are disabled")
-		return nil
+// GetUpdates long-polls the Bot API for callback queries starting at offset.
+func (tc *TelegramClient) GetUpdates(ctx context.Context, offset int, timeout time.Duration) ([]TelegramUpdate, error) {
+	if !tc.Configured() {
+		return nil, fmt.Errorf("telegram is not configured")
 	}
 
-	text := fmt.Sprintf("📊 *Cleanup Summary*\n\n"+
-		"🔍 Total namespaces checked: %d\n"+
-		"🗑️ Namespaces deleted: %d\n"+
-		"⏱️ Cleanup duration: %s\n"+
-		"🕐 Time: %s",
-		totalNamespaces,
-		cleanedNamespaces,
-		duration.Round(time.Second),
-		time.Now().Format("2006-01-02 15:04:05 MST"))
+	payload := map[string]interface{}{
+		"offset":          offset,
+		"timeout":         int(timeout.Seconds()),
+		"allowed_updates": []string{"callback_query"},
+	}
 
-	return tc.SendMessage(text)
+	var updates []TelegramUpdate
+	if err := tc.callMethod(ctx, "getUpdates", payload, &updates); err != nil {
+		return nil, err
+	}
+
+	return updates, nil
 }
 
-func (tc *TelegramClient) SendError(message string, err error) error {
-	if tc.config == nil || !tc.config.Notifications.Errors {
-		tc.logger.Debug("Error notifications are disabled")
-		return nil
+// AnswerCallbackQuery acknowledges a button press so the Telegram client stops spinning.
+func (tc *TelegramClient) AnswerCallbackQuery(ctx context.Context, callbackID, text string) error {
+	if !tc.Configured() {
+		return fmt.Errorf("telegram is not configured")
 	}
 
-	text := fmt.Sprintf("❌ *Error*\n\n"+
-		"📝 Message: %s\n"+
-		"🔍 Error: `%s`\n"+
-		"🕐 Time: %s",
-		message,
-		err.Error(),
-		time.Now().Format("2006-01-02 15:04:05 MST"))
+	return tc.callMethod(ctx, "answerCallbackQuery", map[string]string{
+		"callback_query_id": callbackID,
+		"text":              text,
+	}, nil)
+}
 
-	return tc.SendMessage(text)
+// LoadTemplates parses and validates the configured message templates.
+func (tc *TelegramClient) LoadTemplates() error {
+	templates, err := LoadTelegramTemplates(&tc.config.Templates)
+	if err != nil {
+		return err
+	}
+	tc.templates = templates
+	return nil
 }
 
-func (tc *TelegramClient) SendStartupMessage() error {
-	if tc.config == nil || !tc.config.Notifications.Startup {
-		tc.logger.Debug("Startup notifications are disabled")
+// Notify renders event with its template and sends it to the chat its route
+// selects, honouring the notification toggles and digest mode. Summaries that
+// carry the run report are split per route.
+func (tc *TelegramClient) Notify(event NotificationEvent) error {
+	if tc.config == nil {
+		tc.logger.Debug("Telegram config is nil")
+		return nil
+	}
+	if event.Type == EventCleanupSummary && event.Report != nil {
+		return tc.SendCleanupReport(event.Report, event.ClusterName)
+	}
+	return tc.notify(tc.destination(event), event)
+}
+
+func (tc *TelegramClient) notify(destination TelegramDestination, event NotificationEvent) error {
+
+	enabled, err := tc.config.Notifications.Enabled(event.Type)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		tc.logger.Debugf("Telegram %s notifications are disabled", event.Type)
 		return nil
 	}
 
-	text := fmt.Sprintf("🚀 *kube-ns-gc Started*\n\n"+
-		"🕐 Time: %s\n"+
-		"📋 Service is now monitoring namespaces for cleanup",
-		time.Now().Format("2006-01-02 15:04:05 MST"))
+	if event.InRun() && tc.DigestEnabled() {
+		tc.logger.Debugf("Telegram %s notification is reported in the run digest", event.Type)
+		return nil
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	templates := tc.templates
+	if templates == nil {
+		var err error
+		if templates, err = builtinTelegramTemplates(); err != nil {
+			return err
+		}
+	}
+
+	text, err := templates.Render(tc.formatter(), event)
+	if err != nil {
+		return err
+	}
+	plainText, err := templates.Render(newTelegramFormatter(ParseModePlain), event)
+	if err != nil {
+		return err
+	}
+	return tc.sendTo(destination, text, plainText)
+}
+
+func (tc *TelegramClient) SendNamespaceDeleted(namespace string, age time.Duration) error {
+	return tc.Notify(NotificationEvent{Type: EventNamespaceDeleted, Namespace: namespace, Age: age, Policy: PolicyNamespaceMaxAge})
+}
 
-	return tc.SendMessage(text)
+func (tc *TelegramClient) SendHelmReleaseDeleted(releaseName, namespace string) error {
+	return tc.Notify(NotificationEvent{Type: EventHelmReleaseDeleted, Release: releaseName, Namespace: namespace})
+}
+
+func (tc *TelegramClient) SendCleanupSummary(totalNamespaces, cleanedNamespaces int, duration time.Duration) error {
+	return tc.Notify(NotificationEvent{
+		Type:              EventCleanupSummary,
+		TotalNamespaces:   totalNamespaces,
+		DeletedNamespaces: cleanedNamespaces,
+		Duration:          duration,
+	})
+}
+
+func (tc *TelegramClient) SendError(message string, err error) error {
+	return tc.Notify(NotificationEvent{Type: EventError, Message: message, Error: err.Error()})
+}
+
+func (tc *TelegramClient) SendStartupMessage() error {
+	return tc.Notify(NotificationEvent{Type: EventStartup})
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/sirupsen/logrus"
 )
@@ -196,3 +197,12 @@ type testError struct {
 func (e *testError) Error() string {
 	return e.message
 }
+
+func TestTruncateKeepsCharacters(t *testing.T) {
+	if got := truncate("удаление", 5); got != "уд..." || !utf8.ValidString(got) {
+		t.Errorf("Expected truncation at a character boundary, got %q", got)
+	}
+	if got := truncate("short", 10); got != "short" {
+		t.Errorf("Expected a short string to be kept, got %q", got)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// telegramMessageLimit is the maximum length of a Telegram message in UTF-16 code units.
+const telegramMessageLimit = 4096
+
+// digestMaxReleases caps the releases listed per namespace so a line fits into a message.
+const digestMaxReleases = 50
+
+type TelegramDigestConfig struct {
+	Enabled     bool `json:"enabled"`
+	MaxMessages int  `json:"max_messages"`
+}
+
+type TelegramDocument struct {
+	ChatID          string `json:"chat_id"`
+	MessageThreadID int    `json:"message_thread_id,omitempty"`
+	FileName        string `json:"file_name"`
+	Caption         string `json:"caption,omitempty"`
+	Content         []byte `json:"content"`
+}
+
+// DigestEnabled reports whether per-event messages are replaced by one digest per run.
+func (tc *TelegramClient) DigestEnabled() bool {
+	return tc.config != nil && tc.config.Digest.Enabled
+}
+
+// SendDigest sends one consolidated report for a cleanup run to the default chat.
+func (tc *TelegramClient) SendDigest(report *CleanupReport) error {
+	if tc.config == nil {
+		return nil
+	}
+	return tc.sendDigest(tc.defaultDestination(), report)
+}
+
+// sendDigest sends a digest to destination. Long reports are split into several
+// messages; very long ones are attached as a document instead.
+func (tc *TelegramClient) sendDigest(destination TelegramDestination, report *CleanupReport) error {
+	if !tc.config.Notifications.CleanupSummary {
+		tc.logger.Debug("Cleanup summary notifications are disabled")
+		return nil
+	}
+
+	f := tc.formatter()
+	lines := digestLines(f, report)
+	plainLines := digestLines(newTelegramFormatter(ParseModePlain), report)
+
+	// Every line has to fit into a message of its own
+	messageLines := make([]string, len(lines))
+	messagePlainLines := make([]string, len(plainLines))
+	for i := range lines {
+		messageLines[i], messagePlainLines[i] = fitLine(f, lines[i], plainLines[i], telegramMessageLimit)
+	}
+	chunks := chunkLines(messageLines, telegramMessageLimit)
+
+	maxMessages := tc.config.Digest.MaxMessages
+	if maxMessages <= 0 {
+		maxMessages = 3
+	}
+
+	if len(chunks) > maxMessages {
+		if err := tc.sendFormatted(destination, func(f telegramFormatter) string {
+			return formatDigestHeader(f, report) + "\n\n📎 " + f.Text("Full report attached")
+		}); err != nil {
+			return err
+		}
+		return tc.sendDocument(
+			destination,
+			fmt.Sprintf("kube-ns-gc-report-%s.txt", report.StartedAt.Format("20060102-150405")),
+			[]byte(strings.Join(plainLines, "\n")),
+			"📋 Cleanup report")
+	}
+
+	for _, chunk := range chunks {
+		text := strings.Join(messageLines[chunk[0]:chunk[1]], "\n")
+		plainText := strings.Join(messagePlainLines[chunk[0]:chunk[1]], "\n")
+		if err := tc.sendTo(destination, text, plainText); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendDocument uploads a file to the default chat. The caption is sent as plain text.
+func (tc *TelegramClient) SendDocument(fileName string, content []byte, caption string) error {
+	if tc.config == nil {
+		return nil
+	}
+	return tc.sendDocument(tc.defaultDestination(), fileName, content, caption)
+}
+
+func (tc *TelegramClient) sendDocument(destination TelegramDestination, fileName string, content []byte, caption string) error {
+	if !tc.Configured() {
+		tc.logger.Debug("Telegram is not configured, skipping document")
+		return nil
+	}
+
+	document := TelegramDocument{
+		ChatID:          destination.ChatID,
+		MessageThreadID: destination.MessageThreadID,
+		FileName:        fileName,
+		Caption:         caption,
+		Content:         content,
+	}
+
+	if tc.queue != nil {
+		return tc.queue.EnqueueDocument(document)
+	}
+	return tc.deliverDocument(context.Background(), document)
+}
+
+// deliverDocument uploads a document to the Bot API right away.
+func (tc *TelegramClient) deliverDocument(ctx context.Context, document TelegramDocument) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	fields := map[string]string{
+		"chat_id": document.ChatID,
+		"caption": document.Caption,
+	}
+	if document.MessageThreadID != 0 {
+		fields["message_thread_id"] = strconv.Itoa(document.MessageThreadID)
+	}
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := writer.WriteField(name, value); err != nil {
+			return fmt.Errorf("failed to build telegram document request: %v", err)
+		}
+	}
+
+	part, err := writer.CreateFormFile("document", document.FileName)
+	if err != nil {
+		return fmt.Errorf("failed to build telegram document request: %v", err)
+	}
+	if _, err := part.Write(document.Content); err != nil {
+		return fmt.Errorf("failed to build telegram document request: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("failed to build telegram document request: %v", err)
+	}
+
+	return tc.doRequest(ctx, "sendDocument", writer.FormDataContentType(), &body, nil)
+}
+
+func formatDigestHeader(f telegramFormatter, report *CleanupReport) string {
+	return f.Title("📋", "Cleanup Digest") + "\n\n" +
+		f.Field("🔍", "Namespaces checked", f.Text(fmt.Sprint(report.TotalNamespaces))) + "\n" +
+		f.Field("🗑️", "Deleted", f.Text(fmt.Sprint(len(report.Deleted)))) + "\n" +
+		f.Field("⏭️", "Skipped", f.Text(fmt.Sprint(len(report.Skipped)))) + "\n" +
+		f.Field("❌", "Failed", f.Text(fmt.Sprint(len(report.Failed)))) + "\n" +
+		f.Field("⏱️", "Cleanup duration", f.Text(report.Duration.Round(time.Second).String())) + "\n" +
+		f.Field("🕐", "Time", f.Text(report.StartedAt.Format("2006-01-02 15:04:05 MST")))
+}
+
+// digestLines renders the digest one line per entry so it can be split between
+// messages without breaking markup.
+func digestLines(f telegramFormatter, report *CleanupReport) []string {
+	lines := strings.Split(formatDigestHeader(f, report), "\n")
+
+	if len(report.Deleted) > 0 {
+		lines = append(lines, "", f.Title("🗑️", "Deleted namespaces"))
+		for _, deleted := range report.Deleted {
+			line := "• " + f.Code(deleted.Name) + f.Text(fmt.Sprintf(" (age %s)", formatAge(deleted.Age)))
+			if len(deleted.Releases) > 0 {
+				releases := make([]string, 0, len(deleted.Releases))
+				for i, release := range deleted.Releases {
+					if i == digestMaxReleases {
+						releases = append(releases, f.Text(fmt.Sprintf("and %d more", len(deleted.Releases)-i)))
+						break
+					}
+					releases = append(releases, f.Code(release))
+				}
+				line += f.Text(" — releases: ") + strings.Join(releases, f.Text(", "))
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	if len(report.Skipped) > 0 {
+		lines = append(lines, "", f.Title("⏭️", "Skipped namespaces"))
+		for _, skipped := range report.Skipped {
+			lines = append(lines, "• "+f.Code(skipped.Name)+f.Text(" — "+truncate(skipped.Reason, 500)))
+		}
+	}
+
+	if len(report.Failed) > 0 {
+		lines = append(lines, "", f.Title("❌", "Failed namespaces"))
+		for _, failed := range report.Failed {
+			lines = append(lines, "• "+f.Code(failed.Name)+f.Text(" — "+truncate(failed.Reason, 500)))
+		}
+	}
+
+	return lines
+}
+
+// chunkLines groups consecutive lines into chunks whose joined length fits into
+// limit UTF-16 code units and returns their [start, end) ranges. A line longer
+// than the limit gets a chunk of its own.
+func chunkLines(lines []string, limit int) [][2]int {
+	var chunks [][2]int
+	start, length := 0, 0
+
+	for i, line := range lines {
+		lineLen := utf16Len(line)
+		if i > start && length+1+lineLen > limit {
+			chunks = append(chunks, [2]int{start, i})
+			start, length = i, 0
+		}
+		if i > start {
+			length++
+		}
+		length += lineLen
+	}
+	if start < len(lines) {
+		chunks = append(chunks, [2]int{start, len(lines)})
+	}
+
+	return chunks
+}
+
+// fitLine returns line and its plain text, or the plain text truncated to limit
+// UTF-16 code units when line is longer. The truncated line loses its
+// formatting, so that no tag or escape sequence is cut in half.
+func fitLine(f telegramFormatter, line, plain string, limit int) (string, string) {
+	if utf16Len(line) <= limit {
+		return line, plain
+	}
+	for n := limit; ; {
+		truncated := truncateUTF16(plain, n)
+		text := f.Text(truncated)
+		length := utf16Len(text)
+		if length <= limit {
+			return text, truncated
+		}
+		// Escaping lengthens the text, shrink it in proportion
+		if shorter := n * limit / length; shorter < n {
+			n = shorter
+		} else {
+			n--
+		}
+	}
+}
+
+// truncateUTF16 shortens s to at most limit UTF-16 code units, ellipsis
+// included, without splitting a character.
+func truncateUTF16(s string, limit int) string {
+	if utf16Len(s) <= limit {
+		return s
+	}
+	n := 0
+	for i, r := range s {
+		size := 1
+		if r >= 0x10000 {
+			size = 2
+		}
+		if n+size+1 > limit {
+			return s[:i] + "…"
+		}
+		n += size
+	}
+	return s
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
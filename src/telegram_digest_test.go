@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testReport(deleted int) *CleanupReport {
+	report := NewCleanupReport(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+	report.TotalNamespaces = deleted + 2
+	report.Duration = 42 * time.Second
+	for i := 0; i < deleted; i++ {
+		report.AddDeleted(DeletedNamespace{
+			Name:     fmt.Sprintf("preview-%03d", i),
+			Age:      8*24*time.Hour + 3*time.Hour,
+			Releases: []string{"api", "web"},
+		})
+	}
+	report.AddSkipped("preview-sensitive", "waiting for approval")
+	report.AddFailed("preview-broken", fmt.Errorf("timeout waiting for namespace deletion"))
+	return report
+}
+
+func TestDigestLines(t *testing.T) {
+	text := strings.Join(digestLines(newTelegramFormatter(ParseModeMarkdown), testReport(2)), "\n")
+
+	expected := []string{
+		"Cleanup Digest",
+		"Deleted: 2",
+		"• `preview-000` (age 8d 3h) — releases: `api`, `web`",
+		"• `preview-sensitive` — waiting for approval",
+		"• `preview-broken` — timeout waiting for namespace deletion",
+	}
+	for _, part := range expected {
+		if !strings.Contains(text, part) {
+			t.Errorf("Expected digest to contain %q, got:\n%s", part, text)
+		}
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	tests := []struct {
+		age      time.Duration
+		expected string
+	}{
+		{10 * time.Minute, "<1h"},
+		{5 * time.Hour, "5h"},
+		{48 * time.Hour, "2d"},
+		{8*24*time.Hour + 3*time.Hour, "8d 3h"},
+	}
+
+	for _, test := range tests {
+		if result := formatAge(test.age); result != test.expected {
+			t.Errorf("formatAge(%s) = %s, expected %s", test.age, result, test.expected)
+		}
+	}
+}
+
+func TestChunkLines(t *testing.T) {
+	var lines []string
+	for i := 0; i < 500; i++ {
+		lines = append(lines, fmt.Sprintf("🗑️ line %03d", i))
+	}
+
+	chunks := chunkLines(lines, 1000)
+	if len(chunks) < 2 {
+		t.Fatalf("Expected lines to be split, got %d chunks", len(chunks))
+	}
+
+	next := 0
+	for i, chunk := range chunks {
+		if chunk[0] != next {
+			t.Errorf("Chunk %d starts at line %d, expected %d", i, chunk[0], next)
+		}
+		next = chunk[1]
+
+		if length := utf16Len(strings.Join(lines[chunk[0]:chunk[1]], "\n")); length > 1000 {
+			t.Errorf("Chunk %d is %d UTF-16 units long", i, length)
+		}
+	}
+	if next != len(lines) {
+		t.Errorf("Expected chunks to cover all %d lines, covered %d", len(lines), next)
+	}
+
+	long := []string{"short", strings.Repeat("😀", 30), "short"}
+	if chunks := chunkLines(long, 7); len(chunks) != 3 {
+		t.Errorf("Expected an overlong line to get its own chunk, got %v", chunks)
+	}
+}
+
+func TestSendDigestSplitsLongReports(t *testing.T) {
+	api := newFakeTelegramAPI(t, okHandler)
+	client := api.client()
+	client.config.Digest = TelegramDigestConfig{Enabled: true, MaxMessages: 10}
+
+	if err := client.SendDigest(testReport(100)); err != nil {
+		t.Fatalf("SendDigest failed: %v", err)
+	}
+
+	if len(api.requests) < 2 {
+		t.Fatalf("Expected digest to be split into several messages, got %d", len(api.requests))
+	}
+	for _, request := range api.requests {
+		if !strings.HasSuffix(request.Path, "/sendMessage") {
+			t.Errorf("Expected only sendMessage requests, got %s", request.Path)
+		}
+		if text, _ := request.Body["text"].(string); utf16Len(text) > telegramMessageLimit {
+			t.Errorf("Message exceeds Telegram limit: %d", utf16Len(text))
+		}
+	}
+}
+
+func TestSendDigestAttachesVeryLongReports(t *testing.T) {
+	var documents int
+	api := newFakeTelegramAPI(t, func(method string, body map[string]interface{}) (int, string) {
+		if method == "sendDocument" {
+			documents++
+		}
+		return http.StatusOK, `{"ok":true,"result":{"message_id":1}}`
+	})
+	client := api.client()
+	client.config.Digest = TelegramDigestConfig{Enabled: true, MaxMessages: 1}
+
+	if err := client.SendDigest(testReport(200)); err != nil {
+		t.Fatalf("SendDigest failed: %v", err)
+	}
+
+	if len(api.requests) != 2 || documents != 1 {
+		t.Fatalf("Expected a header message and one document, got %d requests and %d documents", len(api.requests), documents)
+	}
+	if text, _ := api.requests[0].Body["text"].(string); !strings.Contains(text, "Full report attached") {
+		t.Errorf("Expected header to mention the attachment, got %q", text)
+	}
+}
+
+func TestDigestSuppressesPerEventMessages(t *testing.T) {
+	api := newFakeTelegramAPI(t, okHandler)
+	client := api.client()
+	client.config.Digest = TelegramDigestConfig{Enabled: true}
+
+	_ = client.SendNamespaceDeleted("test-ns", time.Hour)
+	_ = client.SendHelmReleaseDeleted("test-release", "test-ns")
+
+	if len(api.requests) != 0 {
+		t.Errorf("Expected no per-event messages in digest mode, got %d", len(api.requests))
+	}
+}
+
+func TestDigestSuppressesRunErrorsOnly(t *testing.T) {
+	api := newFakeTelegramAPI(t, okHandler)
+	client := api.client()
+	client.config.Digest = TelegramDigestConfig{Enabled: true}
+
+	_ = client.Notify(NotificationEvent{Type: EventError, RunID: "run-1", Namespace: "preview-1", Message: "failed", Error: "boom"})
+	if len(api.requests) != 0 {
+		t.Errorf("Expected namespace failure in a run to be left to the digest, got %d messages", len(api.requests))
+	}
+
+	_ = client.Notify(NotificationEvent{Type: EventError, Message: "Failed to list namespaces", Error: "boom"})
+	if len(api.requests) != 1 {
+		t.Errorf("Expected errors outside of a namespace to be sent, got %d messages", len(api.requests))
+	}
+}
+
+func TestFitLineTruncatesOverlongLines(t *testing.T) {
+	f := newTelegramFormatter(ParseModeHTML)
+	plain := "• preview-1 — " + strings.Repeat("<😀>", 2000)
+	line := "• " + f.Code("preview-1") + f.Text(" — "+strings.Repeat("<😀>", 2000))
+
+	text, plainText := fitLine(f, line, plain, telegramMessageLimit)
+	if length := utf16Len(text); length > telegramMessageLimit || length < telegramMessageLimit/2 {
+		t.Errorf("Expected the line to fill one message, got %d UTF-16 units", length)
+	}
+	if utf16Len(plainText) > telegramMessageLimit || !strings.HasSuffix(plainText, "…") {
+		t.Errorf("Expected the plain text to be truncated, got %d UTF-16 units", utf16Len(plainText))
+	}
+	if !strings.HasSuffix(text, "&gt;…") && !strings.HasSuffix(text, "😀…") && !strings.HasSuffix(text, "&lt;…") {
+		t.Errorf("Expected the line to be cut between characters, got %q", text[len(text)-20:])
+	}
+
+	if text, _ := fitLine(f, "short", "short", telegramMessageLimit); text != "short" {
+		t.Errorf("Expected a short line to be kept, got %q", text)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"html"
+	"net/http"
+	"strings"
+)
+
+const (
+	ParseModeMarkdown   = "Markdown"
+	ParseModeMarkdownV2 = "MarkdownV2"
+	ParseModeHTML       = "HTML"
+	ParseModePlain      = ""
+)
+
+var (
+	markdownEscaper   = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+	markdownV2Escaper = strings.NewReplacer(
+		"\\", "\\\\", "_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)",
+		"~", "\\~", "`", "\\`", ">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-", "=", "\\=",
+		"|", "\\|", "{", "\\{", "}", "\\}", ".", "\\.", "!", "\\!")
+	markdownV2CodeEscaper = strings.NewReplacer("\\", "\\\\", "`", "\\`")
+)
+
+// telegramFormatter renders message fragments for a Telegram parse mode, escaping
+// every interpolated value so that names like my_app or error strings with
+// backticks cannot break the markup.
+type telegramFormatter struct {
+	mode string
+}
+
+// newTelegramFormatter returns a formatter for the parse mode. Unknown modes are
+// treated as plain text.
+func newTelegramFormatter(mode string) telegramFormatter {
+	switch strings.ToLower(mode) {
+	case "markdown":
+		return telegramFormatter{mode: ParseModeMarkdown}
+	case "markdownv2":
+		return telegramFormatter{mode: ParseModeMarkdownV2}
+	case "html":
+		return telegramFormatter{mode: ParseModeHTML}
+	default:
+		return telegramFormatter{mode: ParseModePlain}
+	}
+}
+
+// ParseMode is the value to send as parse_mode.
+func (f telegramFormatter) ParseMode() string {
+	return f.mode
+}
+
+// Text escapes s so it is rendered literally.
+func (f telegramFormatter) Text(s string) string {
+	switch f.mode {
+	case ParseModeMarkdown:
+		return markdownEscaper.Replace(s)
+	case ParseModeMarkdownV2:
+		return markdownV2Escaper.Replace(s)
+	case ParseModeHTML:
+		return html.EscapeString(s)
+	default:
+		return s
+	}
+}
+
+// Bold renders s in bold.
+func (f telegramFormatter) Bold(s string) string {
+	switch f.mode {
+	case ParseModeMarkdown:
+		// Legacy Markdown cannot escape inside entities, so drop the markers
+		return "*" + strings.ReplaceAll(s, "*", "") + "*"
+	case ParseModeMarkdownV2:
+		return "*" + markdownV2Escaper.Replace(s) + "*"
+	case ParseModeHTML:
+		return "<b>" + html.EscapeString(s) + "</b>"
+	default:
+		return s
+	}
+}
+
+// Code renders s as inline code.
+func (f telegramFormatter) Code(s string) string {
+	switch f.mode {
+	case ParseModeMarkdown:
+		// Legacy Markdown has no way to escape a backtick inside code
+		return "`" + strings.ReplaceAll(s, "`", "'") + "`"
+	case ParseModeMarkdownV2:
+		return "`" + markdownV2CodeEscaper.Replace(s) + "`"
+	case ParseModeHTML:
+		return "<code>" + html.EscapeString(s) + "</code>"
+	default:
+		return s
+	}
+}
+
+// Field renders an "icon label: value" line where value is already formatted.
+func (f telegramFormatter) Field(icon, label, value string) string {
+	return icon + " " + f.Text(label+": ") + value
+}
+
+// Title renders an "icon title" heading line.
+func (f telegramFormatter) Title(icon, title string) string {
+	return icon + " " + f.Bold(title)
+}
+
+// telegramRenderer produces a message body for a formatter.
+type telegramRenderer func(f telegramFormatter) string
+
+// isParseError reports whether Telegram rejected a message because of its markup.
+func isParseError(err error) bool {
+	var apiErr *TelegramAPIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	description := strings.ToLower(apiErr.Description)
+	return strings.Contains(description, "can't parse entities") ||
+		strings.Contains(description, "can't find end of")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestTelegramFormatterEscaping(t *testing.T) {
+	tests := []struct {
+		mode     string
+		render   telegramRenderer
+		expected string
+	}{
+		{ParseModeMarkdown, func(f telegramFormatter) string { return f.Text("my_app *v1* [beta]") }, "my\\_app \\*v1\\* \\[beta]"},
+		{ParseModeMarkdown, func(f telegramFormatter) string { return f.Code("a`b_c") }, "`a'b_c`"},
+		{ParseModeMarkdown, func(f telegramFormatter) string { return f.Bold("**Summary**") }, "*Summary*"},
+		{ParseModeMarkdownV2, func(f telegramFormatter) string { return f.Text("my-app.v1 (test)!") }, "my\\-app\\.v1 \\(test\\)\\!"},
+		{ParseModeMarkdownV2, func(f telegramFormatter) string { return f.Code("a`b\\c_d") }, "`a\\`b\\\\c_d`"},
+		{ParseModeHTML, func(f telegramFormatter) string { return f.Text("<b>&</b>") }, "&lt;b&gt;&amp;&lt;/b&gt;"},
+		{ParseModeHTML, func(f telegramFormatter) string { return f.Code("a<b") }, "<code>a&lt;b</code>"},
+		{ParseModePlain, func(f telegramFormatter) string { return f.Field("🗑️", "Namespace", f.Code("my_app")) }, "🗑️ Namespace: my_app"},
+	}
+
+	for _, test := range tests {
+		if result := test.render(newTelegramFormatter(test.mode)); result != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.mode, test.expected, result)
+		}
+	}
+}
+
+func TestNewTelegramFormatterModes(t *testing.T) {
+	tests := map[string]string{
+		"markdown":   ParseModeMarkdown,
+		"MarkdownV2": ParseModeMarkdownV2,
+		"html":       ParseModeHTML,
+		"":           ParseModePlain,
+		"unknown":    ParseModePlain,
+	}
+
+	for mode, expected := range tests {
+		if result := newTelegramFormatter(mode).ParseMode(); result != expected {
+			t.Errorf("newTelegramFormatter(%q) = %q, expected %q", mode, result, expected)
+		}
+	}
+}
+
+func TestIsParseError(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{&TelegramAPIError{StatusCode: http.StatusBadRequest, Description: "Bad Request: can't parse entities: Can't find end of the entity"}, true},
+		{&TelegramAPIError{StatusCode: http.StatusBadRequest, Description: "Bad Request: chat not found"}, false},
+		{&TelegramAPIError{StatusCode: http.StatusTooManyRequests, Description: "can't parse entities"}, false},
+		{&testError{message: "can't parse entities"}, false},
+	}
+
+	for _, test := range tests {
+		if result := isParseError(test.err); result != test.expected {
+			t.Errorf("isParseError(%v) = %v, expected %v", test.err, result, test.expected)
+		}
+	}
+}
+
+func TestTelegramEscapesNamespaceNames(t *testing.T) {
+	api := newFakeTelegramAPI(t, okHandler)
+
+	if err := api.client().SendNamespaceDeleted("my_app", 0); err != nil {
+		t.Fatalf("SendNamespaceDeleted failed: %v", err)
+	}
+
+	text, _ := api.requests[0].Body["text"].(string)
+	if !strings.Contains(text, "`my_app`") {
+		t.Errorf("Expected namespace in code span, got %q", text)
+	}
+}
+
+func TestTelegramFallsBackToPlainText(t *testing.T) {
+	api := newFakeTelegramAPI(t, func(method string, body map[string]interface{}) (int, string) {
+		if _, ok := body["parse_mode"]; ok {
+			return http.StatusBadRequest, `{"ok":false,"error_code":400,"description":"Bad Request: can't parse entities"}`
+		}
+		return http.StatusOK, `{"ok":true,"result":{"message_id":1}}`
+	})
+
+	if err := api.client().SendError("deletion failed", &testError{message: "bad *markup_"}); err != nil {
+		t.Fatalf("Expected plain-text resend to succeed, got %v", err)
+	}
+
+	if len(api.requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(api.requests))
+	}
+	text, _ := api.requests[1].Body["text"].(string)
+	if !strings.Contains(text, "bad *markup_") || strings.Contains(text, "\\") {
+		t.Errorf("Expected unescaped plain text, got %q", text)
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type TelegramQueueConfig struct {
+	Enabled        bool          `json:"enabled"`
+	Size           int           `json:"size"`
+	ChatInterval   time.Duration `json:"chat_interval"`
+	MaxRetries     int           `json:"max_retries"`
+	InitialBackoff time.Duration `json:"initial_backoff"`
+	MaxBackoff     time.Duration `json:"max_backoff"`
+	SpoolDir       string        `json:"spool_dir"`
+}
+
+type queuedMessage struct {
+	ID         string            `json:"id"`
+	Message    TelegramMessage   `json:"message"`
+	PlainText  string            `json:"plain_text,omitempty"`
+	Document   *TelegramDocument `json:"document,omitempty"`
+	Attempts   int               `json:"attempts"`
+	EnqueuedAt time.Time         `json:"enqueued_at"`
+}
+
+func (m *queuedMessage) chatID() string {
+	if m.Document != nil {
+		return m.Document.ChatID
+	}
+	return m.Message.ChatID
+}
+
+// telegramDeliverer sends queued items to the Bot API.
+type telegramDeliverer interface {
+	deliver(ctx context.Context, message TelegramMessage) error
+	deliverDocument(ctx context.Context, document TelegramDocument) error
+}
+
+// TelegramQueue delivers messages asynchronously, in FIFO order per chat. It
+// spaces out messages per chat, honors retry_after from Telegram, backs off
+// exponentially on 5xx and network errors and can spool pending messages to
+// disk. A chat that waits never holds up the messages of other chats.
+type TelegramQueue struct {
+	config *TelegramQueueConfig
+	logger *logrus.Logger
+	sender telegramDeliverer
+
+	mu       sync.Mutex
+	items    []*queuedMessage
+	closing  bool
+	sequence int64
+	// readyAt is when the next message to a chat may be sent
+	readyAt map[string]time.Time
+
+	wake    chan struct{}
+	done    chan struct{}
+	stopCtx context.Context
+	stop    context.CancelFunc
+}
+
+func NewTelegramQueue(config *TelegramQueueConfig, logger *logrus.Logger, sender telegramDeliverer) (*TelegramQueue, error) {
+	if config.Size <= 0 {
+		config.Size = 1000
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 5 * time.Minute
+	}
+
+	stopCtx, stop := context.WithCancel(context.Background())
+	q := &TelegramQueue{
+		config:  config,
+		logger:  logger,
+		sender:  sender,
+		readyAt: map[string]time.Time{},
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stopCtx: stopCtx,
+		stop:    stop,
+	}
+
+	if config.SpoolDir != "" {
+		if err := os.MkdirAll(config.SpoolDir, 0o700); err != nil {
+			stop()
+			return nil, fmt.Errorf("failed to create telegram spool directory: %v", err)
+		}
+		if err := q.loadSpool(); err != nil {
+			stop()
+			return nil, err
+		}
+	}
+
+	go q.run()
+	return q, nil
+}
+
+// Enqueue schedules a message for delivery.
+func (q *TelegramQueue) Enqueue(message TelegramMessage) error {
+	return q.enqueue(&queuedMessage{Message: message, PlainText: message.plainText})
+}
+
+// EnqueueDocument schedules a document upload for delivery.
+func (q *TelegramQueue) EnqueueDocument(document TelegramDocument) error {
+	return q.enqueue(&queuedMessage{Document: &document})
+}
+
+func (q *TelegramQueue) enqueue(item *queuedMessage) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closing {
+		return fmt.Errorf("telegram queue is closed")
+	}
+	if len(q.items) >= q.config.Size {
+		return fmt.Errorf("telegram queue is full (%d messages)", len(q.items))
+	}
+
+	q.sequence++
+	item.ID = fmt.Sprintf("%020d-%06d", time.Now().UnixNano(), q.sequence)
+	item.EnqueuedAt = time.Now()
+	if err := q.spool(item); err != nil {
+		q.logger.Warnf("Failed to spool telegram message: %v", err)
+	}
+	q.items = append(q.items, item)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Len returns the number of messages waiting for delivery.
+func (q *TelegramQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// Close stops accepting messages and drains the queue until ctx is done.
+// Undelivered messages stay in the spool directory, if one is configured.
+func (q *TelegramQueue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	q.closing = true
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		q.stop()
+		<-q.done
+	}
+
+	if pending := q.Len(); pending > 0 {
+		if q.config.SpoolDir != "" {
+			return fmt.Errorf("%d telegram messages not delivered, kept in %s", pending, q.config.SpoolDir)
+		}
+		return fmt.Errorf("%d telegram messages not delivered", pending)
+	}
+	return nil
+}
+
+func (q *TelegramQueue) run() {
+	defer close(q.done)
+
+	for {
+		item := q.next()
+		if item == nil {
+			return
+		}
+		q.deliver(item)
+	}
+}
+
+// next blocks until the oldest message of some chat is ready to be sent. It
+// returns nil once the queue is closed and empty, or when the drain deadline
+// has passed.
+func (q *TelegramQueue) next() *queuedMessage {
+	for {
+		if q.stopCtx.Err() != nil {
+			return nil
+		}
+
+		q.mu.Lock()
+		item, wait := q.ready(time.Now())
+		closing := q.closing
+		empty := len(q.items) == 0
+		q.mu.Unlock()
+
+		if item != nil {
+			return item
+		}
+		if closing && empty {
+			return nil
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-q.wake:
+		case <-timeout:
+		case <-q.stopCtx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// ready returns the oldest message of the first chat that is ready at now, or
+// how long until one is. Only the oldest message of each chat is considered,
+// keeping the order within a chat. The caller must hold q.mu.
+func (q *TelegramQueue) ready(now time.Time) (*queuedMessage, time.Duration) {
+	var wait time.Duration
+	seen := map[string]bool{}
+	for _, item := range q.items {
+		chatID := item.chatID()
+		if seen[chatID] {
+			continue
+		}
+		seen[chatID] = true
+
+		until := q.readyAt[chatID].Sub(now)
+		if until <= 0 {
+			return item, 0
+		}
+		if wait == 0 || until < wait {
+			wait = until
+		}
+	}
+	return nil, wait
+}
+
+// deliver sends item once. A failed message stays at the head of its chat
+// until its retry delay has passed.
+func (q *TelegramQueue) deliver(item *queuedMessage) {
+	chatID := item.chatID()
+
+	var err error
+	if item.Document != nil {
+		err = q.sender.deliverDocument(q.stopCtx, *item.Document)
+	} else {
+		err = q.sender.deliver(q.stopCtx, item.Message)
+	}
+	q.delay(chatID, q.config.ChatInterval)
+
+	if err == nil {
+		q.remove(item)
+		q.logger.Debug("Telegram message delivered from queue")
+		return
+	}
+
+	if q.stopCtx.Err() != nil {
+		return
+	}
+
+	item.Attempts++
+	delay, retry := retryDelay(err, item.Attempts, q.config.InitialBackoff, q.config.MaxBackoff)
+	if !retry || (q.config.MaxRetries > 0 && item.Attempts > q.config.MaxRetries) {
+		q.logger.Errorf("Dropping telegram message after %d attempts: %v", item.Attempts, err)
+		q.remove(item)
+		return
+	}
+
+	q.logger.Warnf("Telegram delivery failed (attempt %d), retrying in %s: %v", item.Attempts, delay, err)
+	if err := q.spool(item); err != nil {
+		q.logger.Warnf("Failed to spool telegram message: %v", err)
+	}
+	q.delay(chatID, delay)
+}
+
+// delay holds back the next message to a chat for at least d.
+func (q *TelegramQueue) delay(chatID string, d time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if readyAt := time.Now().Add(d); readyAt.After(q.readyAt[chatID]) {
+		q.readyAt[chatID] = readyAt
+	}
+}
+
+func (q *TelegramQueue) remove(item *queuedMessage) {
+	q.mu.Lock()
+	for i, queued := range q.items {
+		if queued == item {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+	q.mu.Unlock()
+
+	if q.config.SpoolDir != "" {
+		if err := os.Remove(q.spoolPath(item)); err != nil && !os.IsNotExist(err) {
+			q.logger.Warnf("Failed to remove spooled telegram message: %v", err)
+		}
+	}
+}
+
+func (q *TelegramQueue) spoolPath(item *queuedMessage) string {
+	return filepath.Join(q.config.SpoolDir, item.ID+".json")
+}
+
+func (q *TelegramQueue) spool(item *queuedMessage) error {
+	if q.config.SpoolDir == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	tmp := q.spoolPath(item) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.spoolPath(item))
+}
+
+func (q *TelegramQueue) loadSpool() error {
+	entries, err := os.ReadDir(q.config.SpoolDir)
+	if err != nil {
+		return fmt.Errorf("failed to read telegram spool directory: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(q.config.SpoolDir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			q.logger.Warnf("Failed to read spooled telegram message %s: %v", name, err)
+			continue
+		}
+
+		var item queuedMessage
+		if err := json.Unmarshal(data, &item); err != nil {
+			q.logger.Warnf("Discarding corrupt spooled telegram message %s: %v", name, err)
+			_ = os.Remove(path)
+			continue
+		}
+		item.Message.plainText = item.PlainText
+		q.items = append(q.items, &item)
+	}
+
+	if len(q.items) > 0 {
+		q.logger.Infof("Restored %d undelivered telegram messages from %s", len(q.items), q.config.SpoolDir)
+	}
+	return nil
+}
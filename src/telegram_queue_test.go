@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// recordingSender fails with the queued errors first and then succeeds.
+type recordingSender struct {
+	mu       sync.Mutex
+	errs     []error
+	attempts []time.Time
+	sent     []TelegramMessage
+}
+
+func (s *recordingSender) deliver(ctx context.Context, message TelegramMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts = append(s.attempts, time.Now())
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return err
+	}
+	s.sent = append(s.sent, message)
+	return nil
+}
+
+func (s *recordingSender) deliverDocument(ctx context.Context, document TelegramDocument) error {
+	return s.deliver(ctx, TelegramMessage{ChatID: document.ChatID, Text: document.FileName})
+}
+
+func (s *recordingSender) sentCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sent)
+}
+
+func testQueueConfig() *TelegramQueueConfig {
+	return &TelegramQueueConfig{
+		Enabled:        true,
+		MaxRetries:     5,
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+	}
+}
+
+func TestTelegramQueueDrainsOnClose(t *testing.T) {
+	sender := &recordingSender{}
+	queue, err := NewTelegramQueue(testQueueConfig(), logrus.New(), sender)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := queue.Enqueue(TelegramMessage{ChatID: "chat", Text: "message"}); err != nil {
+			t.Fatalf("Failed to enqueue: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := queue.Close(ctx); err != nil {
+		t.Fatalf("Expected queue to drain, got %v", err)
+	}
+
+	if sender.sentCount() != 5 {
+		t.Errorf("Expected 5 messages sent, got %d", sender.sentCount())
+	}
+
+	if err := queue.Enqueue(TelegramMessage{ChatID: "chat", Text: "late"}); err == nil {
+		t.Error("Expected enqueue to fail after close")
+	}
+}
+
+func TestTelegramQueueChatRateLimit(t *testing.T) {
+	config := testQueueConfig()
+	config.ChatInterval = 50 * time.Millisecond
+
+	sender := &recordingSender{}
+	queue, err := NewTelegramQueue(config, logrus.New(), sender)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_ = queue.Enqueue(TelegramMessage{ChatID: "chat", Text: "message"})
+	}
+	if err := queue.Close(context.Background()); err != nil {
+		t.Fatalf("Expected queue to drain, got %v", err)
+	}
+
+	for i := 1; i < len(sender.attempts); i++ {
+		if gap := sender.attempts[i].Sub(sender.attempts[i-1]); gap < config.ChatInterval {
+			t.Errorf("Expected at least %s between messages to one chat, got %s", config.ChatInterval, gap)
+		}
+	}
+}
+
+func TestTelegramQueueRetries(t *testing.T) {
+	sender := &recordingSender{errs: []error{
+		errors.New("connection reset"),
+		&TelegramAPIError{Method: "sendMessage", StatusCode: http.StatusBadGateway},
+		&TelegramAPIError{Method: "sendMessage", StatusCode: http.StatusTooManyRequests, RetryAfter: time.Second},
+	}}
+	queue, err := NewTelegramQueue(testQueueConfig(), logrus.New(), sender)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	start := time.Now()
+	_ = queue.Enqueue(TelegramMessage{ChatID: "chat", Text: "message"})
+	if err := queue.Close(context.Background()); err != nil {
+		t.Fatalf("Expected queue to drain, got %v", err)
+	}
+
+	if sender.sentCount() != 1 || len(sender.attempts) != 4 {
+		t.Errorf("Expected 1 message after 4 attempts, got %d after %d", sender.sentCount(), len(sender.attempts))
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected retry_after of 1s to be honored, finished after %s", elapsed)
+	}
+}
+
+func TestTelegramQueueDropsPermanentErrors(t *testing.T) {
+	sender := &recordingSender{errs: []error{
+		&TelegramAPIError{Method: "sendMessage", StatusCode: http.StatusBadRequest, Description: "chat not found"},
+	}}
+	queue, err := NewTelegramQueue(testQueueConfig(), logrus.New(), sender)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	_ = queue.Enqueue(TelegramMessage{ChatID: "chat", Text: "dropped"})
+	_ = queue.Enqueue(TelegramMessage{ChatID: "chat", Text: "delivered"})
+	if err := queue.Close(context.Background()); err != nil {
+		t.Fatalf("Expected queue to drain, got %v", err)
+	}
+
+	if len(sender.attempts) != 2 || sender.sentCount() != 1 || sender.sent[0].Text != "delivered" {
+		t.Errorf("Expected 400 to be dropped without retry, got attempts=%d sent=%v", len(sender.attempts), sender.sent)
+	}
+}
+
+func TestTelegramQueueSpoolSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	config := testQueueConfig()
+	config.SpoolDir = dir
+	config.InitialBackoff = time.Hour
+	config.MaxBackoff = time.Hour
+
+	failing := &recordingSender{errs: []error{errors.New("network is unreachable")}}
+	queue, err := NewTelegramQueue(config, logrus.New(), failing)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	_ = queue.Enqueue(TelegramMessage{ChatID: "chat", Text: "first"})
+	_ = queue.Enqueue(TelegramMessage{ChatID: "chat", Text: "second"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := queue.Close(ctx); err == nil {
+		t.Fatal("Expected undelivered messages to be reported")
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 spooled messages, got %d", len(entries))
+	}
+
+	// A new queue picks up the spooled messages in order
+	sender := &recordingSender{}
+	restarted, err := NewTelegramQueue(testQueueConfigWithSpool(dir), logrus.New(), sender)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+	if err := restarted.Close(context.Background()); err != nil {
+		t.Fatalf("Expected restored messages to be delivered, got %v", err)
+	}
+
+	if sender.sentCount() != 2 || sender.sent[0].Text != "first" || sender.sent[1].Text != "second" {
+		t.Errorf("Expected spooled messages in order, got %v", sender.sent)
+	}
+
+	entries, _ = os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("Expected spool to be empty after delivery, got %d files", len(entries))
+	}
+}
+
+func testQueueConfigWithSpool(dir string) *TelegramQueueConfig {
+	config := testQueueConfig()
+	config.SpoolDir = dir
+	return config
+}
+
+func TestTelegramClientUsesQueue(t *testing.T) {
+	api := newFakeTelegramAPI(t, okHandler)
+	client := NewTelegramClient(&TelegramConfig{
+		Enabled:  true,
+		BotToken: "test-token",
+		ChatID:   "test-chat-id",
+		APIURL:   api.server.URL,
+		Queue:    *testQueueConfig(),
+	}, logrus.New())
+
+	if client.queue == nil {
+		t.Fatal("Expected delivery queue to be started")
+	}
+
+	if err := client.SendMessage("queued"); err != nil {
+		t.Fatalf("Expected message to be queued, got %v", err)
+	}
+	if err := client.Close(context.Background()); err != nil {
+		t.Fatalf("Expected queue to drain, got %v", err)
+	}
+
+	if len(api.requests) != 1 || api.requests[0].Body["text"] != "queued" {
+		t.Errorf("Expected queued message to reach the API, got %v", api.requests)
+	}
+}
+
+func TestTelegramQueueRetryDoesNotStallOtherChats(t *testing.T) {
+	sender := &recordingSender{errs: []error{
+		&TelegramAPIError{Method: "sendMessage", StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour},
+	}}
+	queue, err := NewTelegramQueue(testQueueConfig(), logrus.New(), sender)
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+
+	_ = queue.Enqueue(TelegramMessage{ChatID: "limited", Text: "first"})
+	_ = queue.Enqueue(TelegramMessage{ChatID: "limited", Text: "second"})
+	_ = queue.Enqueue(TelegramMessage{ChatID: "other", Text: "message"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for sender.sentCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := queue.Close(ctx); err == nil {
+		t.Error("Expected the rate limited chat to stay undelivered")
+	}
+	if len(sender.sent) != 1 || sender.sent[0].ChatID != "other" {
+		t.Errorf("Expected only the other chat to be delivered, got %+v", sender.sent)
+	}
+	if queue.Len() != 2 {
+		t.Errorf("Expected both messages of the rate limited chat to wait, got %d pending", queue.Len())
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"path"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// TelegramDestination is a chat and, for forum supergroups, the topic to post into.
+type TelegramDestination struct {
+	ChatID          string `json:"chat_id"`
+	MessageThreadID int    `json:"message_thread_id"`
+}
+
+// TelegramRoute sends the events of matching namespaces to their own chat. A
+// namespace matches when it satisfies Selector and its name matches one of the
+// Namespaces glob patterns; an empty condition matches everything.
+type TelegramRoute struct {
+	Name            string   `json:"name"`
+	Selector        string   `json:"selector"`
+	Namespaces      []string `json:"namespaces"`
+	ChatID          string   `json:"chat_id"`
+	MessageThreadID int      `json:"message_thread_id"`
+
+	selector labels.Selector
+}
+
+// compileTelegramRoutes validates the routes and parses their selectors.
+func compileTelegramRoutes(routes []TelegramRoute) ([]TelegramRoute, error) {
+	compiled := make([]TelegramRoute, 0, len(routes))
+	for i, route := range routes {
+		name := route.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+
+		if route.ChatID == "" {
+			return nil, fmt.Errorf("telegram route %s has no chat_id", name)
+		}
+		if route.Selector == "" && len(route.Namespaces) == 0 {
+			return nil, fmt.Errorf("telegram route %s needs a selector or namespace patterns", name)
+		}
+
+		selector, err := labels.Parse(route.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q in telegram route %s: %v", route.Selector, name, err)
+		}
+		for _, pattern := range route.Namespaces {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid namespace pattern %q in telegram route %s: %v", pattern, name, err)
+			}
+		}
+
+		route.Name = name
+		route.selector = selector
+		compiled = append(compiled, route)
+	}
+	return compiled, nil
+}
+
+// Matches reports whether a namespace belongs to the route.
+func (r *TelegramRoute) Matches(namespace string, namespaceLabels map[string]string) bool {
+	if r.selector != nil && !r.selector.Matches(labels.Set(namespaceLabels)) {
+		return false
+	}
+	if len(r.Namespaces) == 0 {
+		return true
+	}
+	for _, pattern := range r.Namespaces {
+		if matched, _ := path.Match(pattern, namespace); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *TelegramRoute) destination() TelegramDestination {
+	return TelegramDestination{ChatID: r.ChatID, MessageThreadID: r.MessageThreadID}
+}
+
+// LoadRoutes validates the configured routes. Call it once at startup.
+func (tc *TelegramClient) LoadRoutes() error {
+	routes, err := compileTelegramRoutes(tc.config.Routes)
+	if err != nil {
+		return err
+	}
+	tc.routes = routes
+	return nil
+}
+
+// defaultDestination is where events that no route claims are sent.
+func (tc *TelegramClient) defaultDestination() TelegramDestination {
+	return TelegramDestination{ChatID: tc.config.ChatID, MessageThreadID: tc.config.MessageThreadID}
+}
+
+// routeIndex returns the index of the first route matching a namespace, or -1
+// if it goes to the default destination.
+func (tc *TelegramClient) routeIndex(namespace string, namespaceLabels map[string]string) int {
+	for i := range tc.routes {
+		if tc.routes[i].Matches(namespace, namespaceLabels) {
+			return i
+		}
+	}
+	return -1
+}
+
+// destination picks the chat for an event: errors go to the errors route if one
+// is set, namespace events to the first matching route, the rest to the default chat.
+func (tc *TelegramClient) destination(event NotificationEvent) TelegramDestination {
+	if event.Type == EventError && tc.config.ErrorsRoute.ChatID != "" {
+		return tc.config.ErrorsRoute
+	}
+	if event.Namespace != "" {
+		if i := tc.routeIndex(event.Namespace, event.Labels); i >= 0 {
+			return tc.routes[i].destination()
+		}
+	}
+	return tc.defaultDestination()
+}
+
+// SendCleanupReport sends the summary or digest of a cleanup run split per
+// route, so each chat only sees its own namespaces. The default chat gets the
+// namespaces no route claims.
+func (tc *TelegramClient) SendCleanupReport(report *CleanupReport, clusterName string) error {
+	if tc.config == nil {
+		return nil
+	}
+
+	var errs []error
+	for i := -1; i < len(tc.routes); i++ {
+		index := i
+		part := report.Filter(func(name string, namespaceLabels map[string]string) bool {
+			return tc.routeIndex(name, namespaceLabels) == index
+		})
+
+		destination := tc.defaultDestination()
+		if index >= 0 {
+			// Teams without namespaces in this run get no summary
+			if part.TotalNamespaces == 0 {
+				continue
+			}
+			destination = tc.routes[index].destination()
+		}
+
+		var err error
+		if tc.DigestEnabled() {
+			err = tc.sendDigest(destination, part)
+		} else {
+			err = tc.notify(destination, NotificationEvent{
+				Type:              EventCleanupSummary,
+				ClusterName:       clusterName,
+				RunID:             report.RunID,
+				TotalNamespaces:   part.TotalNamespaces,
+				DeletedNamespaces: len(part.Deleted),
+				Duration:          part.Duration,
+			})
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("chat %s: %v", destination.ChatID, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send cleanup report: %v", errs)
+	}
+	return nil
+}
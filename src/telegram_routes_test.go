@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func routedClient(t *testing.T, api *fakeTelegramAPI) *TelegramClient {
+	client := api.client()
+	client.config.Routes = []TelegramRoute{
+		{Name: "web", Selector: "team=web", ChatID: "web-chat", MessageThreadID: 7},
+		{Name: "previews", Namespaces: []string{"preview-*"}, ChatID: "preview-chat"},
+	}
+	client.config.ErrorsRoute = TelegramDestination{ChatID: "ops-chat", MessageThreadID: 3}
+	if err := client.LoadRoutes(); err != nil {
+		t.Fatalf("LoadRoutes failed: %v", err)
+	}
+	return client
+}
+
+func TestCompileTelegramRoutesValidation(t *testing.T) {
+	tests := [][]TelegramRoute{
+		{{Selector: "team=web"}},
+		{{ChatID: "chat"}},
+		{{Selector: "team in (web", ChatID: "chat"}},
+		{{Namespaces: []string{"preview-["}, ChatID: "chat"}},
+	}
+
+	for _, routes := range tests {
+		if _, err := compileTelegramRoutes(routes); err == nil {
+			t.Errorf("Expected validation error for %+v", routes)
+		}
+	}
+}
+
+func TestTelegramRouteDestination(t *testing.T) {
+	client := routedClient(t, newFakeTelegramAPI(t, okHandler))
+
+	tests := []struct {
+		event    NotificationEvent
+		expected TelegramDestination
+	}{
+		{NotificationEvent{Type: EventNamespaceDeleted, Namespace: "web-1", Labels: map[string]string{"team": "web"}}, TelegramDestination{ChatID: "web-chat", MessageThreadID: 7}},
+		{NotificationEvent{Type: EventNamespaceDeleted, Namespace: "preview-1", Labels: map[string]string{"team": "web"}}, TelegramDestination{ChatID: "web-chat", MessageThreadID: 7}},
+		{NotificationEvent{Type: EventHelmReleaseDeleted, Namespace: "preview-1"}, TelegramDestination{ChatID: "preview-chat"}},
+		{NotificationEvent{Type: EventNamespaceDeleted, Namespace: "other"}, TelegramDestination{ChatID: "test-chat-id"}},
+		{NotificationEvent{Type: EventError, Namespace: "web-1", Labels: map[string]string{"team": "web"}}, TelegramDestination{ChatID: "ops-chat", MessageThreadID: 3}},
+		{NotificationEvent{Type: EventStartup}, TelegramDestination{ChatID: "test-chat-id"}},
+	}
+
+	for _, test := range tests {
+		if result := client.destination(test.event); result != test.expected {
+			t.Errorf("%s in %s: expected %+v, got %+v", test.event.Type, test.event.Namespace, test.expected, result)
+		}
+	}
+}
+
+func TestTelegramNotifySendsToRoute(t *testing.T) {
+	api := newFakeTelegramAPI(t, okHandler)
+	client := routedClient(t, api)
+
+	if err := client.Notify(NotificationEvent{Type: EventNamespaceDeleted, Namespace: "web-1", Labels: map[string]string{"team": "web"}}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	body := api.requests[0].Body
+	if body["chat_id"] != "web-chat" || body["message_thread_id"] != float64(7) {
+		t.Errorf("Expected message in web-chat topic 7, got %v", body)
+	}
+}
+
+func TestSendCleanupReportSplitsPerRoute(t *testing.T) {
+	api := newFakeTelegramAPI(t, okHandler)
+	client := routedClient(t, api)
+
+	report := NewCleanupReport(time.Now())
+	report.AddChecked("web-1", map[string]string{"team": "web"})
+	report.AddChecked("web-2", map[string]string{"team": "web"})
+	report.AddChecked("other", nil)
+	report.AddDeleted(DeletedNamespace{Name: "web-1"})
+	report.AddFailed("other", fmt.Errorf("boom"))
+
+	if err := client.SendCleanupReport(report, "prod"); err != nil {
+		t.Fatalf("SendCleanupReport failed: %v", err)
+	}
+
+	// The previews route has no namespaces in this run and gets no summary
+	if len(api.requests) != 2 {
+		t.Fatalf("Expected 2 summaries, got %d", len(api.requests))
+	}
+
+	summaries := map[string]string{}
+	for _, request := range api.requests {
+		chatID, _ := request.Body["chat_id"].(string)
+		summaries[chatID], _ = request.Body["text"].(string)
+	}
+	if text := summaries["web-chat"]; !strings.Contains(text, "Total namespaces checked: 2") || !strings.Contains(text, "Namespaces deleted: 1") {
+		t.Errorf("Unexpected web summary: %q", text)
+	}
+	if text := summaries["test-chat-id"]; !strings.Contains(text, "Total namespaces checked: 1") || !strings.Contains(text, "Namespaces deleted: 0") {
+		t.Errorf("Unexpected default summary: %q", text)
+	}
+}
+
+func TestCleanupReportFilter(t *testing.T) {
+	report := testReport(3)
+	report.AddChecked("preview-000", map[string]string{"team": "web"})
+
+	filtered := report.Filter(func(name string, labels map[string]string) bool {
+		return labels["team"] == "web"
+	})
+
+	if filtered.TotalNamespaces != 1 || len(filtered.Deleted) != 1 || filtered.Deleted[0].Name != "preview-000" {
+		t.Errorf("Unexpected filtered report: %+v", filtered)
+	}
+	if len(filtered.Skipped) != 0 || len(filtered.Failed) != 0 {
+		t.Errorf("Expected skipped and failed namespaces of other teams to be dropped, got %+v", filtered)
+	}
+}
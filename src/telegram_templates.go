@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// TelegramTemplatesConfig overrides the message templates. An inline template
+// wins over a <event>.tmpl file in Dir, which wins over the built-in default.
+type TelegramTemplatesConfig struct {
+	Dir                string `json:"dir"`
+	Startup            string `json:"startup"`
+	RunStarted         string `json:"run_started"`
+	NamespaceExpiring  string `json:"namespace_expiring"`
+	NamespaceDeleted   string `json:"namespace_deleted"`
+	HelmReleaseDeleted string `json:"helm_release_deleted"`
+	CleanupSummary     string `json:"cleanup_summary"`
+	Error              string `json:"error"`
+	Warning            string `json:"warning"`
+}
+
+// defaultTelegramTemplates are the built-in message texts.
+var defaultTelegramTemplates = map[string]string{
+	EventStartup: `{{ title "🚀" "kube-ns-gc Started" }}
+
+{{ field "🕐" "Time" (text (time .Time)) }}
+📋 {{ text "Service is now monitoring namespaces for cleanup" }}`,
+
+	EventRunStarted: `{{ title "▶️" "Cleanup Started" }}
+
+{{ field "🆔" "Run" (code .RunID) }}
+{{ field "🕐" "Time" (text (time .Time)) }}`,
+
+	EventNamespaceExpiring: `{{ title "⏳" "Namespace Expiring" }}
+
+{{ field "📦" "Namespace" (code .Namespace) }}
+{{ field "🗓️" "Deletion after" (text (time .ExpiresAt)) }}
+{{ field "⏰" "Age" (text (round .Age "1m")) }}`,
+
+	EventNamespaceDeleted: `{{ title "🗑️" "Namespace Deleted" }}
+
+{{ field "📦" "Namespace" (code .Namespace) }}
+{{ field "⏰" "Age" (text (round .Age "1m")) }}
+{{ field "🕐" "Time" (text (time .Time)) }}`,
+
+	EventHelmReleaseDeleted: `{{ if .DryRun }}{{ title "🧪" "Helm Release Would Be Deleted" }}{{ else }}{{ title "🧹" "Helm Release Deleted" }}{{ end }}
+
+{{ field "📦" "Release" (code .Release) }}
+{{ field "🏠" "Namespace" (code .Namespace) }}
+{{ with .ReleaseInfo }}{{ if .Chart }}{{ field "📊" "Chart" (code .ChartRef) }}
+{{ end }}{{ if .AppVersion }}{{ field "🏷️" "App version" (text .AppVersion) }}
+{{ end }}{{ field "🔢" "Revision" (text (printf "%d (%s)" .Version .Status)) }}
+{{ field "🚀" "Last deployed" (text (time .LastDeployed)) }}
+{{ if .Description }}{{ field "📝" "Description" (text .Description) }}
+{{ end }}{{ if .KeptResources }}{{ field "📌" "Kept resources" (text .KeptSummary) }}
+{{ end }}{{ end }}{{ if .Message }}{{ field "📏" "Rule" (text .Message) }}
+{{ end }}{{ field "🕐" "Time" (text (time .Time)) }}`,
+
+	EventCleanupSummary: `{{ title "📊" "Cleanup Summary" }}
+
+{{ field "🔍" "Total namespaces checked" (text (printf "%d" .TotalNamespaces)) }}
+{{ field "🗑️" "Namespaces deleted" (text (printf "%d" .DeletedNamespaces)) }}
+{{ field "⏱️" "Cleanup duration" (text (round .Duration "1s")) }}
+{{ field "🕐" "Time" (text (time .Time)) }}`,
+
+	EventError: `{{ title "❌" "Error" }}
+
+{{ field "📝" "Message" (text .Message) }}
+{{ field "🔍" "Error" (code .Error) }}
+{{ field "🕐" "Time" (text (time .Time)) }}`,
+
+	EventWarning: `{{ title "⚠️" "Warning" }}
+
+{{ field "📝" "Message" (text .Message) }}
+{{ if .Release }}{{ field "📦" "Release" (code .Release) }}
+{{ end }}{{ if .Namespace }}{{ field "🏠" "Namespace" (code .Namespace) }}
+{{ end }}{{ field "🔍" "Error" (code .Error) }}
+{{ field "🕐" "Time" (text (time .Time)) }}`,
+}
+
+var (
+	builtinTemplatesOnce sync.Once
+	builtinTemplates     *TelegramTemplates
+	builtinTemplatesErr  error
+)
+
+// builtinTelegramTemplates returns the parsed default templates.
+func builtinTelegramTemplates() (*TelegramTemplates, error) {
+	builtinTemplatesOnce.Do(func() {
+		builtinTemplates, builtinTemplatesErr = LoadTelegramTemplates(&TelegramTemplatesConfig{})
+	})
+	return builtinTemplates, builtinTemplatesErr
+}
+
+// TelegramTemplates holds the parsed message template of every event type.
+type TelegramTemplates struct {
+	templates map[string]*template.Template
+}
+
+// telegramTemplateFuncs returns the template functions bound to a formatter.
+// Values must go through text, code or bold to be escaped for the parse mode.
+func telegramTemplateFuncs(f telegramFormatter) template.FuncMap {
+	return template.FuncMap{
+		"text":  f.Text,
+		"code":  f.Code,
+		"bold":  f.Bold,
+		"title": f.Title,
+		"field": f.Field,
+		"age":   formatAge,
+		"join":  strings.Join,
+		"time": func(t time.Time) string {
+			return t.Format("2006-01-02 15:04:05 MST")
+		},
+		"round": func(d time.Duration, unit string) (string, error) {
+			m, err := time.ParseDuration(unit)
+			if err != nil {
+				return "", err
+			}
+			return d.Round(m).String(), nil
+		},
+	}
+}
+
+// LoadTelegramTemplates parses the configured templates and checks that each
+// renders for a sample event in every parse mode, so mistakes surface at startup.
+func LoadTelegramTemplates(config *TelegramTemplatesConfig) (*TelegramTemplates, error) {
+	inline := map[string]string{
+		EventStartup:            config.Startup,
+		EventRunStarted:         config.RunStarted,
+		EventNamespaceExpiring:  config.NamespaceExpiring,
+		EventNamespaceDeleted:   config.NamespaceDeleted,
+		EventHelmReleaseDeleted: config.HelmReleaseDeleted,
+		EventCleanupSummary:     config.CleanupSummary,
+		EventError:              config.Error,
+		EventWarning:            config.Warning,
+	}
+
+	t := &TelegramTemplates{templates: make(map[string]*template.Template)}
+	for eventType, text := range defaultTelegramTemplates {
+		if inline[eventType] != "" {
+			text = inline[eventType]
+		} else if config.Dir != "" {
+			data, err := os.ReadFile(filepath.Join(config.Dir, eventType+".tmpl"))
+			if err == nil {
+				text = string(data)
+			} else if !os.IsNotExist(err) {
+				return nil, fmt.Errorf("failed to read %s template: %v", eventType, err)
+			}
+		}
+
+		tmpl, err := template.New(eventType).Funcs(telegramTemplateFuncs(newTelegramFormatter(ParseModePlain))).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s template: %v", eventType, err)
+		}
+		t.templates[eventType] = tmpl
+	}
+
+	sample := sampleNotificationEvent()
+	for eventType := range t.templates {
+		sample.Type = eventType
+		for _, mode := range []string{ParseModePlain, ParseModeMarkdown, ParseModeMarkdownV2, ParseModeHTML} {
+			if _, err := t.Render(newTelegramFormatter(mode), sample); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return t, nil
+}
+
+// Render executes the template for event.Type with values escaped by f.
+func (t *TelegramTemplates) Render(f telegramFormatter, event NotificationEvent) (string, error) {
+	tmpl, ok := t.templates[event.Type]
+	if !ok {
+		return "", fmt.Errorf("no template for event type %q", event.Type)
+	}
+
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		return "", fmt.Errorf("failed to clone %s template: %v", event.Type, err)
+	}
+
+	var out strings.Builder
+	if err := tmpl.Funcs(telegramTemplateFuncs(f)).Execute(&out, event); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %v", event.Type, err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// sampleNotificationEvent has every field set so validation exercises all of them.
+func sampleNotificationEvent() NotificationEvent {
+	return NotificationEvent{
+		Time:        time.Now(),
+		ClusterName: "cluster",
+		RunID:       "run",
+		Namespace:   "preview-1",
+		Labels:      map[string]string{"team": "web"},
+		Annotations: map[string]string{"owner": "web@example.com"},
+		Age:         8 * 24 * time.Hour,
+		Policy:      PolicyNamespaceMaxAge,
+		ExpiresAt:   time.Now().Add(24 * time.Hour),
+		Release:     "api",
+		Releases:    []string{"api", "web"},
+		ReleaseInfo: &HelmRelease{
+			Name:          "api",
+			Namespace:     "preview-1",
+			Status:        "deployed",
+			Version:       3,
+			Chart:         "api",
+			ChartVersion:  "1.2.0",
+			AppVersion:    "2.0.1",
+			FirstDeployed: time.Now().Add(-8 * 24 * time.Hour),
+			LastDeployed:  time.Now().Add(-24 * time.Hour),
+			Description:   "Upgrade complete",
+			KeptResources: []KeptResource{{APIVersion: "v1", Kind: "PersistentVolumeClaim", Name: "data"}},
+		},
+		TotalNamespaces:   10,
+		DeletedNamespaces: 3,
+		Duration:          time.Minute,
+		Message:           "message",
+		Error:             "error",
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultTemplatesKeepMessageTexts(t *testing.T) {
+	templates, err := LoadTelegramTemplates(&TelegramTemplatesConfig{})
+	if err != nil {
+		t.Fatalf("Failed to load default templates: %v", err)
+	}
+
+	event := NotificationEvent{
+		Type:      EventNamespaceDeleted,
+		Time:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Namespace: "my_app",
+		Age:       2*time.Hour + 10*time.Second,
+	}
+	text, err := templates.Render(newTelegramFormatter(ParseModeMarkdown), event)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	expected := "🗑️ *Namespace Deleted*\n\n" +
+		"📦 Namespace: `my_app`\n" +
+		"⏰ Age: 2h0m0s\n" +
+		"🕐 Time: 2024-01-02 03:04:05 UTC"
+	if text != expected {
+		t.Errorf("Expected %q, got %q", expected, text)
+	}
+}
+
+func TestDefaultHelmReleaseTemplateShowsRelease(t *testing.T) {
+	templates, err := LoadTelegramTemplates(&TelegramTemplatesConfig{})
+	if err != nil {
+		t.Fatalf("Failed to load default templates: %v", err)
+	}
+
+	deployed := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	event := NotificationEvent{
+		Type:      EventHelmReleaseDeleted,
+		Time:      deployed.Add(24 * time.Hour),
+		Namespace: "dev",
+		Release:   "api",
+		ReleaseInfo: &HelmRelease{
+			Name:         "api",
+			Status:       "failed",
+			Version:      2,
+			Chart:        "api",
+			ChartVersion: "1.2.0",
+			AppVersion:   "2.0.1",
+			LastDeployed: deployed,
+		},
+	}
+	text, err := templates.Render(newTelegramFormatter(ParseModePlain), event)
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	expected := "🧹 Helm Release Deleted\n\n" +
+		"📦 Release: api\n" +
+		"🏠 Namespace: dev\n" +
+		"📊 Chart: api-1.2.0\n" +
+		"🏷️ App version: 2.0.1\n" +
+		"🔢 Revision: 2 (failed)\n" +
+		"🚀 Last deployed: 2024-01-02 03:04:05 UTC\n" +
+		"🕐 Time: 2024-01-03 03:04:05 UTC"
+	if text != expected {
+		t.Errorf("Expected %q, got %q", expected, text)
+	}
+}
+
+func TestInlineTemplateOverridesDefault(t *testing.T) {
+	templates, err := LoadTelegramTemplates(&TelegramTemplatesConfig{
+		NamespaceDeleted: `{{ text .ClusterName }}/{{ code .Namespace }} team={{ text (index .Labels "team") }} releases={{ join .Releases "," }} run={{ .RunID }}`,
+	})
+	if err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+
+	text, err := templates.Render(newTelegramFormatter(ParseModeHTML), NotificationEvent{
+		Type:        EventNamespaceDeleted,
+		ClusterName: "prod<1>",
+		RunID:       "20240102-030405",
+		Namespace:   "preview-1",
+		Labels:      map[string]string{"team": "web"},
+		Releases:    []string{"api", "web"},
+	})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+
+	expected := "prod&lt;1&gt;/<code>preview-1</code> team=web releases=api,web run=20240102-030405"
+	if text != expected {
+		t.Errorf("Expected %q, got %q", expected, text)
+	}
+}
+
+func TestTemplatesLoadedFromDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, EventError+".tmpl"), []byte(`failed: {{ text .Message }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := LoadTelegramTemplates(&TelegramTemplatesConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+
+	text, err := templates.Render(newTelegramFormatter(ParseModePlain), NotificationEvent{Type: EventError, Message: "boom"})
+	if err != nil || text != "failed: boom" {
+		t.Errorf("Expected template from dir, got %q (%v)", text, err)
+	}
+
+	// Events without a file keep the default
+	text, err = templates.Render(newTelegramFormatter(ParseModePlain), NotificationEvent{Type: EventStartup})
+	if err != nil || !strings.Contains(text, "kube-ns-gc Started") {
+		t.Errorf("Expected default startup template, got %q (%v)", text, err)
+	}
+}
+
+func TestInvalidTemplatesFailValidation(t *testing.T) {
+	tests := []TelegramTemplatesConfig{
+		{Startup: `{{ .Time`},
+		{NamespaceDeleted: `{{ .NoSuchField }}`},
+		{CleanupSummary: `{{ unknownFunc }}`},
+		{Error: `{{ round .Duration "soon" }}`},
+	}
+
+	for _, config := range tests {
+		if _, err := LoadTelegramTemplates(&config); err == nil {
+			t.Errorf("Expected validation error for %+v", config)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// WebhookEventVersion is bumped on incompatible changes to the webhook payload.
+const WebhookEventVersion = "v1"
+
+// Webhook request headers.
+const (
+	WebhookSignatureHeader = "X-Kube-Ns-Gc-Signature"
+	WebhookEventHeader     = "X-Kube-Ns-Gc-Event"
+	WebhookDeliveryHeader  = "X-Kube-Ns-Gc-Delivery"
+)
+
+type WebhookConfig struct {
+	Enabled bool   `json:"enabled"`
+	URL     string `json:"url"`
+	// Secret signs the request body with HMAC-SHA256
+	Secret  string            `json:"secret"`
+	Events  []string          `json:"events"`
+	Headers map[string]string `json:"headers"`
+	Timeout time.Duration     `json:"timeout"`
+	RetryConfig
+}
+
+// WebhookEvent is the JSON body POSTed for every event.
+type WebhookEvent struct {
+	Version     string            `json:"version"`
+	ID          string            `json:"id"`
+	Type        string            `json:"type"`
+	Time        time.Time         `json:"time"`
+	Cluster     string            `json:"cluster,omitempty"`
+	RunID       string            `json:"run_id,omitempty"`
+	Namespace   *WebhookNamespace `json:"namespace,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	ReleaseInfo *HelmRelease      `json:"release_info,omitempty"`
+	DryRun      bool              `json:"dry_run,omitempty"`
+	Summary     *WebhookSummary   `json:"summary,omitempty"`
+	Message     string            `json:"message,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Failure     string            `json:"failure,omitempty"`
+}
+
+type WebhookNamespace struct {
+	Name        string            `json:"name"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	AgeSeconds  int64             `json:"age_seconds,omitempty"`
+	Policy      string            `json:"policy,omitempty"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"`
+	Releases    []string          `json:"releases,omitempty"`
+}
+
+type WebhookSummary struct {
+	TotalNamespaces int                       `json:"total_namespaces"`
+	DurationSeconds float64                   `json:"duration_seconds"`
+	Deleted         []WebhookDeletedNamespace `json:"deleted"`
+	Skipped         []WebhookNamespaceReason  `json:"skipped"`
+	Failed          []WebhookNamespaceReason  `json:"failed"`
+}
+
+type WebhookDeletedNamespace struct {
+	Name       string   `json:"name"`
+	AgeSeconds int64    `json:"age_seconds"`
+	Releases   []string `json:"releases,omitempty"`
+}
+
+type WebhookNamespaceReason struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// WebhookNotifier POSTs signed JSON events to an HTTP endpoint.
+type WebhookNotifier struct {
+	config *WebhookConfig
+	logger *logrus.Logger
+	client *http.Client
+	queue  *deliveryQueue
+}
+
+func NewWebhookNotifier(config *WebhookConfig, logger *logrus.Logger) (*WebhookNotifier, error) {
+	endpoint, err := url.Parse(config.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid webhook url %q", config.URL)
+	}
+
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &WebhookNotifier{
+		config: config,
+		logger: logger,
+		client: &http.Client{Timeout: timeout},
+		queue:  newDeliveryQueue("webhook", config.RetryConfig, logger),
+	}, nil
+}
+
+// Notify queues event for delivery if the webhook subscribes to its type.
+func (w *WebhookNotifier) Notify(event NotificationEvent) error {
+	if !w.wants(event.Type) {
+		return nil
+	}
+
+	payload := newWebhookEvent(event)
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook event: %v", err)
+	}
+
+	headers := map[string]string{
+		WebhookEventHeader:    payload.Type,
+		WebhookDeliveryHeader: payload.ID,
+	}
+	for name, value := range w.config.Headers {
+		headers[name] = value
+	}
+	if w.config.Secret != "" {
+		headers[WebhookSignatureHeader] = "sha256=" + signWebhookBody(w.config.Secret, body)
+	}
+
+	return w.queue.Enqueue(payload.Type+" event", func(ctx context.Context) error {
+		return postJSON(ctx, w.client, w.config.URL, body, headers, nil)
+	})
+}
+
+func (w *WebhookNotifier) Close(ctx context.Context) error {
+	return w.queue.Close(ctx)
+}
+
+func (w *WebhookNotifier) wants(eventType string) bool {
+	if len(w.config.Events) == 0 {
+		return true
+	}
+	for _, wanted := range w.config.Events {
+		if wanted == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// signWebhookBody returns the hex HMAC-SHA256 of body keyed with secret.
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newWebhookEvent(event NotificationEvent) WebhookEvent {
+	payload := WebhookEvent{
+		Version:     WebhookEventVersion,
+		ID:          event.ID,
+		Type:        event.Type,
+		Time:        event.Time.UTC(),
+		Cluster:     event.ClusterName,
+		RunID:       event.RunID,
+		Release:     event.Release,
+		ReleaseInfo: event.ReleaseInfo,
+		DryRun:      event.DryRun,
+		Message:     event.Message,
+		Error:       event.Error,
+		Failure:     event.Failure,
+	}
+	if payload.ID == "" {
+		payload.ID = newEventID()
+	}
+	if payload.Time.IsZero() {
+		payload.Time = time.Now().UTC()
+	}
+
+	if event.Namespace != "" {
+		payload.Namespace = &WebhookNamespace{
+			Name:        event.Namespace,
+			Labels:      event.Labels,
+			Annotations: event.Annotations,
+			AgeSeconds:  int64(event.Age.Seconds()),
+			Policy:      event.Policy,
+			Releases:    event.Releases,
+		}
+		if !event.ExpiresAt.IsZero() {
+			expiresAt := event.ExpiresAt.UTC()
+			payload.Namespace.ExpiresAt = &expiresAt
+		}
+	}
+
+	if event.Type == EventCleanupSummary {
+		summary := &WebhookSummary{
+			TotalNamespaces: event.TotalNamespaces,
+			DurationSeconds: event.Duration.Seconds(),
+			Deleted:         []WebhookDeletedNamespace{},
+			Skipped:         []WebhookNamespaceReason{},
+			Failed:          []WebhookNamespaceReason{},
+		}
+		if report := event.Report; report != nil {
+			for _, deleted := range report.Deleted {
+				summary.Deleted = append(summary.Deleted, WebhookDeletedNamespace{
+					Name:       deleted.Name,
+					AgeSeconds: int64(deleted.Age.Seconds()),
+					Releases:   deleted.Releases,
+				})
+			}
+			for _, skipped := range report.Skipped {
+				summary.Skipped = append(summary.Skipped, WebhookNamespaceReason{Name: skipped.Name, Reason: skipped.Reason})
+			}
+			for _, failed := range report.Failed {
+				summary.Failed = append(summary.Failed, WebhookNamespaceReason{Name: failed.Name, Reason: failed.Reason})
+			}
+		}
+		payload.Summary = summary
+	}
+
+	return payload
+}
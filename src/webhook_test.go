@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type webhookRequest struct {
+	Header http.Header
+	Body   []byte
+}
+
+// fakeWebhook answers with the queued statuses in order, then 200.
+type fakeWebhook struct {
+	server   *httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []webhookRequest
+}
+
+func newFakeWebhook(t *testing.T, statuses ...int) *fakeWebhook {
+	hook := &fakeWebhook{statuses: statuses}
+	hook.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		hook.mu.Lock()
+		hook.requests = append(hook.requests, webhookRequest{Header: r.Header.Clone(), Body: body})
+		status := http.StatusOK
+		if len(hook.statuses) > 0 {
+			status, hook.statuses = hook.statuses[0], hook.statuses[1:]
+		}
+		hook.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(hook.server.Close)
+	return hook
+}
+
+func (h *fakeWebhook) received() []webhookRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]webhookRequest(nil), h.requests...)
+}
+
+func newTestWebhookNotifier(t *testing.T, config *WebhookConfig) *WebhookNotifier {
+	config.InitialBackoff = time.Millisecond
+	config.MaxBackoff = 5 * time.Millisecond
+	notifier, err := NewWebhookNotifier(config, logrus.New())
+	if err != nil {
+		t.Fatalf("NewWebhookNotifier failed: %v", err)
+	}
+	return notifier
+}
+
+func closeNotifier(t *testing.T, notifier Notifier) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := notifier.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestWebhookSignsVersionedEvent(t *testing.T) {
+	hook := newFakeWebhook(t)
+	notifier := newTestWebhookNotifier(t, &WebhookConfig{URL: hook.server.URL, Secret: "s3cret"})
+
+	event := NotificationEvent{
+		ID:          "event-1",
+		Type:        EventNamespaceDeleted,
+		Time:        time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		ClusterName: "prod",
+		RunID:       "run-1",
+		Namespace:   "preview-1",
+		Labels:      map[string]string{"team": "web"},
+		Age:         48 * time.Hour,
+		Policy:      PolicyNamespaceMaxAge,
+		Releases:    []string{"api"},
+	}
+	if err := notifier.Notify(event); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	closeNotifier(t, notifier)
+
+	requests := hook.received()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(requests))
+	}
+	request := requests[0]
+
+	if signature := request.Header.Get(WebhookSignatureHeader); signature != "sha256="+signWebhookBody("s3cret", request.Body) {
+		t.Errorf("Unexpected signature %q", signature)
+	}
+	if request.Header.Get(WebhookEventHeader) != EventNamespaceDeleted || request.Header.Get(WebhookDeliveryHeader) != "event-1" {
+		t.Errorf("Unexpected headers: %v", request.Header)
+	}
+
+	var payload WebhookEvent
+	if err := json.Unmarshal(request.Body, &payload); err != nil {
+		t.Fatalf("Invalid payload: %v", err)
+	}
+	if payload.Version != WebhookEventVersion || payload.Type != EventNamespaceDeleted || payload.Cluster != "prod" || payload.RunID != "run-1" {
+		t.Errorf("Unexpected payload: %+v", payload)
+	}
+	if payload.Namespace == nil || payload.Namespace.Name != "preview-1" || payload.Namespace.AgeSeconds != 172800 || payload.Namespace.Labels["team"] != "web" {
+		t.Errorf("Unexpected namespace in payload: %+v", payload.Namespace)
+	}
+}
+
+func TestWebhookSummaryPayload(t *testing.T) {
+	report := testReport(1)
+	payload := newWebhookEvent(NotificationEvent{Type: EventCleanupSummary, TotalNamespaces: 3, Report: report})
+
+	if payload.Summary == nil || payload.Summary.TotalNamespaces != 3 {
+		t.Fatalf("Expected summary in payload, got %+v", payload)
+	}
+	if len(payload.Summary.Deleted) != 1 || len(payload.Summary.Skipped) != 1 || len(payload.Summary.Failed) != 1 {
+		t.Errorf("Unexpected summary: %+v", payload.Summary)
+	}
+}
+
+func TestWebhookRetriesServerErrors(t *testing.T) {
+	hook := newFakeWebhook(t, http.StatusInternalServerError, http.StatusTooManyRequests)
+	notifier := newTestWebhookNotifier(t, &WebhookConfig{URL: hook.server.URL, RetryConfig: RetryConfig{MaxRetries: 5}})
+
+	if err := notifier.Notify(NotificationEvent{Type: EventError, Message: "boom"}); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	closeNotifier(t, notifier)
+
+	requests := hook.received()
+	if len(requests) != 3 {
+		t.Fatalf("Expected 2 retries before success, got %d requests", len(requests))
+	}
+	if requests[0].Header.Get(WebhookDeliveryHeader) != requests[2].Header.Get(WebhookDeliveryHeader) {
+		t.Errorf("Expected retries to reuse the delivery ID")
+	}
+}
+
+func TestWebhookDropsClientErrors(t *testing.T) {
+	hook := newFakeWebhook(t, http.StatusBadRequest)
+	notifier := newTestWebhookNotifier(t, &WebhookConfig{URL: hook.server.URL, RetryConfig: RetryConfig{MaxRetries: 5}})
+
+	_ = notifier.Notify(NotificationEvent{Type: EventError})
+	closeNotifier(t, notifier)
+
+	if requests := hook.received(); len(requests) != 1 {
+		t.Errorf("Expected no retry after 400, got %d requests", len(requests))
+	}
+}
+
+func TestWebhookEventFilter(t *testing.T) {
+	hook := newFakeWebhook(t)
+	notifier := newTestWebhookNotifier(t, &WebhookConfig{URL: hook.server.URL, Events: []string{EventCleanupSummary}})
+
+	_ = notifier.Notify(NotificationEvent{Type: EventNamespaceDeleted, Namespace: "preview-1"})
+	_ = notifier.Notify(NotificationEvent{Type: EventCleanupSummary})
+	closeNotifier(t, notifier)
+
+	requests := hook.received()
+	if len(requests) != 1 || requests[0].Header.Get(WebhookEventHeader) != EventCleanupSummary {
+		t.Errorf("Expected only the summary to be delivered, got %d requests", len(requests))
+	}
+}
+
+func TestWebhookRejectsInvalidURL(t *testing.T) {
+	for _, url := range []string{"", "ftp://example.com", "http://"} {
+		if _, err := NewWebhookNotifier(&WebhookConfig{URL: url}, logrus.New()); err == nil {
+			t.Errorf("Expected error for webhook url %q", url)
+		}
+	}
+}
+
+func TestRetryDelayIsSharedBySinks(t *testing.T) {
+	tests := []struct {
+		err   error
+		delay time.Duration
+		retry bool
+	}{
+		{fmt.Errorf("connection refused"), 4 * time.Second, true},
+		{&HTTPStatusError{StatusCode: 503}, 4 * time.Second, true},
+		{&HTTPStatusError{StatusCode: 429, RetryAfter: 30 * time.Second}, 30 * time.Second, true},
+		{&HTTPStatusError{StatusCode: 400}, 0, false},
+		{&TelegramAPIError{StatusCode: 429, RetryAfter: 7 * time.Second}, 7 * time.Second, true},
+		{&TelegramAPIError{StatusCode: 502}, 4 * time.Second, true},
+		{&TelegramAPIError{StatusCode: 403}, 0, false},
+		{&PermanentError{Err: fmt.Errorf("bad payload")}, 0, false},
+	}
+	for _, test := range tests {
+		delay, retry := retryDelay(test.err, 3, time.Second, time.Minute)
+		if delay != test.delay || retry != test.retry {
+			t.Errorf("%v: expected %s, %v, got %s, %v", test.err, test.delay, test.retry, delay, retry)
+		}
+	}
+	if delay, _ := retryDelay(fmt.Errorf("timeout"), 20, time.Second, time.Minute); delay != time.Minute {
+		t.Errorf("Expected backoff to be capped at 1m, got %s", delay)
+	}
+}
+
+type recordingNotifier struct {
+	events []NotificationEvent
+	err    error
+}
+
+func (n *recordingNotifier) Notify(event NotificationEvent) error {
+	n.events = append(n.events, event)
+	return n.err
+}
+
+func (n *recordingNotifier) Close(ctx context.Context) error {
+	return nil
+}
+
+func TestMultiNotifierFansOut(t *testing.T) {
+	failing := &recordingNotifier{err: &testError{message: "down"}}
+	working := &recordingNotifier{}
+
+	notifier := NewMultiNotifier()
+	notifier.Add("failing", failing)
+	notifier.Add("working", working)
+
+	err := notifier.Notify(NotificationEvent{Type: EventStartup})
+	if err == nil {
+		t.Errorf("Expected error from failing sink")
+	}
+
+	if len(failing.events) != 1 || len(working.events) != 1 {
+		t.Fatalf("Expected every sink to get the event, got %d and %d", len(failing.events), len(working.events))
+	}
+	if id := working.events[0].ID; id == "" || id != failing.events[0].ID || working.events[0].Time.IsZero() {
+		t.Errorf("Expected sinks to share an event ID and time, got %+v", working.events[0])
+	}
+}